@@ -4,57 +4,100 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	_ "sync"
+	"time"
 
-	"github.com/git-sim/tc/app/domain/repo"
 	"github.com/git-sim/tc/app/domain/service"
 	"github.com/git-sim/tc/app/io/rest/handlers"
-	"github.com/git-sim/tc/app/io/storage/ram"
 	"github.com/git-sim/tc/app/usecase"
 )
 
 func main() {
 
 	fmt.Println("Hello from msgserver main()")
-	dbAccounts := ram.NewAccountRepo()
-	dbProfiles := ram.NewProfileRepo()
-	dbFirstNames := ram.NewStringRepo(dbProfiles, ram.EnumFirstName)
-	dbLastNames := ram.NewStringRepo(dbProfiles, ram.EnumLastName)
-	dbBios := ram.NewStringRepo(dbProfiles, ram.EnumBio)
-	dbAviImgs := ram.NewImageRepo(dbProfiles, ram.EnumAvatar)
-	dbBgImgs := ram.NewImageRepo(dbProfiles, ram.EnumBackground)
-	dbMsgs := ram.NewStructRepo()
-	dbPendingMsgs := ram.NewStructRepo()
-	dbFolders := ram.NewStructRepo()
-	//For the folders, pass a func allowing folder repo to be created on demand
-	// still isolates the usecase from knowing the specifics of the repo
-	folderFactoryFn := func() repo.Generic { return ram.NewGenericRepo() }
-
-	accServ := service.NewAccountService(dbAccounts)
+	// STORAGE selects the repos, ram (the default) logged to the DB_PATH directory if it's set
+	// and fsynced per RAM_SYNC, bolt to keep everything in the DB_PATH file or mongo for the
+	// mongodb at DB_PATH
+	db, err := openRepos(os.Getenv("STORAGE"), os.Getenv("DB_PATH"), os.Getenv("RAM_SYNC"))
+	if err != nil {
+		log.Fatal("openRepos:", err)
+	}
+	defer db.close()
+	accServ := service.NewAccountService(db.accounts)
 	sessionUsecase := usecase.NewSessionUsecase(nil, accServ)
-	accUsecase := usecase.NewAccountUsecase(dbAccounts, sessionUsecase, accServ)
-	folUsecase := usecase.NewFoldersUsecase(dbFolders, folderFactoryFn, accServ)
-	msgUsecase := usecase.NewMsgUsecase(dbMsgs, dbPendingMsgs, folUsecase, accServ)
+	// ACCOUNT_IDS=sequential numbers the accounts 1, 2, 3..., by default the ids are random
+	accountIDs := usecase.NewRandomAccountIDs()
+	switch v := os.Getenv("ACCOUNT_IDS"); v {
+	case "", "random":
+	case "sequential":
+		accountIDs = usecase.NewSequentialAccountIDs(db.accounts)
+	default:
+		log.Fatal("ACCOUNT_IDS: expected random or sequential got ", v)
+	}
+	accUsecase := usecase.NewAccountUsecase(db.accounts, sessionUsecase, accServ, accountIDs)
+	folUsecase := usecase.NewFoldersUsecase(db.folders, db.folderFactoryFn, db.mutedFactoryFn, accServ)
+	scheduler := usecase.NewScheduler(usecase.NewRealClock())
+	rulesUsecase := usecase.NewRulesUsecase(db.rules, folUsecase)
+	// MSG_IDS=time gives msg and thread ids ordered by when they're made, MSG_ID_NODE (0-15)
+	// tells apart the servers sharing a db. By default they're counters kept with the repos
+	msgIDGen, err := msgIDs(os.Getenv("MSG_IDS"), os.Getenv("MSG_ID_NODE"), db, folUsecase)
+	if err != nil {
+		log.Fatal("msgIDs:", err)
+	}
+	msgUsecase := usecase.NewMsgUsecase(db.msgs, db.pendingMsgs, db.threads, folUsecase, rulesUsecase, accServ, scheduler,
+		msgIDGen)
+	threadsUsecase := usecase.NewThreadsUsecase(folUsecase)
+	settingsUsecase := usecase.NewSettingsUsecase(db.settings, accServ, scheduler.Clock())
+	searchUsecase := usecase.NewSearchUsecase(folUsecase)
 
 	profUcs := &handlers.ProfileUsecases{} //A struct to collect up the profile usecases
-	profUcs.StrUsecases[handlers.EnumFirstNameUsecase] = usecase.NewProfileStringUsecase(dbFirstNames)
-	profUcs.StrUsecases[handlers.EnumLastNameUsecase] = usecase.NewProfileStringUsecase(dbLastNames)
-	profUcs.StrUsecases[handlers.EnumBioUsecase] = usecase.NewProfileStringUsecase(dbBios)
-	profUcs.ImageUsecases[handlers.EnumAvatarImageUsecase] = usecase.NewProfileImageUsecase(dbAviImgs)
-	profUcs.ImageUsecases[handlers.EnumBgImageUsecase] = usecase.NewProfileImageUsecase(dbBgImgs)
+	profUcs.StrUsecases[handlers.EnumFirstNameUsecase] = usecase.NewProfileStringUsecase(db.firstNames)
+	profUcs.StrUsecases[handlers.EnumLastNameUsecase] = usecase.NewProfileStringUsecase(db.lastNames)
+	profUcs.StrUsecases[handlers.EnumBioUsecase] = usecase.NewProfileStringUsecase(db.bios)
+	profUcs.ImageUsecases[handlers.EnumAvatarImageUsecase] = usecase.NewProfileImageUsecase(db.aviImgs)
+	profUcs.ImageUsecases[handlers.EnumBgImageUsecase] = usecase.NewProfileImageUsecase(db.bgImgs)
 
 	//Initialize internal notifications
-	usecase.InitSubscribers(accServ, folUsecase, accUsecase, dbPendingMsgs)
+	usecase.InitSubscribers(accServ, folUsecase, accUsecase, rulesUsecase, db.pendingMsgs)
+	usecase.InitFolderSubscribers(folUsecase, msgUsecase)
+	usecase.InitRules(rulesUsecase, msgUsecase)
+	usecase.InitSettings(settingsUsecase, rulesUsecase, msgUsecase)
 	usecase.InitAccounts(accUsecase)
+	if err := usecase.InitScheduler(scheduler, msgUsecase, folUsecase); err != nil {
+		log.Fatal("InitScheduler:", err)
+	}
+	if err := usecase.InitSearch(searchUsecase, folUsecase); err != nil {
+		log.Fatal("InitSearch:", err)
+	}
+
+	// Trash entries older than TRASH_RETENTION (a time.Duration, default 30 days) are removed for good
+	trashRetention := 720 * time.Hour
+	if v := os.Getenv("TRASH_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatal("TRASH_RETENTION:", err)
+		}
+		trashRetention = d
+	}
+	stopPurger := usecase.StartTrashPurger(folUsecase, usecase.NewRealClock(), trashRetention, time.Hour)
+	defer stopPurger()
 
 	mux := http.NewServeMux()
 	mux.Handle("/login", handlers.HandleLogin(sessionUsecase, accUsecase))
 	mux.Handle("/logout", handlers.HandleLogout(sessionUsecase))
 	mux.Handle("/account", handlers.HandleAccount(accUsecase))
 	mux.Handle("/accountList", handlers.HandleAccountList(accUsecase))
+	mux.Handle("/accountSettings", handlers.HandleAccountSettings(settingsUsecase, accUsecase))
 	mux.Handle("/profile", handlers.HandleProfile(accUsecase, profUcs))
 	mux.Handle("/message", handlers.HandleMessage(msgUsecase, folUsecase, accUsecase))
 	mux.Handle("/folder", handlers.HandleFolder(folUsecase, msgUsecase, accUsecase))
+	mux.Handle("/folderList", handlers.HandleFolderList(folUsecase, accUsecase))
+	mux.Handle("/thread", handlers.HandleThread(threadsUsecase, accUsecase))
+	mux.Handle("/draft", handlers.HandleDraft(msgUsecase, accUsecase))
+	mux.Handle("/search", handlers.HandleSearch(searchUsecase, accUsecase))
+	mux.Handle("/rule", handlers.HandleRule(rulesUsecase, accUsecase))
+	mux.Handle("/ruleDryRun", handlers.HandleRuleDryRun(rulesUsecase, accUsecase))
 	//mux.Handle("/directory",  handlers.HandleDirectory(dirUsecase))
 
 	listenString := "0.0.0.0:8080"
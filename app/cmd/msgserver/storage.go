@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/io/storage/bolt"
+	"github.com/git-sim/tc/app/io/storage/mdb"
+	"github.com/git-sim/tc/app/io/storage/ram"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// repos the storage the usecases are built on
+type repos struct {
+	accounts        repo.AccountRepo
+	firstNames      repo.StringRepo
+	lastNames       repo.StringRepo
+	bios            repo.StringRepo
+	aviImgs         repo.ImageRepo
+	bgImgs          repo.ImageRepo
+	msgs            repo.Store[entity.MsgIDType, entity.Msg]
+	pendingMsgs     repo.Store[usecase.PendingKeyType, entity.PendingMsgEntry]
+	threads         repo.Store[entity.ThreadIDType, []entity.MsgIDType]
+	idMarks         repo.Store[repo.GenericKeyT, uint64] // high-water marks of the msg and thread ids
+	rules           repo.Store[entity.AccountIDType, usecase.AccountRules]
+	settings        repo.Store[entity.AccountIDType, usecase.AccountSettingsEntry]
+	folders         repo.Store[entity.AccountIDType, usecase.AccountFolders]
+	folderFactoryFn func() repo.IndexedStore[entity.MsgIDType, entity.MsgEntry] // creates the per account folders on demand
+	mutedFactoryFn  func() repo.Store[entity.ThreadIDType, bool]                // and their muted thread sets
+	close           func() error
+}
+
+// openRepos for the storage kind, ram, bolt or mongo. ram with a location keeps a log of
+// the repos in the location directory synced per ramSync, bolt keeps the repos in the file
+// at location, mongo in the "tc" database of the mongodb at the location uri.
+func openRepos(kind string, location string, ramSync string) (*repos, error) {
+	switch kind {
+	case "", "ram":
+		if location != "" {
+			return newRamLogRepos(location, ramSync)
+		}
+		return newRamRepos(), nil
+	case "bolt":
+		if location == "" {
+			location = "msgserver.db"
+		}
+		return newBoltRepos(location)
+	case "mongo":
+		if location == "" {
+			location = "mongodb://localhost:27017"
+		}
+		return newMongoRepos(location, "tc")
+	}
+	return nil, fmt.Errorf("unknown storage %q, expected ram, bolt or mongo", kind)
+}
+
+func newRamRepos() *repos {
+	dbProfiles := ram.NewProfileRepo()
+	return &repos{
+		accounts:        ram.NewAccountRepo(),
+		firstNames:      ram.NewStringRepo(dbProfiles, ram.EnumFirstName),
+		lastNames:       ram.NewStringRepo(dbProfiles, ram.EnumLastName),
+		bios:            ram.NewStringRepo(dbProfiles, ram.EnumBio),
+		aviImgs:         ram.NewImageRepo(dbProfiles, ram.EnumAvatar),
+		bgImgs:          ram.NewImageRepo(dbProfiles, ram.EnumBackground),
+		msgs:            ram.NewStore[entity.MsgIDType, entity.Msg](),
+		pendingMsgs:     ram.NewStore[usecase.PendingKeyType, entity.PendingMsgEntry](),
+		threads:         ram.NewStore[entity.ThreadIDType, []entity.MsgIDType](),
+		idMarks:         ram.NewStore[repo.GenericKeyT, uint64](),
+		rules:           ram.NewStore[entity.AccountIDType, usecase.AccountRules](),
+		settings:        ram.NewStore[entity.AccountIDType, usecase.AccountSettingsEntry](),
+		folders:         ram.NewStore[entity.AccountIDType, usecase.AccountFolders](),
+		folderFactoryFn: ram.IndexedStoreFactory[entity.MsgIDType, entity.MsgEntry](usecase.FolderIndexes...),
+		mutedFactoryFn:  ram.NewStore[entity.ThreadIDType, bool],
+		close:           func() error { return nil },
+	}
+}
+
+// ramLogOptions always (the default) fsyncs every write, never leaves it to the OS, a
+// duration fsyncs that often
+func ramLogOptions(ramSync string) (ram.LogOptions, error) {
+	switch ramSync {
+	case "", "always":
+		return ram.LogOptions{Sync: ram.SyncAlways}, nil
+	case "never":
+		return ram.LogOptions{Sync: ram.SyncNever}, nil
+	}
+	d, err := time.ParseDuration(ramSync)
+	if err != nil || d <= 0 {
+		return ram.LogOptions{}, fmt.Errorf("bad RAM_SYNC %q, expected always, never or a duration", ramSync)
+	}
+	return ram.LogOptions{Sync: ram.SyncInterval, SyncInterval: d}, nil
+}
+
+func newRamLogRepos(dir string, ramSync string) (*repos, error) {
+	opts, err := ramLogOptions(ramSync)
+	if err != nil {
+		return nil, err
+	}
+	l, err := ram.OpenLog(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	dbProfiles := l.NewProfileRepo()
+	return &repos{
+		accounts:        l.NewAccountRepo(),
+		firstNames:      ram.NewStringRepo(dbProfiles, ram.EnumFirstName),
+		lastNames:       ram.NewStringRepo(dbProfiles, ram.EnumLastName),
+		bios:            ram.NewStringRepo(dbProfiles, ram.EnumBio),
+		aviImgs:         ram.NewImageRepo(dbProfiles, ram.EnumAvatar),
+		bgImgs:          ram.NewImageRepo(dbProfiles, ram.EnumBackground),
+		msgs:            ram.OpenStore[entity.MsgIDType, entity.Msg](l, "msgs"),
+		pendingMsgs:     ram.OpenStore[usecase.PendingKeyType, entity.PendingMsgEntry](l, "pendingMsgs"),
+		threads:         ram.OpenStore[entity.ThreadIDType, []entity.MsgIDType](l, "threads"),
+		idMarks:         ram.OpenStore[repo.GenericKeyT, uint64](l, "idMarks"),
+		rules:           ram.OpenStore[entity.AccountIDType, usecase.AccountRules](l, "rules"),
+		settings:        ram.OpenStore[entity.AccountIDType, usecase.AccountSettingsEntry](l, "settings"),
+		folders:         ram.OpenStore[entity.AccountIDType, usecase.AccountFolders](l, "folders"),
+		folderFactoryFn: ram.LogIndexedStoreFactory[entity.MsgIDType, entity.MsgEntry](l, usecase.FolderIndexes...),
+		mutedFactoryFn:  ram.LogStoreFactory[entity.ThreadIDType, bool](l),
+		close:           l.Close,
+	}, nil
+}
+
+func newBoltRepos(path string) (*repos, error) {
+	s, err := bolt.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	dbProfiles := s.NewProfileRepo()
+	return &repos{
+		accounts:        s.NewAccountRepo(),
+		firstNames:      bolt.NewStringRepo(dbProfiles, bolt.EnumFirstName),
+		lastNames:       bolt.NewStringRepo(dbProfiles, bolt.EnumLastName),
+		bios:            bolt.NewStringRepo(dbProfiles, bolt.EnumBio),
+		aviImgs:         bolt.NewImageRepo(dbProfiles, bolt.EnumAvatar),
+		bgImgs:          bolt.NewImageRepo(dbProfiles, bolt.EnumBackground),
+		msgs:            bolt.OpenStore[entity.MsgIDType, entity.Msg](s, "msgs"),
+		pendingMsgs:     bolt.OpenStore[usecase.PendingKeyType, entity.PendingMsgEntry](s, "pendingMsgs"),
+		threads:         bolt.OpenStore[entity.ThreadIDType, []entity.MsgIDType](s, "threads"),
+		idMarks:         bolt.OpenStore[repo.GenericKeyT, uint64](s, "idMarks"),
+		rules:           bolt.OpenStore[entity.AccountIDType, usecase.AccountRules](s, "rules"),
+		settings:        bolt.OpenStore[entity.AccountIDType, usecase.AccountSettingsEntry](s, "settings"),
+		folders:         bolt.OpenStore[entity.AccountIDType, usecase.AccountFolders](s, "folders"),
+		folderFactoryFn: bolt.IndexedStoreFactory[entity.MsgIDType, entity.MsgEntry](s, usecase.FolderIndexes...),
+		mutedFactoryFn:  bolt.StoreFactory[entity.ThreadIDType, bool](s),
+		close:           s.Close,
+	}, nil
+}
+
+func newMongoRepos(uri string, dbName string) (*repos, error) {
+	s, err := mdb.Open(uri, dbName)
+	if err != nil {
+		return nil, err
+	}
+	dbProfiles := s.NewProfileRepo()
+	return &repos{
+		accounts:        s.NewAccountRepo(),
+		firstNames:      mdb.NewStringRepo(dbProfiles, mdb.EnumFirstName),
+		lastNames:       mdb.NewStringRepo(dbProfiles, mdb.EnumLastName),
+		bios:            mdb.NewStringRepo(dbProfiles, mdb.EnumBio),
+		aviImgs:         mdb.NewImageRepo(dbProfiles, mdb.EnumAvatar),
+		bgImgs:          mdb.NewImageRepo(dbProfiles, mdb.EnumBackground),
+		msgs:            mdb.OpenStore[entity.MsgIDType, entity.Msg](s, "msgs"),
+		pendingMsgs:     mdb.OpenStore[usecase.PendingKeyType, entity.PendingMsgEntry](s, "pendingMsgs"),
+		threads:         mdb.OpenStore[entity.ThreadIDType, []entity.MsgIDType](s, "threads"),
+		idMarks:         mdb.OpenStore[repo.GenericKeyT, uint64](s, "idMarks"),
+		rules:           mdb.OpenStore[entity.AccountIDType, usecase.AccountRules](s, "rules"),
+		settings:        mdb.OpenStore[entity.AccountIDType, usecase.AccountSettingsEntry](s, "settings"),
+		folders:         mdb.OpenStore[entity.AccountIDType, usecase.AccountFolders](s, "folders"),
+		folderFactoryFn: mdb.IndexedStoreFactory[entity.MsgIDType, entity.MsgEntry](s, usecase.FolderIndexes...),
+		mutedFactoryFn:  mdb.StoreFactory[entity.ThreadIDType, bool](s),
+		close:           s.Close,
+	}, nil
+}
+
+// msgIDs the generator of the msg and thread ids, msgIDs "time" for ids ordered by when
+// they're made on node, otherwise ("" or "counter") counters kept with the repos
+func msgIDs(kind string, node string, db *repos, folUsecase usecase.FoldersUsecase) (usecase.IDGenerator, error) {
+	switch kind {
+	case "", "counter":
+		return usecase.NewPersistedIDGenerator(db.idMarks, usecase.DefaultIDBlock,
+			usecase.StoredIDs(db.msgs, folUsecase))
+	case "time":
+		n := uint64(0)
+		if node != "" {
+			var err error
+			if n, err = strconv.ParseUint(node, 10, 64); err != nil {
+				return nil, fmt.Errorf("bad MSG_ID_NODE %q", node)
+			}
+		}
+		return usecase.NewTimeIDGenerator(usecase.NewRealClock(), n)
+	}
+	return nil, fmt.Errorf("unknown MSG_IDS %q, expected counter or time", kind)
+}
@@ -1,5 +1,7 @@
 package entity
 
+import "strings"
+
 // Account represents an messaging account. Email should be unique
 type Account struct {
 	id        AccountIDType
@@ -28,6 +30,11 @@ func NewAccount(newid AccountIDType, newemail string) *Account {
 	}
 }
 
+// NormalizeEmail the form emails are looked up by, they match ignoring case and surrounding space
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 func (a *Account) GetID() AccountIDType {
 	return a.id
 }
@@ -36,6 +43,11 @@ func (a *Account) GetEmail() string {
 	return a.email
 }
 
+// SetEmail changes the address, the id stays the same so everything keyed by it follows the account
+func (a *Account) SetEmail(email string) {
+	a.email = email
+}
+
 func (a *Account) GetFirstName() string {
 	return a.FirstName
 }
@@ -33,17 +33,20 @@ type Msg struct {
 	Tid      ThreadIDType
 	SentAt   time.Time
 	SenderID AccountIDType
+	Auto     bool // sent by the system on the user's behalf (rule forwards), rules don't act on these
 	M        MsgBase
 }
 
 // MsgEntry this is the decorated type used in Message Folders (inbox, archive, etc)
 type MsgEntry struct {
-	Mid       MsgIDType
-	ViewedAt  time.Time
-	IsViewed  bool
-	IsStarred bool
-	Folder    string
-	M         Msg
+	Mid         MsgIDType
+	ViewedAt    time.Time
+	IsViewed    bool
+	IsStarred   bool
+	Folder      string
+	DeletedAt   time.Time // set while the entry is in the Trash
+	DeletedFrom int       // folder the entry is restored to from the Trash
+	M           Msg
 }
 
 func NewMsg(msgbase MsgBase) *Msg {
@@ -4,12 +4,18 @@ import (
 	"github.com/git-sim/tc/app/domain/entity"
 )
 
+// AccountRepo stores the accounts by id. Create of an id or email that's already stored
+// returns EsAlreadyExists and leaves the stored account alone. Update of a missing account
+// and the Retrieves of one return EsNotFound, Delete of a missing account isn't an error.
+// Retrieve and RetrieveIDByEmail match the email by its entity.NormalizeEmail form, through
+// an index kept up to date as the emails change. RetrieveAll is sorted by email.
 type AccountRepo interface {
 	Create(a *entity.Account) error
 	Update(a *entity.Account) error
 	Delete(a *entity.Account) error
 
 	Retrieve(email string) (*entity.Account, error)
+	RetrieveIDByEmail(email string) (entity.AccountIDType, error)
 	RetrieveByID(id entity.AccountIDType) (*entity.Account, error)
 	RetrieveCount() (int, error)
 	RetrieveAll() ([]*entity.Account, error)
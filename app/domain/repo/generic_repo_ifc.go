@@ -1,6 +1,9 @@
 package repo
 
 type GenericKeyT uint64
+// Generic stores values of any type by key. Create and Update both store the value replacing
+// what's there, Retrieve of a missing key returns EsNotFound, Delete of a missing key isn't
+// an error. RetrieveFiltered and RetrieveAll return the values in key order.
 type Generic interface {
 	Create(id GenericKeyT, val interface{}) error
 	Update(id GenericKeyT, val interface{}) error
@@ -3,6 +3,7 @@ import (
     "image"
 )
 
+// ImageRepo stores one image field per id, same contract as the StringRepo
 type ImageRepo interface {
     Create(id uint64, val *image.Image) error
     Update(id uint64, val *image.Image) error
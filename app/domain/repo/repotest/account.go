@@ -0,0 +1,221 @@
+package repotest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// TestAccount runs the repo.AccountRepo checks
+func TestAccount(t *testing.T, newRepo func() repo.AccountRepo) {
+	run(t, []check{
+		{"CRUD", func(t *testing.T) { accountCRUD(t, newRepo()) }},
+		{"NotFound", func(t *testing.T) { accountNotFound(t, newRepo()) }},
+		{"EmailIndex", func(t *testing.T) { accountEmailIndex(t, newRepo()) }},
+		{"Conflict", func(t *testing.T) { accountConflict(t, newRepo()) }},
+		{"Order", func(t *testing.T) { accountOrder(t, newRepo()) }},
+		{"Concurrent", func(t *testing.T) { accountConcurrent(t, newRepo()) }},
+	})
+}
+
+func accountCRUD(t *testing.T, ar repo.AccountRepo) {
+	bob := entity.NewAccount(2, "bob@mail.com")
+	alice := entity.NewAccount(1, "alice@mail.com")
+	for _, a := range []*entity.Account{bob, alice} {
+		if err := ar.Create(a); err != nil {
+			t.Fatalf("create %s: %v", a.GetEmail(), err)
+		}
+	}
+	if count, _ := ar.RetrieveCount(); count != 2 {
+		t.Errorf("count expected 2 got %d", count)
+	}
+
+	got, err := ar.Retrieve("bob@mail.com")
+	if err != nil || got.GetID() != 2 {
+		t.Errorf("retrieve bob expected id 2 got %v %v", got, err)
+	}
+	got, err = ar.RetrieveByID(1)
+	if err != nil || got.GetEmail() != "alice@mail.com" {
+		t.Errorf("retrieve id 1 expected alice got %v %v", got, err)
+	}
+
+	alice.FirstName = "Alice"
+	alice.LastName = "Liddell"
+	if err := ar.Update(alice); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	got, _ = ar.RetrieveByID(1)
+	if got == nil || got.GetFirstName() != "Alice" || got.GetLastName() != "Liddell" {
+		t.Errorf("after update expected Alice Liddell got %v", got)
+	}
+	// The repo hands out copies
+	got.FirstName = "changed"
+	if again, _ := ar.RetrieveByID(1); again.GetFirstName() != "Alice" {
+		t.Error("changing a retrieved account changed the stored one")
+	}
+
+	if err := ar.Delete(bob); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if count, _ := ar.RetrieveCount(); count != 1 {
+		t.Errorf("count after delete expected 1 got %d", count)
+	}
+}
+
+func accountNotFound(t *testing.T, ar repo.AccountRepo) {
+	_, err := ar.Retrieve("nobody@mail.com")
+	expectNotFound(t, "retrieve of a missing email", err)
+	_, err = ar.RetrieveByID(7)
+	expectNotFound(t, "retrieve of a missing id", err)
+	_, err = ar.RetrieveIDByEmail("nobody@mail.com")
+	expectNotFound(t, "id of a missing email", err)
+	expectNotFound(t, "update of a missing account", ar.Update(entity.NewAccount(7, "carol@mail.com")))
+
+	carol := entity.NewAccount(7, "carol@mail.com")
+	ar.Create(carol)
+	ar.Delete(carol)
+	_, err = ar.Retrieve("carol@mail.com")
+	expectNotFound(t, "retrieve after delete", err)
+	_, err = ar.RetrieveByID(7)
+	expectNotFound(t, "retrieve by id after delete", err)
+	if err := ar.Delete(carol); err != nil {
+		t.Errorf("delete of a missing account: %v", err)
+	}
+	if err := ar.Create(nil); err == nil {
+		t.Error("create nil expected an error")
+	}
+	all, err := ar.RetrieveAll()
+	if err != nil || all == nil || len(all) != 0 {
+		t.Errorf("all from an empty repo expected an empty slice got %v %v", all, err)
+	}
+}
+
+func accountEmailIndex(t *testing.T, ar repo.AccountRepo) {
+	bob := entity.NewAccount(2, "Bob@Mail.com")
+	ar.Create(bob)
+	ar.Create(entity.NewAccount(3, "carol@mail.com"))
+
+	// The lookups ignore case and surrounding space, the stored email keeps its case
+	if id, err := ar.RetrieveIDByEmail(" bob@mail.COM "); err != nil || id != 2 {
+		t.Errorf("id of bob expected 2 got %d %v", id, err)
+	}
+	if got, err := ar.Retrieve("BOB@MAIL.COM"); err != nil || got.GetEmail() != "Bob@Mail.com" {
+		t.Errorf("retrieve bob expected Bob@Mail.com got %v %v", got, err)
+	}
+
+	// The index follows a change of email
+	renamed := entity.NewAccount(2, "robert@mail.com")
+	if err := ar.Update(renamed); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ar.RetrieveIDByEmail("bob@mail.com")
+	expectNotFound(t, "id of the old email", err)
+	_, err = ar.Retrieve("bob@mail.com")
+	expectNotFound(t, "retrieve of the old email", err)
+	if id, err := ar.RetrieveIDByEmail("Robert@mail.com"); err != nil || id != 2 {
+		t.Errorf("id of the new email expected 2 got %d %v", id, err)
+	}
+
+	ar.Delete(renamed)
+	_, err = ar.RetrieveIDByEmail("robert@mail.com")
+	expectNotFound(t, "id after delete", err)
+	if id, err := ar.RetrieveIDByEmail("carol@mail.com"); err != nil || id != 3 {
+		t.Errorf("id of carol expected 3 got %d %v", id, err)
+	}
+}
+
+func accountConflict(t *testing.T, ar repo.AccountRepo) {
+	bob := entity.NewAccount(2, "bob@mail.com")
+	bob.FirstName = "Bob"
+	if err := ar.Create(bob); err != nil {
+		t.Fatal(err)
+	}
+	// Neither the id nor the email (in any case) can be created twice
+	for _, a := range []*entity.Account{
+		entity.NewAccount(2, "mallory@mail.com"),
+		entity.NewAccount(3, "BOB@mail.com"),
+	} {
+		if err := ar.Create(a); !usecase.CheckEs(err, usecase.EsAlreadyExists) {
+			t.Errorf("create of %d %s expected EsAlreadyExists got %v", a.GetID(), a.GetEmail(), err)
+		}
+	}
+	got, err := ar.RetrieveByID(2)
+	if err != nil || got.GetEmail() != "bob@mail.com" || got.GetFirstName() != "Bob" {
+		t.Errorf("bob expected to be left alone got %v %v", got, err)
+	}
+	if _, err := ar.RetrieveByID(3); err == nil {
+		t.Error("the conflicting email was stored")
+	}
+	if count, _ := ar.RetrieveCount(); count != 1 {
+		t.Errorf("count expected 1 got %d", count)
+	}
+
+	// Nor can another account be updated to it, bob can change his own case
+	carol := entity.NewAccount(3, "carol@mail.com")
+	if err := ar.Create(carol); err != nil {
+		t.Fatal(err)
+	}
+	if err := ar.Update(entity.NewAccount(3, "Bob@mail.com")); !usecase.CheckEs(err, usecase.EsAlreadyExists) {
+		t.Errorf("update to bob's email expected EsAlreadyExists got %v", err)
+	}
+	if got, err := ar.Retrieve("bob@mail.com"); err != nil || got.GetID() != 2 {
+		t.Errorf("bob's email expected to find bob got %v %v", got, err)
+	}
+	if got, err := ar.Retrieve("carol@mail.com"); err != nil || got.GetID() != 3 {
+		t.Errorf("carol's email expected to find carol got %v %v", got, err)
+	}
+	if err := ar.Update(entity.NewAccount(2, "Bob@mail.com")); err != nil {
+		t.Errorf("change of case %v", err)
+	}
+}
+
+func accountOrder(t *testing.T, ar repo.AccountRepo) {
+	// RetrieveAll is sorted by email whatever the ids
+	emails := []string{"dan@mail.com", "bob@mail.com", "erin@mail.com", "alice@mail.com", "carol@mail.com"}
+	for i, email := range emails {
+		if err := ar.Create(entity.NewAccount(entity.AccountIDType(i+1), email)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := ar.RetrieveAll()
+	if err != nil || len(all) != len(emails) {
+		t.Fatalf("all expected %d accounts got %d %v", len(emails), len(all), err)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i-1].GetEmail() >= all[i].GetEmail() {
+			t.Errorf("all not sorted by email at %d: %s, %s", i, all[i-1].GetEmail(), all[i].GetEmail())
+		}
+	}
+}
+
+func accountConcurrent(t *testing.T, ar repo.AccountRepo) {
+	parallel(t, func(w int) error {
+		for i := 0; i < opsPerWorker; i++ {
+			id := entity.AccountIDType(w*opsPerWorker + i + 1)
+			a := entity.NewAccount(id, fmt.Sprintf("user%d@mail.com", id))
+			if err := ar.Create(a); err != nil {
+				return err
+			}
+			a.FirstName = "first"
+			if err := ar.Update(a); err != nil {
+				return err
+			}
+			if _, err := ar.Retrieve(a.GetEmail()); err != nil {
+				return err
+			}
+			if got, err := ar.RetrieveIDByEmail(a.GetEmail()); err != nil || got != id {
+				return fmt.Errorf("id of %s expected %d got %d %v", a.GetEmail(), id, got, err)
+			}
+			if _, err := ar.RetrieveAll(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if count, _ := ar.RetrieveCount(); count != workers*opsPerWorker {
+		t.Errorf("count expected %d got %d", workers*opsPerWorker, count)
+	}
+}
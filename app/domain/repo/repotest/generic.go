@@ -0,0 +1,105 @@
+package repotest
+
+import (
+	"encoding/gob"
+	"fmt"
+	"testing"
+
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+func init() {
+	gob.Register(Value{})
+}
+
+// TestGeneric runs the repo.Store checks against a repo.Generic holding Values
+func TestGeneric(t *testing.T, newRepo func() repo.Generic) {
+	TestStore(t, func() repo.Store[repo.GenericKeyT, Value] {
+		return genericStore{newRepo()}
+	})
+}
+
+// genericStore adapts a repo.Generic to the typed checks, a value that isn't a Value comes
+// back as EsArgConvFail
+type genericStore struct {
+	gr repo.Generic
+}
+
+func toValue(val interface{}) (Value, error) {
+	v, ok := val.(Value)
+	if !ok {
+		return Value{}, usecase.NewEs(usecase.EsArgConvFail, fmt.Sprintf("%T to Value", val))
+	}
+	return v, nil
+}
+
+func (gs genericStore) Create(id repo.GenericKeyT, val Value) error { return gs.gr.Create(id, val) }
+func (gs genericStore) Update(id repo.GenericKeyT, val Value) error { return gs.gr.Update(id, val) }
+func (gs genericStore) Delete(id repo.GenericKeyT) error            { return gs.gr.Delete(id) }
+func (gs genericStore) RetrieveCount() (int, error)                 { return gs.gr.RetrieveCount() }
+
+// Drop a repo.Generic can't be dropped, its Values are deleted by their ID
+func (gs genericStore) Drop() error {
+	vals, err := gs.RetrieveAll()
+	if err != nil {
+		return err
+	}
+	for _, v := range vals {
+		if err := gs.gr.Delete(repo.GenericKeyT(v.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gs genericStore) Retrieve(id repo.GenericKeyT) (Value, error) {
+	val, err := gs.gr.Retrieve(id)
+	if err != nil {
+		return Value{}, err
+	}
+	return toValue(val)
+}
+
+func (gs genericStore) RetrieveFiltered(fn func(Value) bool) ([]Value, error) {
+	var convErr error
+	vals, err := gs.gr.RetrieveFiltered(func(val interface{}) bool {
+		v, err := toValue(val)
+		if err != nil {
+			convErr = err
+			return false
+		}
+		return fn(v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if convErr != nil {
+		return nil, convErr
+	}
+	return gs.toValues(vals)
+}
+
+func (gs genericStore) RetrieveAll() ([]Value, error) {
+	vals, err := gs.gr.RetrieveAll()
+	if err != nil {
+		return nil, err
+	}
+	return gs.toValues(vals)
+}
+
+// toValues keeps a nil slice nil so the empty slice checks still see it
+func (gs genericStore) toValues(vals []interface{}) ([]Value, error) {
+	if vals == nil {
+		return nil, nil
+	}
+	ret := make([]Value, len(vals))
+	for i, val := range vals {
+		v, err := toValue(val)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = v
+	}
+	return ret, nil
+}
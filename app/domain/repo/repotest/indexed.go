@@ -0,0 +1,228 @@
+package repotest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// The indexes the suite asks for, by Name and by the number of Tags
+var testIndexes = []repo.Index[Value]{
+	{Name: "name", Key: func(v Value) string { return v.Name }},
+	{Name: "ntags", Key: func(v Value) string { return fmt.Sprintf("%04d", len(v.Tags)) }},
+}
+
+// TestIndexedStore runs the repo.IndexedStore checks, newStore makes an empty store with the indexes
+func TestIndexedStore(t *testing.T, newStore func(indexes ...repo.Index[Value]) repo.IndexedStore[repo.GenericKeyT, Value]) {
+	newTestStore := func() repo.IndexedStore[repo.GenericKeyT, Value] { return newStore(testIndexes...) }
+	TestStore(t, func() repo.Store[repo.GenericKeyT, Value] { return newTestStore() })
+	run(t, []check{
+		{"QueryOrder", func(t *testing.T) { queryOrder(t, newTestStore()) }},
+		{"QueryPage", func(t *testing.T) { queryPage(t, newTestStore()) }},
+		{"QueryAfter", func(t *testing.T) { queryAfter(t, newTestStore()) }},
+		{"QueryUpdates", func(t *testing.T) { queryUpdates(t, newTestStore()) }},
+		{"QueryConcurrent", func(t *testing.T) { queryConcurrent(t, newTestStore()) }},
+	})
+}
+
+// namedValue a Value with the name and number of tags given
+func namedValue(id uint64, name string, ntags int) Value {
+	v := Value{ID: id, Name: name}
+	for i := 0; i < ntags; i++ {
+		v.Tags = append(v.Tags, fmt.Sprint(i))
+	}
+	return v
+}
+
+// createNamed fills the store, the names have ties broken by id
+func createNamed(t *testing.T, st repo.IndexedStore[repo.GenericKeyT, Value]) {
+	vals := []Value{
+		namedValue(1<<63, "carol", 1), namedValue(5, "alice", 3), namedValue(1<<64-1, "bob", 0),
+		namedValue(0, "carol", 2), namedValue(42, "alice", 1), namedValue(7, "dave", 2),
+	}
+	for _, v := range vals {
+		if err := st.Create(repo.GenericKeyT(v.ID), v); err != nil {
+			t.Fatalf("create %d: %v", v.ID, err)
+		}
+	}
+}
+
+func query(t *testing.T, st repo.IndexedStore[repo.GenericKeyT, Value],
+	q repo.Query[repo.GenericKeyT, Value]) repo.QueryResult[Value] {
+	t.Helper()
+	res, err := st.Query(q)
+	if err != nil {
+		t.Fatalf("query %+v: %v", q, err)
+	}
+	return res
+}
+
+func queryOrder(t *testing.T, st repo.IndexedStore[repo.GenericKeyT, Value]) {
+	createNamed(t, st)
+	const all = 100
+	tests := []struct {
+		index string
+		desc  bool
+		want  []uint64
+	}{
+		{"", false, []uint64{0, 5, 7, 42, 1 << 63, 1<<64 - 1}},
+		{"", true, []uint64{1<<64 - 1, 1 << 63, 42, 7, 5, 0}},
+		{"name", false, []uint64{5, 42, 1<<64 - 1, 0, 1 << 63, 7}},
+		{"name", true, []uint64{7, 1 << 63, 0, 1<<64 - 1, 42, 5}},
+		{"ntags", false, []uint64{1<<64 - 1, 42, 1 << 63, 0, 7, 5}},
+	}
+	for _, tt := range tests {
+		res := query(t, st, repo.Query[repo.GenericKeyT, Value]{Index: tt.index, Desc: tt.desc, Limit: all})
+		if got := valueIDs(res.Vals); !reflect.DeepEqual(got, tt.want) || res.Total != len(tt.want) {
+			t.Errorf("index %q desc %v expected %v total %d got %v total %d",
+				tt.index, tt.desc, tt.want, len(tt.want), got, res.Total)
+		}
+	}
+
+	_, err := st.Query(repo.Query[repo.GenericKeyT, Value]{Index: "nope", Limit: all})
+	if !usecase.CheckEs(err, usecase.EsArgInvalid) {
+		t.Errorf("unknown index expected EsArgInvalid got %v", err)
+	}
+}
+
+func queryPage(t *testing.T, st repo.IndexedStore[repo.GenericKeyT, Value]) {
+	createNamed(t, st)
+
+	res := query(t, st, repo.Query[repo.GenericKeyT, Value]{Index: "name", Offset: 2, Limit: 3})
+	if got := valueIDs(res.Vals); !reflect.DeepEqual(got, []uint64{1<<64 - 1, 0, 1 << 63}) || res.Total != 6 {
+		t.Errorf("offset 2 limit 3 got %v total %d", got, res.Total)
+	}
+	res = query(t, st, repo.Query[repo.GenericKeyT, Value]{Index: "name", Offset: 5, Limit: 3})
+	if got := valueIDs(res.Vals); !reflect.DeepEqual(got, []uint64{7}) {
+		t.Errorf("offset 5 limit 3 got %v", got)
+	}
+	res = query(t, st, repo.Query[repo.GenericKeyT, Value]{Index: "name", Offset: 6, Limit: 3})
+	if res.Vals == nil || len(res.Vals) != 0 || res.Total != 6 {
+		t.Errorf("offset past the end expected an empty page total 6 got %v total %d", res.Vals, res.Total)
+	}
+	res = query(t, st, repo.Query[repo.GenericKeyT, Value]{Index: "name"})
+	if len(res.Vals) != 0 || res.Total != 6 {
+		t.Errorf("limit 0 expected just the total 6 got %v total %d", valueIDs(res.Vals), res.Total)
+	}
+
+	// The total counts what the filter keeps, the page is taken from those
+	twoTags := func(v Value) bool { return len(v.Tags) >= 2 }
+	res = query(t, st, repo.Query[repo.GenericKeyT, Value]{Index: "name", Desc: true, Filter: twoTags, Offset: 1, Limit: 2})
+	if got := valueIDs(res.Vals); !reflect.DeepEqual(got, []uint64{0, 5}) || res.Total != 3 {
+		t.Errorf("filtered page expected [0 5] total 3 got %v total %d", got, res.Total)
+	}
+	res = query(t, st, repo.Query[repo.GenericKeyT, Value]{Filter: func(Value) bool { return false }, Limit: 10})
+	if res.Vals == nil || len(res.Vals) != 0 || res.Total != 0 {
+		t.Errorf("filter keeping nothing expected an empty page got %v total %d", res.Vals, res.Total)
+	}
+}
+
+// pageThrough reads the whole order limit values at a time continuing after the last one
+func pageThrough(t *testing.T, st repo.IndexedStore[repo.GenericKeyT, Value],
+	q repo.Query[repo.GenericKeyT, Value], key func(Value) string) []uint64 {
+	ids := []uint64{}
+	for i := 0; i < 10; i++ {
+		res := query(t, st, q)
+		ids = append(ids, valueIDs(res.Vals)...)
+		if len(res.Vals) < q.Limit {
+			return ids
+		}
+		last := res.Vals[len(res.Vals)-1]
+		q.After = &repo.Cursor[repo.GenericKeyT]{IndexKey: key(last), ID: repo.GenericKeyT(last.ID)}
+	}
+	t.Fatalf("paging didn't end, got %v", ids)
+	return nil
+}
+
+func queryAfter(t *testing.T, st repo.IndexedStore[repo.GenericKeyT, Value]) {
+	createNamed(t, st)
+	name := testIndexes[0].Key
+
+	got := pageThrough(t, st, repo.Query[repo.GenericKeyT, Value]{Index: "name", Limit: 2}, name)
+	if want := []uint64{5, 42, 1<<64 - 1, 0, 1 << 63, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ascending pages expected %v got %v", want, got)
+	}
+	got = pageThrough(t, st, repo.Query[repo.GenericKeyT, Value]{Index: "name", Desc: true, Limit: 4}, name)
+	if want := []uint64{7, 1 << 63, 0, 1<<64 - 1, 42, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("descending pages expected %v got %v", want, got)
+	}
+	got = pageThrough(t, st, repo.Query[repo.GenericKeyT, Value]{Limit: 4}, func(Value) string { return "" })
+	if want := []uint64{0, 5, 7, 42, 1 << 63, 1<<64 - 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("key order pages expected %v got %v", want, got)
+	}
+
+	// The cursor doesn't have to be a value in the store
+	res := query(t, st, repo.Query[repo.GenericKeyT, Value]{Index: "name", Limit: 10,
+		After: &repo.Cursor[repo.GenericKeyT]{IndexKey: "bz"}})
+	if got := valueIDs(res.Vals); !reflect.DeepEqual(got, []uint64{0, 1 << 63, 7}) || res.Total != 6 {
+		t.Errorf("after bz expected [0 %d 7] total 6 got %v total %d", uint64(1<<63), got, res.Total)
+	}
+	res = query(t, st, repo.Query[repo.GenericKeyT, Value]{Index: "name", Desc: true, Limit: 10, Offset: 1,
+		Filter: func(v Value) bool { return v.ID != 5 }, After: &repo.Cursor[repo.GenericKeyT]{IndexKey: "carol", ID: 1}})
+	if got := valueIDs(res.Vals); !reflect.DeepEqual(got, []uint64{1<<64 - 1, 42}) || res.Total != 5 {
+		t.Errorf("descending after carol 1 offset 1 expected [%d 42] total 5 got %v total %d",
+			uint64(1<<64-1), got, res.Total)
+	}
+}
+
+func queryUpdates(t *testing.T, st repo.IndexedStore[repo.GenericKeyT, Value]) {
+	createNamed(t, st)
+
+	// Renaming moves the value in the name index, deleting takes it out of all of them
+	if err := st.Update(5, namedValue(5, "zed", 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Create(42, namedValue(42, "aaron", 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Delete(1 << 63); err != nil {
+		t.Fatal(err)
+	}
+	res := query(t, st, repo.Query[repo.GenericKeyT, Value]{Index: "name", Limit: 10})
+	if got := valueIDs(res.Vals); !reflect.DeepEqual(got, []uint64{42, 1<<64 - 1, 0, 7, 5}) || res.Total != 5 {
+		t.Errorf("name order after updates got %v total %d", got, res.Total)
+	}
+	res = query(t, st, repo.Query[repo.GenericKeyT, Value]{Index: "ntags", Limit: 10})
+	if got := valueIDs(res.Vals); !reflect.DeepEqual(got, []uint64{1<<64 - 1, 0, 7, 5, 42}) {
+		t.Errorf("ntags order after updates got %v", got)
+	}
+	if res.Vals[3].Name != "zed" {
+		t.Errorf("query expected the updated value got %v", res.Vals[3])
+	}
+}
+
+func queryConcurrent(t *testing.T, st repo.IndexedStore[repo.GenericKeyT, Value]) {
+	parallel(t, func(w int) error {
+		for i := 0; i < opsPerWorker; i++ {
+			id := uint64(w*opsPerWorker + i + 1)
+			if err := st.Create(repo.GenericKeyT(id), namedValue(id, fmt.Sprintf("w%d", w), i%3)); err != nil {
+				return err
+			}
+			// Rename some so they move in the index while the others query
+			if i%2 == 0 {
+				if err := st.Update(repo.GenericKeyT(id), namedValue(id, fmt.Sprintf("v%03d", i), i%3)); err != nil {
+					return err
+				}
+			}
+			if _, err := st.Query(repo.Query[repo.GenericKeyT, Value]{Index: "name", Desc: w%2 == 0, Limit: 5}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	// Once it settles the index has every value once, in order
+	res := query(t, st, repo.Query[repo.GenericKeyT, Value]{Index: "name", Limit: workers * opsPerWorker * 2})
+	if len(res.Vals) != workers*opsPerWorker || res.Total != workers*opsPerWorker {
+		t.Fatalf("expected %d values got %d total %d", workers*opsPerWorker, len(res.Vals), res.Total)
+	}
+	for i := 1; i < len(res.Vals); i++ {
+		a, b := res.Vals[i-1], res.Vals[i]
+		if a.Name > b.Name || (a.Name == b.Name && a.ID >= b.ID) {
+			t.Errorf("not in name order at %d: %v then %v", i, a, b)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package repotest
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/git-sim/tc/app/domain/repo"
+)
+
+// TestString runs the repo.StringRepo checks
+func TestString(t *testing.T, newRepo func() repo.StringRepo) {
+	run(t, []check{
+		{"CRUD", func(t *testing.T) { stringCRUD(t, newRepo()) }},
+		{"NotFound", func(t *testing.T) { stringNotFound(t, newRepo()) }},
+		{"Order", func(t *testing.T) { stringOrder(t, newRepo()) }},
+		{"Concurrent", func(t *testing.T) { stringConcurrent(t, newRepo()) }},
+	})
+}
+
+func stringCRUD(t *testing.T, sr repo.StringRepo) {
+	if err := sr.Create(1, "alice"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := sr.Create(2, "bob"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if v, err := sr.Retrieve(1); err != nil || v != "alice" {
+		t.Errorf("retrieve 1 expected alice got %q %v", v, err)
+	}
+	if count, _ := sr.RetrieveCount(); count != 2 {
+		t.Errorf("count expected 2 got %d", count)
+	}
+
+	if err := sr.Update(1, "alicia"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if v, _ := sr.Retrieve(1); v != "alicia" {
+		t.Errorf("after update expected alicia got %q", v)
+	}
+	all, err := sr.RetrieveAll()
+	if err != nil || !reflect.DeepEqual(derefStrings(all), []string{"alicia", "bob"}) {
+		t.Errorf("all expected [alicia bob] got %v %v", derefStrings(all), err)
+	}
+
+	if err := sr.Delete(1); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	_, err = sr.Retrieve(1)
+	expectNotFound(t, "retrieve after delete", err)
+	if count, _ := sr.RetrieveCount(); count != 1 {
+		t.Errorf("count after delete expected 1 got %d", count)
+	}
+}
+
+func stringNotFound(t *testing.T, sr repo.StringRepo) {
+	_, err := sr.Retrieve(1)
+	expectNotFound(t, "retrieve from an empty repo", err)
+	if err := sr.Delete(1); err != nil {
+		t.Errorf("delete of a missing id: %v", err)
+	}
+	if count, _ := sr.RetrieveCount(); count != 0 {
+		t.Errorf("count after deleting a missing id expected 0 got %d", count)
+	}
+	all, err := sr.RetrieveAll()
+	if err != nil || all == nil || len(all) != 0 {
+		t.Errorf("all from an empty repo expected an empty slice got %v %v", all, err)
+	}
+}
+
+func stringOrder(t *testing.T, sr repo.StringRepo) {
+	ids := []uint64{1 << 63, 5, 1<<64 - 1, 0, 42}
+	for _, id := range ids {
+		if err := sr.Create(id, fmt.Sprint(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"0", "5", "42", fmt.Sprint(uint64(1 << 63)), fmt.Sprint(uint64(1<<64 - 1))}
+	all, err := sr.RetrieveAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := derefStrings(all); !reflect.DeepEqual(got, want) {
+		t.Errorf("all expected id order %v got %v", want, got)
+	}
+}
+
+func derefStrings(ps []*string) []string {
+	ret := make([]string, len(ps))
+	for i, p := range ps {
+		ret[i] = *p
+	}
+	return ret
+}
+
+func stringConcurrent(t *testing.T, sr repo.StringRepo) {
+	parallel(t, func(w int) error {
+		for i := 0; i < opsPerWorker; i++ {
+			id := uint64(w*opsPerWorker + i + 1)
+			if err := sr.Create(id, "created"); err != nil {
+				return err
+			}
+			if err := sr.Update(id, fmt.Sprint(id)); err != nil {
+				return err
+			}
+			if _, err := sr.Retrieve(id); err != nil {
+				return err
+			}
+			if _, err := sr.RetrieveAll(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if count, _ := sr.RetrieveCount(); count != workers*opsPerWorker {
+		t.Errorf("count expected %d got %d", workers*opsPerWorker, count)
+	}
+}
+
+// TestImage runs the repo.ImageRepo checks
+func TestImage(t *testing.T, newRepo func() repo.ImageRepo) {
+	run(t, []check{
+		{"CRUD", func(t *testing.T) { imageCRUD(t, newRepo()) }},
+		{"NotFound", func(t *testing.T) { imageNotFound(t, newRepo()) }},
+		{"Order", func(t *testing.T) { imageOrder(t, newRepo()) }},
+		{"Concurrent", func(t *testing.T) { imageConcurrent(t, newRepo()) }},
+	})
+}
+
+// newImage a small opaque image, mark tells them apart
+func newImage(mark uint8) *image.Image {
+	rgba := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for x := 0; x < 2; x++ {
+		for y := 0; y < 2; y++ {
+			rgba.Set(x, y, color.RGBA{R: mark, G: uint8(x * 100), B: uint8(y * 100), A: 255})
+		}
+	}
+	var img image.Image = rgba
+	return &img
+}
+
+// imageMark reads back the mark, persistent repos may decode to another image type
+func imageMark(img *image.Image) int {
+	if img == nil || *img == nil {
+		return -1
+	}
+	r, _, _, _ := (*img).At(0, 0).RGBA()
+	return int(r >> 8)
+}
+
+func sameImage(a *image.Image, b *image.Image) bool {
+	if a == nil || b == nil || *a == nil || *b == nil {
+		return a == b
+	}
+	if (*a).Bounds() != (*b).Bounds() {
+		return false
+	}
+	bounds := (*a).Bounds()
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			r1, g1, b1, a1 := (*a).At(x, y).RGBA()
+			r2, g2, b2, a2 := (*b).At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func imageCRUD(t *testing.T, ir repo.ImageRepo) {
+	img1 := newImage(1)
+	if err := ir.Create(1, img1); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := ir.Create(2, newImage(2)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if got, err := ir.Retrieve(1); err != nil || !sameImage(got, img1) {
+		t.Errorf("retrieve 1 expected the created image got mark %d %v", imageMark(got), err)
+	}
+	if count, _ := ir.RetrieveCount(); count != 2 {
+		t.Errorf("count expected 2 got %d", count)
+	}
+
+	if err := ir.Update(1, newImage(3)); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got, _ := ir.Retrieve(1); imageMark(got) != 3 {
+		t.Errorf("after update expected mark 3 got %d", imageMark(got))
+	}
+
+	if err := ir.Delete(1); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	_, err := ir.Retrieve(1)
+	expectNotFound(t, "retrieve after delete", err)
+	if count, _ := ir.RetrieveCount(); count != 1 {
+		t.Errorf("count after delete expected 1 got %d", count)
+	}
+}
+
+func imageNotFound(t *testing.T, ir repo.ImageRepo) {
+	_, err := ir.Retrieve(1)
+	expectNotFound(t, "retrieve from an empty repo", err)
+	if err := ir.Delete(1); err != nil {
+		t.Errorf("delete of a missing id: %v", err)
+	}
+	all, err := ir.RetrieveAll()
+	if err != nil || all == nil || len(all) != 0 {
+		t.Errorf("all from an empty repo expected an empty slice got %v %v", all, err)
+	}
+}
+
+func imageOrder(t *testing.T, ir repo.ImageRepo) {
+	marks := map[uint64]uint8{1 << 63: 3, 5: 1, 1<<64 - 1: 4, 0: 0, 42: 2}
+	for id, mark := range marks {
+		if err := ir.Create(id, newImage(mark)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := ir.RetrieveAll()
+	if err != nil || len(all) != len(marks) {
+		t.Fatalf("all expected %d images got %d %v", len(marks), len(all), err)
+	}
+	for i, img := range all {
+		if imageMark(img) != i {
+			t.Errorf("all not in id order, at %d got mark %d", i, imageMark(img))
+		}
+	}
+}
+
+func imageConcurrent(t *testing.T, ir repo.ImageRepo) {
+	parallel(t, func(w int) error {
+		for i := 0; i < opsPerWorker; i++ {
+			id := uint64(w*opsPerWorker + i + 1)
+			if err := ir.Create(id, newImage(uint8(w))); err != nil {
+				return err
+			}
+			if _, err := ir.Retrieve(id); err != nil {
+				return err
+			}
+			if _, err := ir.RetrieveCount(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if count, _ := ir.RetrieveCount(); count != workers*opsPerWorker {
+		t.Errorf("count expected %d got %d", workers*opsPerWorker, count)
+	}
+}
@@ -0,0 +1,65 @@
+// Package repotest checks that an implementation of the domain repos keeps the contract in
+// domain/repo, the storage packages run it from their tests against their repos. Each check
+// gets a new empty repo from the factory passed in, and the concurrent checks are meant to be
+// run with -race.
+package repotest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// Value what the suite stores in the generic repos and typed stores. Fields are exported so the
+// persistent repos can encode it, it's registered with gob in generic.go.
+type Value struct {
+	ID   uint64
+	Name string
+	Tags []string
+}
+
+// The size of the concurrent checks, workers each doing opsPerWorker ops
+const (
+	workers      = 4
+	opsPerWorker = 25
+)
+
+// check one named part of a suite, run as a subtest
+type check struct {
+	name string
+	fn   func(t *testing.T)
+}
+
+func run(t *testing.T, checks []check) {
+	for _, c := range checks {
+		t.Run(c.name, c.fn)
+	}
+}
+
+// parallel runs fn for each worker at the same time and reports the errors
+func parallel(t *testing.T, fn func(worker int) error) {
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			if err := fn(w); err != nil {
+				errs <- err
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func expectNotFound(t *testing.T, what string, err error) {
+	t.Helper()
+	if !usecase.CheckEs(err, usecase.EsNotFound) {
+		t.Errorf("%s expected EsNotFound got %v", what, err)
+	}
+}
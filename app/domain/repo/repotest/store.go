@@ -0,0 +1,187 @@
+package repotest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/git-sim/tc/app/domain/repo"
+)
+
+func newValue(id uint64) Value {
+	return Value{ID: id, Name: fmt.Sprintf("value %d", id), Tags: []string{"a", "b"}}
+}
+
+// TestStore runs the repo.Store checks
+func TestStore(t *testing.T, newStore func() repo.Store[repo.GenericKeyT, Value]) {
+	run(t, []check{
+		{"CRUD", func(t *testing.T) { storeCRUD(t, newStore()) }},
+		{"NotFound", func(t *testing.T) { storeNotFound(t, newStore()) }},
+		{"Order", func(t *testing.T) { storeOrder(t, newStore()) }},
+		{"Concurrent", func(t *testing.T) { storeConcurrent(t, newStore()) }},
+		{"Drop", func(t *testing.T) { storeDrop(t, newStore(), newStore()) }},
+	})
+}
+
+func storeDrop(t *testing.T, st, other repo.Store[repo.GenericKeyT, Value]) {
+	for id := uint64(1); id <= 3; id++ {
+		st.Create(repo.GenericKeyT(id), newValue(id))
+		other.Create(repo.GenericKeyT(id), newValue(id))
+	}
+	if err := st.Drop(); err != nil {
+		t.Fatal(err)
+	}
+	// The other store is left alone
+	if count, _ := other.RetrieveCount(); count != 3 {
+		t.Errorf("other store count expected 3 got %d", count)
+	}
+	if val, err := other.Retrieve(2); err != nil || !reflect.DeepEqual(val, newValue(2)) {
+		t.Errorf("other store value expected %v got %v %v", newValue(2), val, err)
+	}
+}
+
+func storeCRUD(t *testing.T, st repo.Store[repo.GenericKeyT, Value]) {
+	if count, _ := st.RetrieveCount(); count != 0 {
+		t.Errorf("new repo count expected 0 got %d", count)
+	}
+
+	vals := []Value{newValue(1), newValue(2), newValue(3)}
+	for _, v := range vals {
+		if err := st.Create(repo.GenericKeyT(v.ID), v); err != nil {
+			t.Fatalf("create %d: %v", v.ID, err)
+		}
+	}
+	if count, _ := st.RetrieveCount(); count != len(vals) {
+		t.Errorf("count expected %d got %d", len(vals), count)
+	}
+	for _, v := range vals {
+		got, err := st.Retrieve(repo.GenericKeyT(v.ID))
+		if err != nil {
+			t.Fatalf("retrieve %d: %v", v.ID, err)
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("retrieve %d expected %v got %v", v.ID, v, got)
+		}
+	}
+
+	// Update replaces the value, so does a Create over an existing id
+	vals[1].Name = "updated"
+	if err := st.Update(repo.GenericKeyT(vals[1].ID), vals[1]); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got, _ := st.Retrieve(repo.GenericKeyT(vals[1].ID)); !reflect.DeepEqual(got, vals[1]) {
+		t.Errorf("after update expected %v got %v", vals[1], got)
+	}
+	vals[2].Name = "recreated"
+	if err := st.Create(repo.GenericKeyT(vals[2].ID), vals[2]); err != nil {
+		t.Fatalf("create over existing: %v", err)
+	}
+	if got, _ := st.Retrieve(repo.GenericKeyT(vals[2].ID)); !reflect.DeepEqual(got, vals[2]) {
+		t.Errorf("after create over existing expected %v got %v", vals[2], got)
+	}
+	if count, _ := st.RetrieveCount(); count != len(vals) {
+		t.Errorf("count after updates expected %d got %d", len(vals), count)
+	}
+
+	filtered, err := st.RetrieveFiltered(func(v Value) bool {
+		return v.Name == "updated"
+	})
+	if err != nil || len(filtered) != 1 || !reflect.DeepEqual(filtered[0], vals[1]) {
+		t.Errorf("filtered expected [%v] got %v %v", vals[1], filtered, err)
+	}
+
+	if err := st.Delete(repo.GenericKeyT(vals[0].ID)); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if count, _ := st.RetrieveCount(); count != len(vals)-1 {
+		t.Errorf("count after delete expected %d got %d", len(vals)-1, count)
+	}
+	all, err := st.RetrieveAll()
+	if err != nil || len(all) != len(vals)-1 {
+		t.Errorf("all after delete expected %d values got %d %v", len(vals)-1, len(all), err)
+	}
+}
+
+func storeNotFound(t *testing.T, st repo.Store[repo.GenericKeyT, Value]) {
+	_, err := st.Retrieve(1)
+	expectNotFound(t, "retrieve from an empty repo", err)
+
+	st.Create(1, newValue(1))
+	st.Delete(1)
+	_, err = st.Retrieve(1)
+	expectNotFound(t, "retrieve after delete", err)
+
+	// Deleting what isn't there isn't an error
+	if err := st.Delete(2); err != nil {
+		t.Errorf("delete of a missing id: %v", err)
+	}
+	all, err := st.RetrieveAll()
+	if err != nil || all == nil || len(all) != 0 {
+		t.Errorf("all from an empty repo expected an empty slice got %v %v", all, err)
+	}
+}
+
+func storeOrder(t *testing.T, st repo.Store[repo.GenericKeyT, Value]) {
+	// Spread the keys across the full range, including above the int64 max
+	ids := []uint64{1 << 63, 5, 1<<64 - 1, 0, 1 << 32, 42}
+	for _, id := range ids {
+		if err := st.Create(repo.GenericKeyT(id), newValue(id)); err != nil {
+			t.Fatalf("create %d: %v", id, err)
+		}
+	}
+	want := []uint64{0, 5, 42, 1 << 32, 1 << 63, 1<<64 - 1}
+
+	all, err := st.RetrieveAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := valueIDs(all); !reflect.DeepEqual(got, want) {
+		t.Errorf("all expected key order %v got %v", want, got)
+	}
+	filtered, err := st.RetrieveFiltered(func(v Value) bool { return v.ID != 42 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := valueIDs(filtered); !reflect.DeepEqual(got, []uint64{0, 5, 1 << 32, 1 << 63, 1<<64 - 1}) {
+		t.Errorf("filtered not in key order %v", got)
+	}
+}
+
+func valueIDs(vals []Value) []uint64 {
+	ids := make([]uint64, len(vals))
+	for i, v := range vals {
+		ids[i] = v.ID
+	}
+	return ids
+}
+
+func storeConcurrent(t *testing.T, st repo.Store[repo.GenericKeyT, Value]) {
+	const shared = 1 << 40
+	st.Create(shared, newValue(shared))
+	parallel(t, func(w int) error {
+		for i := 0; i < opsPerWorker; i++ {
+			id := uint64(w*opsPerWorker + i + 1)
+			if err := st.Create(repo.GenericKeyT(id), newValue(id)); err != nil {
+				return err
+			}
+			if _, err := st.Retrieve(repo.GenericKeyT(id)); err != nil {
+				return err
+			}
+			v := newValue(shared)
+			v.Name = fmt.Sprintf("worker %d", w)
+			if err := st.Update(shared, v); err != nil {
+				return err
+			}
+			if _, err := st.RetrieveAll(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if count, _ := st.RetrieveCount(); count != workers*opsPerWorker+1 {
+		t.Errorf("count expected %d got %d", workers*opsPerWorker+1, count)
+	}
+	if _, err := st.Retrieve(shared); err != nil {
+		t.Errorf("shared value: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package repo
+
+// Key the key types a Store can use, the ids in the system are all uint64 underneath
+type Key interface {
+	~uint64
+}
+
+// Store the typed counterpart of Generic, it keeps the same contract: Create and Update both
+// store the value replacing what's there, Retrieve of a missing key returns EsNotFound, Delete
+// of a missing key isn't an error. RetrieveFiltered and RetrieveAll return the values in key
+// order. Drop deletes the values and whatever the store keeps them in, it isn't used after.
+type Store[K Key, V any] interface {
+	Create(id K, val V) error
+	Update(id K, val V) error
+	Delete(id K) error
+	Drop() error
+
+	Retrieve(id K) (V, error)
+	RetrieveFiltered(fn func(V) bool) ([]V, error)
+	RetrieveCount() (int, error)
+	RetrieveAll() ([]V, error)
+}
+
+// Index a secondary order of a Store's values. Key gives a value's position, compared as
+// bytes, values with the same Key are in store key order. Persistent stores keep the Key
+// so it has to be valid UTF-8.
+type Index[V any] struct {
+	Name string
+	Key  func(V) string
+}
+
+// Cursor a position in the order of a query, the index key and the store key of a value
+type Cursor[K Key] struct {
+	IndexKey string
+	ID       K
+}
+
+// Query selects, orders and pages the values of an IndexedStore
+type Query[K Key, V any] struct {
+	Index  string       // the Index to order by, "" orders by key
+	Desc   bool         // reverses the order
+	Filter func(V) bool // the values to keep, nil keeps them all
+	After  *Cursor[K]   // start after this position in the order, the Offset counts from there
+	Offset int
+	Limit  int // the most values returned, 0 only counts
+}
+
+// QueryResult the page of values, Total is how many the filter kept before After, Offset and Limit
+type QueryResult[V any] struct {
+	Vals  []V
+	Total int
+}
+
+// IndexedStore a Store keeping its values in the order of its Indexes as well, so a page
+// of them can be read without sorting the lot. Query returns EsArgInvalid for an unknown
+// index.
+type IndexedStore[K Key, V any] interface {
+	Store[K, V]
+	Query(q Query[K, V]) (QueryResult[V], error)
+}
@@ -1,5 +1,7 @@
 package repo
 
+// StringRepo stores one string field per id. Retrieve of a missing id returns EsNotFound,
+// Delete of a missing id isn't an error. RetrieveAll returns the values in id order.
 type StringRepo interface {
     Create(id uint64, val string) error
     Update(id uint64, val string) error
@@ -12,6 +12,7 @@ import (
 type AccountService struct {
 	repo                  repo.AccountRepo
 	regAccountSubscribers []func(entity.Account)
+	chgEmailSubscribers   []func(acc entity.Account, oldEmail string)
 }
 
 // NewAccountService takes in the account repository
@@ -21,9 +22,9 @@ func NewAccountService(newrepo repo.AccountRepo) *AccountService {
 	}
 }
 
-// AlreadyExists returns if the account exists
+// AlreadyExists returns if the account exists, the email matches ignoring case
 func (s *AccountService) AlreadyExists(email string) bool {
-	_, err := s.repo.Retrieve(email)
+	_, err := s.repo.RetrieveIDByEmail(email)
 	if err == nil {
 		return true
 	}
@@ -39,13 +40,18 @@ func (s *AccountService) AlreadyExistsByID(id entity.AccountIDType) bool {
 	return false
 }
 
-// GetIDFromEmail utility reverse lookup
+// GetIDFromEmail utility reverse lookup through the repo's email index
 func (s *AccountService) GetIDFromEmail(email string) (entity.AccountIDType, error) {
-	val, err := s.repo.Retrieve(email) //todo replace with the promised quick mapping
+	return s.repo.RetrieveIDByEmail(email)
+}
+
+// GetEmailFromID utility lookup
+func (s *AccountService) GetEmailFromID(id entity.AccountIDType) (string, error) {
+	val, err := s.repo.RetrieveByID(id)
 	if err == nil {
-		return val.GetID(), nil
+		return val.GetEmail(), nil
 	}
-	return 0, err
+	return "", err
 }
 
 // todo put a real notification system in
@@ -60,3 +66,15 @@ func (s *AccountService) NotifyRegisterAccount(acc entity.Account) {
 		fn(acc)
 	}
 }
+
+// SubscribeChangeEmail same simple pub-sub as SubscribeRegisterAccount
+func (s *AccountService) SubscribeChangeEmail(fn func(acc entity.Account, oldEmail string)) {
+	s.chgEmailSubscribers = append(s.chgEmailSubscribers, fn)
+}
+
+// NotifyChangeEmail an account has moved to a new address, acc already has the new one
+func (s *AccountService) NotifyChangeEmail(acc entity.Account, oldEmail string) {
+	for _, fn := range s.chgEmailSubscribers {
+		fn(acc, oldEmail)
+	}
+}
@@ -37,6 +37,23 @@ func (r *accountRepo) Retrieve(email string) (*entity.Account, error) {
 	}
 }
 
+func (r *accountRepo) RetrieveIDByEmail(email string) (entity.AccountIDType, error) {
+	a, err := r.Retrieve(email)
+	if err != nil {
+		return 0, err
+	}
+	return a.GetID(), nil
+}
+
+func (r *accountRepo) RetrieveByID(id entity.AccountIDType) (*entity.Account, error) {
+	for _, a := range r.m {
+		if a.GetID() == id {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("id not found")
+}
+
 func (r *accountRepo) RetrieveCount() (int, error) {
 	return len(r.m), nil
 }
@@ -81,9 +81,23 @@ func HandleAccount(u usecase.AccountUsecase) http.Handler {
 					plastname = &lnval[0]
 				}
 
-				err := u.UpdateNameAccount(email, pfirstname, plastname)
+				// The names and the address go in one update, a rejected address changes neither
+				newemail := r.FormValue("newemail")
+				acc, err = u.UpdateAccount(email, newemail, pfirstname, plastname)
 				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
+					if usecase.CheckEs(err, usecase.EsAlreadyExists) || usecase.CheckEs(err, usecase.EsArgInvalid) {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+					} else {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+					}
+					return
+				}
+				if newemail != "" {
+					err = json.NewEncoder(w).Encode(acc)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
 				}
 
 			} else {
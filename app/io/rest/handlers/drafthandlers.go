@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// Drafts are unfinished messages kept in the user's Drafts folder. They aren't checked
+// until they're sent, sending goes through the same path as a POST on /message.
+
+// HandleDraft handler
+func HandleDraft(mu usecase.MsgUsecase, u usecase.AccountUsecase) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetupCORS(r, w)
+		accIDString, ok, auth := GetAccIDFromSession(u, r)
+		if !auth || !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		accID, err := usecase.ToAccountID(accIDString)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.ParseForm()
+		_, hasMsgID := r.Form["msgid"]
+		var mid usecase.MsgIDType
+		if hasMsgID {
+			mid, err = parseIDStringAndReportErr(w, accIDString, r.FormValue("msgid"))
+			if err != nil {
+				return //error already reported
+			}
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			// Get Draft has the params:
+			//   msgid: base16 optional, returns the one draft otherwise all of them newest first
+			var out interface{}
+			if hasMsgID {
+				out, err = mu.RetrieveDraft(accID, mid)
+			} else {
+				out, err = mu.ListDrafts(accID)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			err = json.NewEncoder(w).Encode(out)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodPost:
+			// Post with an IngressMsg body saves a new draft, the response is the draft.
+			// Post with msgid and send=1 sends the draft, the response is the sent message.
+			var outmsg interface{}
+			if hasMsgID && r.FormValue("send") == "1" {
+				newid, err := mu.SendDraft(accID, mid)
+				if err != nil {
+					http.Error(w, err.Error(), draftErrToStatus(err))
+					return
+				}
+				outmsg, err = mu.RetrieveMsg(newid)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			} else {
+				inmsg, err := decodeDraft(w, r)
+				if err != nil {
+					return //error already reported
+				}
+				newid, err := mu.CreateDraft(accID, inmsg)
+				if err != nil {
+					http.Error(w, err.Error(), draftErrToStatus(err))
+					return
+				}
+				outmsg, err = mu.RetrieveDraft(accID, newid)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			err = json.NewEncoder(w).Encode(outmsg)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodPut:
+			// Put with msgid and an IngressMsg body replaces the draft's content
+			if !hasMsgID {
+				http.Error(w, "missing msgid in request", http.StatusBadRequest)
+				return
+			}
+			inmsg, err := decodeDraft(w, r)
+			if err != nil {
+				return //error already reported
+			}
+			err = mu.UpdateDraft(accID, mid, inmsg)
+			if err != nil {
+				http.Error(w, err.Error(), draftErrToStatus(err))
+				return
+			}
+
+		case http.MethodDelete:
+			if !hasMsgID {
+				http.Error(w, "missing msgid in request", http.StatusBadRequest)
+				return
+			}
+			err = mu.DeleteDraft(accID, mid)
+			if err != nil {
+				http.Error(w, err.Error(), draftErrToStatus(err))
+				return
+			}
+
+		case http.MethodOptions:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// Helpers
+func decodeDraft(w http.ResponseWriter, r *http.Request) (*usecase.IngressMsg, error) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields() // catch unwanted fields
+
+	inmsg := &usecase.IngressMsg{}
+	err := d.Decode(inmsg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+	return inmsg, nil
+}
+
+func draftErrToStatus(err error) int {
+	if usecase.CheckEs(err, usecase.EsNotFound) {
+		return http.StatusNotFound
+	}
+	if usecase.CheckEs(err, usecase.EsForbidden) {
+		return http.StatusForbidden
+	}
+	if usecase.CheckEs(err, usecase.EsArgInvalid) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
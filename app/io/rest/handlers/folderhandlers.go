@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/git-sim/tc/app/usecase"
 )
@@ -13,7 +16,8 @@ import (
 // light control like marking a message as read/starred, or archiving message.
 // The folder presenter handles the sorting, and presenting the lists of messages.
 
-// This implementation doesn't allow adding or deleting folders.
+// Every account has the system folders (Inbox, Archive, Sent, Scheduled, Trash, Drafts) and can add their own.
+// User folders get idxs from usecase.EnumFirstUserFolder up, system folders can't be renamed or deleted.
 
 // HandleFolder handler
 func HandleFolder(ufo usecase.FoldersUsecase, mu usecase.MsgUsecase, u usecase.AccountUsecase) http.Handler {
@@ -34,15 +38,30 @@ func HandleFolder(ufo usecase.FoldersUsecase, mu usecase.MsgUsecase, u usecase.A
 		switch r.Method {
 		case http.MethodGet:
 			// Get Folder has the params:
-			//   folderid: 0|Inbox,1|Archive,2|Sent,3|Scheduled Def=0
+			//   folderid: 0|Inbox,1|Archive,2|Sent,3|Scheduled,4|Trash,5|Drafts,100..|user folders Def=0
 			//   sort: 0|time,1|subject,2|sender Def=0
 			//   sortorder: -1,1 Def=1
 			//   limit: 0.. Def=10
 			//   page: 0.. Def=0
+			//   cursor: NextCursor from the previous response, takes the place of page
+			// Optional filters, applied before paging
+			//   unread: 1 only unviewed
+			//   starred: 1 only starred
+			//   sender: email address
+			//   after, before: RFC3339 or 2006-01-02
+			//   hasthread: 1 only messages in a conversation
 			r.ParseForm()
-			qparams := getFolderParams(r)
+			qparams, err := getFolderParams(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 			pOut, err := ufo.QueryMsgs(accID, qparams)
 			if err != nil {
+				if usecase.CheckEs(err, usecase.EsArgInvalid) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
 				http.Error(w, err.Error(), http.StatusNotFound)
 				return
 			}
@@ -52,12 +71,122 @@ func HandleFolder(ufo usecase.FoldersUsecase, mu usecase.MsgUsecase, u usecase.A
 				return
 			}
 
+		case http.MethodPost:
+			// Post creates a user folder
+			//   name: the folder name, unique per account
+			r.ParseForm()
+			if missing := MissingRequiredFields(r, []string{"name"}); len(missing) > 0 {
+				http.Error(w, "missing name in request", http.StatusBadRequest)
+				return
+			}
+			info, err := ufo.CreateFolder(accID, r.FormValue("name"))
+			if err != nil {
+				http.Error(w, err.Error(), folderErrToStatus(err))
+				return
+			}
+			err = json.NewEncoder(w).Encode(info)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodPut:
+			// Put renames a user folder
+			//   folderid: idx of the folder
+			//   name: the new name
+			r.ParseForm()
+			if missing := MissingRequiredFields(r, []string{"folderid", "name"}); len(missing) > 0 {
+				http.Error(w, "missing folderid or name in request", http.StatusBadRequest)
+				return
+			}
+			idx, err := strconv.Atoi(r.FormValue("folderid"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = ufo.RenameFolder(accID, idx, r.FormValue("name"))
+			if err != nil {
+				http.Error(w, err.Error(), folderErrToStatus(err))
+				return
+			}
+
+		case http.MethodDelete:
+			// Delete removes a user folder, the messages in it go to the Archive.
+			// Deleting the Trash empties it
+			//   folderid: idx of the folder
+			r.ParseForm()
+			idx, err := strconv.Atoi(r.FormValue("folderid"))
+			if err != nil {
+				http.Error(w, "missing folderid in request", http.StatusBadRequest)
+				return
+			}
+			if idx == usecase.EnumTrash {
+				_, err = ufo.EmptyTrash(accID)
+			} else {
+				err = ufo.DeleteFolder(accID, idx)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), folderErrToStatus(err))
+				return
+			}
+
+		case http.MethodOptions:
 		default:
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 }
 
+// HandleFolderList handler - the folders of the account with their total and unviewed counts
+func HandleFolderList(ufo usecase.FoldersUsecase, u usecase.AccountUsecase) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetupCORS(r, w)
+		accIDString, ok, auth := GetAccIDFromSession(u, r)
+		if !auth || !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		accID, err := usecase.ToAccountID(accIDString)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			pOut, err := ufo.ListFolders(accID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			err = json.NewEncoder(w).Encode(pOut)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodOptions:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func folderErrToStatus(err error) int {
+	switch {
+	case usecase.CheckEs(err, usecase.EsNotFound):
+		return http.StatusNotFound
+	case usecase.CheckEs(err, usecase.EsForbidden):
+		return http.StatusForbidden
+	case usecase.CheckEs(err, usecase.EsAlreadyExists):
+		return http.StatusConflict
+	case usecase.CheckEs(err, usecase.EsArgInvalid):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
 func parseIntField(in string, def, min, max int) int {
 	ret, err := strconv.Atoi(in)
 	if err != nil || ret < min || max < ret {
@@ -74,17 +203,43 @@ func parseSortOrder(in string) int {
 }
 
 // getFolderParams parses the form intpu and gets the query parameters, defaulting out
-// those that are missing or invalid.  NOTE r.ParseForm() must be called before this for it to be valid
-func getFolderParams(r *http.Request) usecase.QueryParams {
+// those that are missing or invalid, except for the time filters which are an error when
+// they can't be parsed.  NOTE r.ParseForm() must be called before this for it to be valid
+func getFolderParams(r *http.Request) (usecase.QueryParams, error) {
+	var err error
 	qp := usecase.QueryParams{}
 	qp.FolderIdx = parseIntField(r.FormValue("folderid"), usecase.EnumInbox,
-		usecase.EnumInbox, usecase.EnumNumFolders-1)
+		usecase.EnumInbox, math.MaxInt32)
 	qp.SortBy = parseIntField(r.FormValue("sort"), usecase.EnumSortByTime,
 		usecase.EnumSortByTime, usecase.EnumNumSortBy-1)
 	qp.SortOrder = parseSortOrder(r.FormValue("sortorder"))
 	qp.Limit = parseIntField(r.FormValue("limit"), 10, 0, 100)
 	qp.Page = parseIntField(r.FormValue("page"), 0, 0, 1e3)
-	return qp
+	qp.Cursor = r.FormValue("cursor")
+	qp.UnreadOnly = r.FormValue("unread") == "1"
+	qp.StarredOnly = r.FormValue("starred") == "1"
+	qp.SenderEmail = r.FormValue("sender")
+	if qp.After, err = parseTimeField("after", r.FormValue("after")); err != nil {
+		return qp, err
+	}
+	if qp.Before, err = parseTimeField("before", r.FormValue("before")); err != nil {
+		return qp, err
+	}
+	qp.HasThread = r.FormValue("hasthread") == "1"
+	return qp, nil
+}
+
+// parseTimeField RFC3339 or a date, the zero time if missing
+func parseTimeField(field string, in string) (time.Time, error) {
+	if in == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, in); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s %q isn't an RFC3339 time or a yyyy-mm-dd date", field, in)
 }
 
 // MissingRequiredFields Returns fields that were missing
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/git-sim/tc/app/usecase"
 )
@@ -78,7 +80,13 @@ func HandleMessage(mu usecase.MsgUsecase, ufo usecase.FoldersUsecase, u usecase.
 			//        starred:   0|1
 			//    Move to folder
 			//        msgid: same as above
-			//        dest: {0|inbox, 1|archive, 2|sent, 3|scheduled}
+			//        dest: {0|inbox, 1|archive, 100..|user folder idx}
+			//    Reschedule a message that is still in the Scheduled folder
+			//        msgid: same as above
+			//        scheduledat: RFC3339 time string
+			//    Restore a message from the Trash to the folder it was deleted from
+			//        msgid: same as above
+			//        restore: 1
 			r.ParseForm()
 			msgIDString := r.FormValue("msgid")
 			mid, err := parseIDStringAndReportErr(w, accIDString, msgIDString)
@@ -103,20 +111,51 @@ func HandleMessage(mu usecase.MsgUsecase, ufo usecase.FoldersUsecase, u usecase.
 			}
 
 			if formval, ok := r.Form["dest"]; ok {
-				if formval[0] == "1" {
-					err = ufo.ArchiveMsg(accID, mid)
+				destIdx, err := strconv.Atoi(formval[0])
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
 				}
-				if formval[0] == "0" {
-					err = ufo.UnArchiveMsg(accID, mid)
+				err = ufo.MoveMsg(accID, mid, destIdx)
+				if err != nil {
+					if usecase.CheckEs(err, usecase.EsNotFound) {
+						http.Error(w, err.Error(), http.StatusNotFound)
+						return
+					}
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
 				}
+			}
+
+			if formval, ok := r.Form["scheduledat"]; ok {
+				at, err := time.Parse(time.RFC3339, formval[0])
 				if err != nil {
 					http.Error(w, err.Error(), http.StatusBadRequest)
 					return
 				}
+				err = mu.RescheduleMsg(accID, mid, at)
+				if err != nil {
+					http.Error(w, err.Error(), scheduledErrToStatus(err))
+					return
+				}
+			}
+
+			if formval, ok := r.Form["restore"]; ok && formval[0] == "1" {
+				err = ufo.RestoreMsg(accID, mid)
+				if err != nil {
+					if usecase.CheckEs(err, usecase.EsNotFound) {
+						http.Error(w, err.Error(), http.StatusNotFound)
+						return
+					}
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
 			}
 
-			// Todo handle Move to Folder Operation
 		case http.MethodDelete:
+			// Deleting a message still in the Scheduled folder cancels it, the message
+			// goes to the Drafts and comes back in the response so the client can edit it.
+			// Otherwise the message goes to the Trash, deleting it from the Trash removes it for good.
 			r.ParseForm()
 			msgIDString := r.FormValue("msgid")
 			mid, err := parseIDStringAndReportErr(w, accIDString, msgIDString)
@@ -129,8 +168,28 @@ func HandleMessage(mu usecase.MsgUsecase, ufo usecase.FoldersUsecase, u usecase.
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+
+			// Only a message in the caller's Scheduled folder is cancelled, a sent one (even
+			// to themselves) is trashed like any other
+			if _, err := ufo.RetrieveFromFolder(usecase.EnumScheduled, accID, mid); err == nil {
+				draft, err := mu.CancelScheduled(accID, mid)
+				if err != nil {
+					http.Error(w, err.Error(), scheduledErrToStatus(err))
+					return
+				}
+				err = json.NewEncoder(w).Encode(draft)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+
 			err = ufo.DeleteMsg(accID, mid)
 			if err != nil {
+				if usecase.CheckEs(err, usecase.EsNotFound) {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
@@ -143,6 +202,17 @@ func HandleMessage(mu usecase.MsgUsecase, ufo usecase.FoldersUsecase, u usecase.
 }
 
 // Helpers
+func scheduledErrToStatus(err error) int {
+	if usecase.CheckEs(err, usecase.EsNotFound) {
+		return http.StatusNotFound
+	}
+	if usecase.CheckEs(err, usecase.EsForbidden) {
+		// Already dispatched, too late to change it
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
 func parseIDStringAndReportErr(w http.ResponseWriter, accIDString string, msgIDString string) (usecase.MsgIDType, error) {
 	mid, err := usecase.ToMsgID(msgIDString)
 	if err != nil {
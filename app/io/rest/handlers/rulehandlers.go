@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// Rules are run on every message delivered to the user's Inbox, see usecase.Rule
+// for the conditions and actions.
+
+// HandleRule handler
+func HandleRule(ur usecase.RulesUsecase, u usecase.AccountUsecase) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetupCORS(r, w)
+		accIDString, ok, auth := GetAccIDFromSession(u, r)
+		if !auth || !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		accID, err := usecase.ToAccountID(accIDString)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			// Get lists the rules in the order they're applied
+			rules, err := ur.ListRules(accID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			err = json.NewEncoder(w).Encode(rules)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodPost:
+			// Post with a Rule body adds it after the existing rules, the response is the rule with its ID
+			rule, err := decodeRule(w, r)
+			if err != nil {
+				return //error already reported
+			}
+			pRule, err := ur.CreateRule(accID, *rule)
+			if err != nil {
+				http.Error(w, err.Error(), ruleErrToStatus(err))
+				return
+			}
+			err = json.NewEncoder(w).Encode(pRule)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodPut:
+			// Put with a Rule body replaces the rule with the same ID
+			rule, err := decodeRule(w, r)
+			if err != nil {
+				return //error already reported
+			}
+			err = ur.UpdateRule(accID, *rule)
+			if err != nil {
+				http.Error(w, err.Error(), ruleErrToStatus(err))
+				return
+			}
+
+		case http.MethodDelete:
+			//   ruleid: the rule's ID
+			r.ParseForm()
+			ruleID, err := strconv.ParseUint(r.FormValue("ruleid"), 10, 64)
+			if err != nil {
+				http.Error(w, "missing ruleid in request", http.StatusBadRequest)
+				return
+			}
+			err = ur.DeleteRule(accID, usecase.RuleIDType(ruleID))
+			if err != nil {
+				http.Error(w, err.Error(), ruleErrToStatus(err))
+				return
+			}
+
+		case http.MethodOptions:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// HandleRuleDryRun handler - POST a Rule body to see which messages it matches, nothing is changed
+func HandleRuleDryRun(ur usecase.RulesUsecase, u usecase.AccountUsecase) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetupCORS(r, w)
+		accIDString, ok, auth := GetAccIDFromSession(u, r)
+		if !auth || !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		accID, err := usecase.ToAccountID(accIDString)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			rule, err := decodeRule(w, r)
+			if err != nil {
+				return //error already reported
+			}
+			pOut, err := ur.DryRun(accID, *rule)
+			if err != nil {
+				http.Error(w, err.Error(), ruleErrToStatus(err))
+				return
+			}
+			err = json.NewEncoder(w).Encode(pOut)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodOptions:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// Helpers
+func decodeRule(w http.ResponseWriter, r *http.Request) (*usecase.Rule, error) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields() // catch unwanted fields
+
+	rule := &usecase.Rule{}
+	err := d.Decode(rule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+	return rule, nil
+}
+
+func ruleErrToStatus(err error) int {
+	if usecase.CheckEs(err, usecase.EsNotFound) {
+		return http.StatusNotFound
+	}
+	if usecase.CheckEs(err, usecase.EsArgInvalid) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
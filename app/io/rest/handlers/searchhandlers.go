@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// HandleSearch handler - full text search across the user's folders, see usecase.SearchParams
+// for the query syntax
+func HandleSearch(us usecase.SearchUsecase, u usecase.AccountUsecase) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetupCORS(r, w)
+		accIDString, ok, auth := GetAccIDFromSession(u, r)
+		if !auth || !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		accID, err := usecase.ToAccountID(accIDString)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			// Get Search has the params:
+			//   q: the query
+			//   limit: 0.. Def=10
+			//   page: 0.. Def=0
+			r.ParseForm()
+			sp := usecase.SearchParams{
+				Query: r.FormValue("q"),
+				Limit: parseIntField(r.FormValue("limit"), 10, 0, 1e3),
+				Page:  parseIntField(r.FormValue("page"), 0, 0, 1e6),
+			}
+			pOut, err := us.Search(accID, sp)
+			if err != nil {
+				if usecase.CheckEs(err, usecase.EsArgInvalid) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			err = json.NewEncoder(w).Encode(pOut)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodOptions:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// HandleAccountSettings handler - forwarding and the vacation auto-reply, see usecase.AccountSettings
+func HandleAccountSettings(us usecase.SettingsUsecase, u usecase.AccountUsecase) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetupCORS(r, w)
+		accIDString, ok, auth := GetAccIDFromSession(u, r)
+		if !auth || !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		accID, err := usecase.ToAccountID(accIDString)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			settings, err := us.GetSettings(accID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			err = json.NewEncoder(w).Encode(settings)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodPut:
+			// Put with an AccountSettings body replaces the settings
+			d := json.NewDecoder(r.Body)
+			d.DisallowUnknownFields() // catch unwanted fields
+
+			settings := usecase.AccountSettings{}
+			err := d.Decode(&settings)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = us.UpdateSettings(accID, settings)
+			if err != nil {
+				if usecase.CheckEs(err, usecase.EsNotFound) {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+		case http.MethodOptions:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
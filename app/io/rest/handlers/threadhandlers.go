@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// Threads group the messages in a user's folders by conversation.
+// GET without a threadid lists the threads, most recent activity first.
+
+// HandleThread handler
+func HandleThread(ut usecase.ThreadsUsecase, u usecase.AccountUsecase) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetupCORS(r, w)
+		accIDString, ok, auth := GetAccIDFromSession(u, r)
+		if !auth || !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		accID, err := usecase.ToAccountID(accIDString)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.ParseForm()
+		_, hasThreadID := r.Form["threadid"]
+		var tid usecase.ThreadIDType
+		if hasThreadID {
+			tid, err = usecase.ToThreadID(r.FormValue("threadid"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			// Get Thread has the params:
+			//   threadid: base16 optional, returns the one ThreadInfo
+			//   limit: 0.. Def=0 means all
+			//   offset: 0.. Def=0
+			var out interface{}
+			if hasThreadID {
+				out, err = ut.GetThread(accID, tid)
+			} else {
+				limit := parseIntField(r.FormValue("limit"), 0, 0, 1e3)
+				offset := parseIntField(r.FormValue("offset"), 0, 0, 1e6)
+				out, err = ut.ListThreads(accID, limit, offset)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			err = json.NewEncoder(w).Encode(out)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodPut:
+			// Put sets the mute param for the thread
+			//   threadid: base16
+			//   mute: 0|1
+			if missing := MissingRequiredFields(r, []string{"threadid", "mute"}); len(missing) > 0 {
+				http.Error(w, "missing required fields", http.StatusBadRequest)
+				return
+			}
+			err = ut.MuteThread(accID, tid, r.FormValue("mute") == "1")
+			if err != nil {
+				if usecase.CheckEs(err, usecase.EsNotFound) {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodDelete:
+			// Delete removes all the messages in the thread from the user's folders
+			if !hasThreadID {
+				http.Error(w, "missing threadid in request", http.StatusBadRequest)
+				return
+			}
+			err = ut.DeleteThread(accID, tid)
+			if err != nil {
+				if usecase.CheckEs(err, usecase.EsNotFound) {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+		case http.MethodOptions:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,297 @@
+package bolt
+
+import (
+	"bytes"
+	"sort"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+const (
+	accountsBucket = "accounts"
+	emailsBucket   = "accountEmails" // the normalized emails to the ids
+)
+
+// Account the stored form of entity.Account, the id is the key
+type Account struct {
+	Email     string
+	FirstName string
+	LastName  string
+}
+
+// Account.toEntityAccount conversion helper
+func (ba *Account) toEntityAccount(id entity.AccountIDType) *entity.Account {
+	ret := entity.NewAccount(id, ba.Email)
+	ret.FirstName = ba.FirstName
+	ret.LastName = ba.LastName
+	return ret
+}
+
+func fromEntityAccount(a *entity.Account) *Account {
+	return &Account{
+		Email:     a.GetEmail(),
+		FirstName: a.GetFirstName(),
+		LastName:  a.GetLastName(),
+	}
+}
+
+// Impl of bolt based account repository, one bucket keyed by account id and the index of
+// the emails kept in step with it
+type accountRepo struct {
+	s *Store
+}
+
+func (s *Store) NewAccountRepo() *accountRepo {
+	return &accountRepo{s: s}
+}
+
+// emails the email index, it's built from the accounts the first time so a file written
+// before it had the index gets it
+func (r *accountRepo) emails(tx *bbolt.Tx) (*bbolt.Bucket, error) {
+	if b := tx.Bucket([]byte(emailsBucket)); b != nil {
+		return b, nil
+	}
+	b, err := tx.CreateBucket([]byte(emailsBucket))
+	if err != nil {
+		return nil, err
+	}
+	err = r.forEach(tx, func(id entity.AccountIDType, ba *Account) error {
+		return b.Put([]byte(entity.NormalizeEmail(ba.Email)), toKey(uint64(id)))
+	})
+	return b, err
+}
+
+// unindex removes the stored account's email from the index
+func (r *accountRepo) unindex(tx *bbolt.Tx, id entity.AccountIDType) error {
+	b := tx.Bucket([]byte(accountsBucket))
+	if b == nil {
+		return nil
+	}
+	data := b.Get(toKey(uint64(id)))
+	if data == nil {
+		return nil
+	}
+	var old Account
+	if err := decode(data, &old); err != nil {
+		return err
+	}
+	emails, err := r.emails(tx)
+	if err != nil {
+		return err
+	}
+	email := []byte(entity.NormalizeEmail(old.Email))
+	if bytes.Equal(emails.Get(email), toKey(uint64(id))) {
+		return emails.Delete(email)
+	}
+	return nil
+}
+
+func (r *accountRepo) put(tx *bbolt.Tx, a *entity.Account) error {
+	data, err := encode(fromEntityAccount(a))
+	if err != nil {
+		return err
+	}
+	if err := r.unindex(tx, a.GetID()); err != nil {
+		return err
+	}
+	emails, err := r.emails(tx)
+	if err != nil {
+		return err
+	}
+	if err := emails.Put([]byte(entity.NormalizeEmail(a.GetEmail())), toKey(uint64(a.GetID()))); err != nil {
+		return err
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(accountsBucket))
+	if err != nil {
+		return err
+	}
+	return b.Put(toKey(uint64(a.GetID())), data)
+}
+
+// lookup the id of the email, without the index (a file from before it that hasn't been
+// written since) the accounts are scanned
+func (r *accountRepo) lookup(tx *bbolt.Tx, email string) (id entity.AccountIDType, ok bool, err error) {
+	email = entity.NormalizeEmail(email)
+	if b := tx.Bucket([]byte(emailsBucket)); b != nil {
+		if v := b.Get([]byte(email)); v != nil {
+			return entity.AccountIDType(fromKey(v)), true, nil
+		}
+		return 0, false, nil
+	}
+	err = r.forEach(tx, func(aid entity.AccountIDType, ba *Account) error {
+		if !ok && entity.NormalizeEmail(ba.Email) == email {
+			id, ok = aid, true
+		}
+		return nil
+	})
+	return id, ok, err
+}
+
+// forEach calls fn for every stored account in id order
+func (r *accountRepo) forEach(tx *bbolt.Tx, fn func(id entity.AccountIDType, ba *Account) error) error {
+	b := tx.Bucket([]byte(accountsBucket))
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(func(k, data []byte) error {
+		var ba Account
+		if err := decode(data, &ba); err != nil {
+			return err
+		}
+		return fn(entity.AccountIDType(fromKey(k)), &ba)
+	})
+}
+
+func (r *accountRepo) Create(a *entity.Account) error {
+	if a == nil {
+		return usecase.NewEs(usecase.EsArgInvalid, "*entity.Account")
+	}
+	return r.s.db.Update(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket([]byte(accountsBucket)); b != nil && b.Get(toKey(uint64(a.GetID()))) != nil {
+			return usecase.NewEs(usecase.EsAlreadyExists, "entity.Account")
+		}
+		if _, ok, err := r.lookup(tx, a.GetEmail()); err != nil || ok {
+			if ok {
+				err = usecase.NewEs(usecase.EsAlreadyExists, "Email")
+			}
+			return err
+		}
+		return r.put(tx, a)
+	})
+}
+
+func (r *accountRepo) Update(a *entity.Account) error {
+	if a == nil {
+		return usecase.NewEs(usecase.EsArgInvalid, "*entity.Account")
+	}
+	return r.s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(accountsBucket))
+		if b == nil || b.Get(toKey(uint64(a.GetID()))) == nil {
+			return usecase.NewEs(usecase.EsNotFound, "entity.Account")
+		}
+		if id, ok, err := r.lookup(tx, a.GetEmail()); err != nil || (ok && id != a.GetID()) {
+			if err == nil {
+				err = usecase.NewEs(usecase.EsAlreadyExists, "Email")
+			}
+			return err
+		}
+		return r.put(tx, a)
+	})
+}
+
+func (r *accountRepo) Delete(a *entity.Account) error {
+	if a == nil {
+		return usecase.NewEs(usecase.EsArgInvalid, "*entity.Account")
+	}
+	return r.s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(accountsBucket))
+		if b == nil {
+			return nil
+		}
+		if err := r.unindex(tx, a.GetID()); err != nil {
+			return err
+		}
+		return b.Delete(toKey(uint64(a.GetID())))
+	})
+}
+
+func (r *accountRepo) Retrieve(email string) (*entity.Account, error) {
+	var ret *entity.Account
+	err := r.s.db.View(func(tx *bbolt.Tx) error {
+		id, ok, err := r.lookup(tx, email)
+		if err != nil || !ok {
+			return err
+		}
+		ret, err = r.get(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ret == nil {
+		return nil, usecase.NewEs(usecase.EsNotFound, "Email")
+	}
+	return ret, nil
+}
+
+func (r *accountRepo) RetrieveIDByEmail(email string) (entity.AccountIDType, error) {
+	var id entity.AccountIDType
+	var ok bool
+	err := r.s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		id, ok, err = r.lookup(tx, email)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, usecase.NewEs(usecase.EsNotFound, "Email")
+	}
+	return id, nil
+}
+
+// get the account, nil if there's none
+func (r *accountRepo) get(tx *bbolt.Tx, id entity.AccountIDType) (*entity.Account, error) {
+	b := tx.Bucket([]byte(accountsBucket))
+	if b == nil {
+		return nil, nil
+	}
+	data := b.Get(toKey(uint64(id)))
+	if data == nil {
+		return nil, nil
+	}
+	var ba Account
+	if err := decode(data, &ba); err != nil {
+		return nil, err
+	}
+	return ba.toEntityAccount(id), nil
+}
+
+func (r *accountRepo) RetrieveByID(id entity.AccountIDType) (*entity.Account, error) {
+	var ret *entity.Account
+	err := r.s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		ret, err = r.get(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ret == nil {
+		return nil, usecase.NewEs(usecase.EsNotFound, "account id")
+	}
+	return ret, nil
+}
+
+func (r *accountRepo) RetrieveCount() (int, error) {
+	var count int
+	err := r.s.db.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket([]byte(accountsBucket)); b != nil {
+			count = b.Stats().KeyN
+		}
+		return nil
+	})
+	return count, err
+}
+
+// RetrieveAll the accounts sorted by email, same as the ram repo
+func (r *accountRepo) RetrieveAll() ([]*entity.Account, error) {
+	accounts := []*entity.Account{}
+	err := r.s.db.View(func(tx *bbolt.Tx) error {
+		return r.forEach(tx, func(id entity.AccountIDType, ba *Account) error {
+			accounts = append(accounts, ba.toEntityAccount(id))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].GetEmail() < accounts[j].GetEmail()
+	})
+	return accounts, nil
+}
@@ -0,0 +1,315 @@
+package bolt
+
+import (
+	"encoding/gob"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/domain/repo/repotest"
+	"github.com/git-sim/tc/app/usecase"
+	"go.etcd.io/bbolt"
+)
+
+// gob only encodes exported fields, unlike the ram repo test's struct
+type MsgStruct struct {
+	ID         uint64
+	Sender     string
+	Recipients []string
+	Subject    string
+	Body       []byte
+}
+
+// Holder a value with a nested repo like the per account folders
+type Holder struct {
+	Name string
+	Repo repo.Generic
+}
+
+// IndexedHolder a value with a nested indexed store like the per account folders
+type IndexedHolder struct {
+	Name  string
+	Store repo.IndexedStore[repo.GenericKeyT, repotest.Value]
+}
+
+func init() {
+	gob.Register(MsgStruct{})
+	gob.Register(Holder{})
+}
+
+func openTestStore(t *testing.T, path string) *Store {
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestWithStringStruct(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	defer s.Close()
+
+	gr := s.NewGenericRepo()
+	count, _ := gr.RetrieveCount()
+	if count != 0 {
+		t.Error("Count not initialized to 0")
+	}
+
+	msgs := make([]MsgStruct, 3)
+	for i, m := range msgs {
+		m.ID = uint64(i)
+		m.Sender = "abc@mail.com"
+		m.Recipients = []string{"def@mail.com", "ghi@mail.com"}
+		m.Subject = fmt.Sprintf("test subject %d", i)
+		m.Body = ([]byte)(fmt.Sprintf("test message %d", i))
+		msgs[i] = m
+		if err := gr.Create(repo.GenericKeyT(m.ID), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, _ = gr.RetrieveCount()
+	if len(msgs) != count {
+		t.Errorf("count expected %d got %d", len(msgs), count)
+	}
+
+	for i, m := range msgs {
+		val, err := gr.Retrieve(repo.GenericKeyT(i))
+		if err != nil {
+			t.Errorf("retreive failed for id %d, err %s", i, err)
+		}
+		msgStruct, ok := val.(MsgStruct)
+		if ok {
+			if !reflect.DeepEqual(msgStruct, m) {
+				t.Errorf("structs don't match idx %d", i)
+			}
+		} else {
+			t.Errorf("failed to convert back to msgStruct")
+		}
+	}
+
+	all, _ := gr.RetrieveAll()
+	for i, v := range all {
+		if v.(MsgStruct).ID != uint64(i) {
+			t.Errorf("RetrieveAll not in key order at %d", i)
+		}
+	}
+
+	for i := range msgs {
+		gr.Delete(repo.GenericKeyT(i))
+	}
+
+	count, _ = gr.RetrieveCount()
+	if count != 0 {
+		t.Error("Count not 0 after delete")
+	}
+	if _, err := gr.Retrieve(0); !usecase.CheckEs(err, usecase.EsNotFound) {
+		t.Errorf("retrieve after delete expected EsNotFound got %v", err)
+	}
+}
+
+func TestGenericConformance(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	defer s.Close()
+	repotest.TestGeneric(t, s.NewGenericRepo)
+}
+
+func TestStoreConformance(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	defer s.Close()
+	t.Run("named", func(t *testing.T) {
+		n := 0
+		repotest.TestStore(t, func() repo.Store[repo.GenericKeyT, repotest.Value] {
+			n++
+			return OpenStore[repo.GenericKeyT, repotest.Value](s, fmt.Sprintf("store%d", n))
+		})
+	})
+	t.Run("unnamed", func(t *testing.T) {
+		repotest.TestStore(t, StoreFactory[repo.GenericKeyT, repotest.Value](s))
+	})
+}
+
+func TestIndexedStoreConformance(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	defer s.Close()
+	t.Run("named", func(t *testing.T) {
+		n := 0
+		repotest.TestIndexedStore(t, func(indexes ...repo.Index[repotest.Value]) repo.IndexedStore[repo.GenericKeyT, repotest.Value] {
+			n++
+			return OpenIndexedStore[repo.GenericKeyT, repotest.Value](s, fmt.Sprintf("store%d", n), indexes...)
+		})
+	})
+	t.Run("unnamed", func(t *testing.T) {
+		repotest.TestIndexedStore(t, func(indexes ...repo.Index[repotest.Value]) repo.IndexedStore[repo.GenericKeyT, repotest.Value] {
+			return IndexedStoreFactory[repo.GenericKeyT, repotest.Value](s, indexes...)()
+		})
+	})
+}
+
+func TestIndexedReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s := openTestStore(t, path)
+	byName := repo.Index[repotest.Value]{Name: "name", Key: func(v repotest.Value) string { return v.Name }}
+	newStore := IndexedStoreFactory[repo.GenericKeyT, repotest.Value](s, byName)
+
+	nested := newStore()
+	for i, name := range []string{"c", "a", "b"} {
+		nested.Create(repo.GenericKeyT(i), repotest.Value{ID: uint64(i), Name: name})
+	}
+	OpenStore[repo.GenericKeyT, IndexedHolder](s, "holders").Create(1, IndexedHolder{Name: "h", Store: nested})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestStore(t, path)
+	defer s.Close()
+	h, err := OpenStore[repo.GenericKeyT, IndexedHolder](s, "holders").Retrieve(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The nested store keeps its indexes, the updates after the reopen are indexed too
+	h.Store.Update(1, repotest.Value{ID: 1, Name: "d"})
+	res, err := h.Store.Query(repo.Query[repo.GenericKeyT, repotest.Value]{Index: "name", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, v := range res.Vals {
+		names = append(names, v.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"b", "c", "d"}) {
+		t.Errorf("expected names in order [b c d] got %v", names)
+	}
+}
+
+func TestIndexBuilt(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	defer s.Close()
+	byName := repo.Index[repotest.Value]{Name: "name", Key: func(v repotest.Value) string { return v.Name }}
+	// Values stored before the store had the index
+	plain := OpenStore[repo.GenericKeyT, repotest.Value](s, "values")
+	plain.Create(1, repotest.Value{ID: 1, Name: "b"})
+	plain.Create(2, repotest.Value{ID: 2, Name: "a"})
+
+	indexed := OpenIndexedStore[repo.GenericKeyT, repotest.Value](s, "values", byName)
+	indexed.Create(3, repotest.Value{ID: 3, Name: "c"})
+	res, err := indexed.Query(repo.Query[repo.GenericKeyT, repotest.Value]{Index: "name", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []uint64{}
+	for _, v := range res.Vals {
+		ids = append(ids, v.ID)
+	}
+	if !reflect.DeepEqual(ids, []uint64{2, 1, 3}) {
+		t.Errorf("expected ids by name [2 1 3] got %v", ids)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s := openTestStore(t, path)
+	if _, err := Open(path); err == nil {
+		t.Error("opening a store twice should fail")
+	}
+
+	holders := s.GenericRepo("holders")
+	nested := s.NewGenericRepo()
+	nested.Create(7, MsgStruct{ID: 7, Subject: "nested"})
+	holders.Create(1, Holder{Name: "h", Repo: nested})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestStore(t, path)
+	defer s.Close()
+	val, err := s.GenericRepo("holders").Retrieve(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, ok := val.(Holder)
+	if !ok || h.Name != "h" {
+		t.Fatalf("holder didn't survive the reopen %v", val)
+	}
+	// The nested repo reattaches to the reopened store
+	val, err = h.Repo.Retrieve(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.(MsgStruct).Subject != "nested" {
+		t.Errorf("nested value expected subject nested got %v", val)
+	}
+}
+
+func TestCountKept(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s := openTestStore(t, path)
+	store := OpenIndexedStore[repo.GenericKeyT, repotest.Value](s, "values")
+	for i := 1; i <= 3; i++ {
+		store.Create(repo.GenericKeyT(i), repotest.Value{ID: uint64(i)})
+	}
+	// A bucket written before the count was kept
+	s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("values")).SetSequence(0)
+	})
+	total := func(what string, want int) {
+		t.Helper()
+		res, err := store.Query(repo.Query[repo.GenericKeyT, repotest.Value]{Limit: 1})
+		if err != nil || res.Total != want {
+			t.Errorf("%s: total expected %d got %d %v", what, want, res.Total, err)
+		}
+		if n, _ := store.RetrieveCount(); n != want {
+			t.Errorf("%s: count expected %d got %d", what, want, n)
+		}
+	}
+	total("counted", 3)
+
+	// Updates and deletes of missing ids don't change it
+	store.Update(2, repotest.Value{ID: 2, Name: "b"})
+	store.Create(4, repotest.Value{ID: 4})
+	store.Delete(1)
+	store.Delete(9)
+	total("after writes", 3)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestStore(t, path)
+	defer s.Close()
+	store = OpenIndexedStore[repo.GenericKeyT, repotest.Value](s, "values")
+	total("reopened", 3)
+}
+
+func TestDropBuckets(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	defer s.Close()
+	byName := repo.Index[repotest.Value]{Name: "name", Key: func(v repotest.Value) string { return v.Name }}
+	newStore := IndexedStoreFactory[repo.GenericKeyT, repotest.Value](s, byName)
+	dropped, kept := newStore(), newStore()
+	for _, st := range []repo.IndexedStore[repo.GenericKeyT, repotest.Value]{dropped, kept} {
+		st.Create(1, repotest.Value{ID: 1, Name: "a"})
+	}
+	if err := dropped.Drop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the kept store's bucket and index bucket are left
+	buckets := 0
+	s.db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if strings.HasPrefix(string(name), "store.") {
+				buckets++
+			}
+			return nil
+		})
+	})
+	if buckets != 2 {
+		t.Errorf("expected the kept store's 2 buckets got %d", buckets)
+	}
+	if n, _ := kept.RetrieveCount(); n != 1 {
+		t.Errorf("kept store count expected 1 got %d", n)
+	}
+}
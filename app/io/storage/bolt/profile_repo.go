@@ -0,0 +1,304 @@
+package bolt
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/git-sim/tc/app/usecase"
+)
+
+const profilesBucket = "profiles"
+
+const (
+	EnumFirstName = iota
+	EnumLastName
+	EnumSalutation //Mr., Ms., Dr., Lt Col., etc
+	EnumSuffix     //Jr., Sr., III, PhD, Esq, etc
+	EnumBio
+	EnumNumProfileStringFields
+)
+const (
+	EnumAvatar = iota
+	EnumBackground
+	EnumNumProfileImageFields
+)
+
+// PublicProfile the stored form of a profile, the pics are png encoded
+type PublicProfile struct {
+	NameAndBios [EnumNumProfileStringFields]string
+	Pics        [EnumNumProfileImageFields][]byte
+}
+
+func (pp *PublicProfile) isEmpty() bool {
+	for _, s := range pp.NameAndBios {
+		if s != "" {
+			return false
+		}
+	}
+	for _, p := range pp.Pics {
+		if p != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Impl of bolt based profile repository, one bucket keyed by id. The string and image
+// repos below are views on one field of the profiles.
+type profileRepo struct {
+	s *Store
+}
+
+func (s *Store) NewProfileRepo() *profileRepo {
+	return &profileRepo{s: s}
+}
+
+// retrieve the profile for id, nil if there isn't one
+func (pr *profileRepo) retrieve(tx *bbolt.Tx, id uint64) (*PublicProfile, error) {
+	b := tx.Bucket([]byte(profilesBucket))
+	if b == nil {
+		return nil, nil
+	}
+	data := b.Get(toKey(id))
+	if data == nil {
+		return nil, nil
+	}
+	var pp PublicProfile
+	if err := decode(data, &pp); err != nil {
+		return nil, err
+	}
+	return &pp, nil
+}
+
+// modify applies fn to the profile for id, creating it if needed. Profiles left with
+// no fields set are removed.
+func (pr *profileRepo) modify(id uint64, fn func(pp *PublicProfile)) error {
+	return pr.s.db.Update(func(tx *bbolt.Tx) error {
+		pp, err := pr.retrieve(tx, id)
+		if err != nil {
+			return err
+		}
+		if pp == nil {
+			pp = &PublicProfile{}
+		}
+		fn(pp)
+
+		b, err := tx.CreateBucketIfNotExists([]byte(profilesBucket))
+		if err != nil {
+			return err
+		}
+		if pp.isEmpty() {
+			return b.Delete(toKey(id))
+		}
+		data, err := encode(pp)
+		if err != nil {
+			return err
+		}
+		return b.Put(toKey(id), data)
+	})
+}
+
+// forEach calls fn for every stored profile
+func (pr *profileRepo) forEach(fn func(pp *PublicProfile) error) error {
+	return pr.s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(profilesBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, data []byte) error {
+			var pp PublicProfile
+			if err := decode(data, &pp); err != nil {
+				return err
+			}
+			return fn(&pp)
+		})
+	})
+}
+
+func (pr *profileRepo) count() (int, error) {
+	var count int
+	err := pr.s.db.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket([]byte(profilesBucket)); b != nil {
+			count = b.Stats().KeyN
+		}
+		return nil
+	})
+	return count, err
+}
+
+// A port replicator so the usecases can treat the repo's separately
+type stringRepo struct {
+	Pr         *profileRepo
+	whichField int
+}
+
+func NewStringRepo(pr *profileRepo, which int) *stringRepo {
+	// Should be an assert
+	if which >= EnumNumProfileStringFields {
+		log.Fatal(fmt.Errorf("invalid String Repo Enum"))
+	}
+	return &stringRepo{
+		Pr:         pr,
+		whichField: which,
+	}
+}
+
+func (sr *stringRepo) set(id uint64, val string) error {
+	return sr.Pr.modify(id, func(pp *PublicProfile) {
+		pp.NameAndBios[sr.whichField] = val
+	})
+}
+
+func (sr *stringRepo) Create(id uint64, val string) error {
+	return sr.set(id, val)
+}
+
+func (sr *stringRepo) Update(id uint64, val string) error {
+	return sr.set(id, val)
+}
+
+func (sr *stringRepo) Delete(id uint64) error {
+	return sr.set(id, "")
+}
+
+func (sr *stringRepo) Retrieve(id uint64) (string, error) {
+	var pp *PublicProfile
+	err := sr.Pr.s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		pp, err = sr.Pr.retrieve(tx, id)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if pp == nil {
+		return "", usecase.NewEs(usecase.EsNotFound, "stringRepo id")
+	}
+	return pp.NameAndBios[sr.whichField], nil
+}
+
+func (sr *stringRepo) RetrieveCount() (int, error) {
+	//Note this is max count
+	return sr.Pr.count()
+}
+
+func (sr *stringRepo) RetrieveAll() ([]*string, error) {
+	ret := []*string{}
+	err := sr.Pr.forEach(func(pp *PublicProfile) error {
+		val := pp.NameAndBios[sr.whichField]
+		if val != "" {
+			ret = append(ret, &val)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Image Repo making interfaces to the profileRepo, same as the stringRepo except the
+// images are png encoded on the way in.
+type imageRepo struct {
+	Pr         *profileRepo
+	whichField int
+}
+
+func NewImageRepo(pr *profileRepo, which int) *imageRepo {
+	// Should be an assert
+	if which >= EnumNumProfileImageFields {
+		log.Fatal(fmt.Errorf("invalid image Repo Enum"))
+	}
+	return &imageRepo{
+		Pr:         pr,
+		whichField: which,
+	}
+}
+
+func encodeImage(val *image.Image) ([]byte, error) {
+	if val == nil || *val == nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, *val); err != nil {
+		return nil, usecase.NewEs(usecase.EsArgConvFail, fmt.Sprintf("imageRepo encode: %s", err))
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeImage(data []byte) (*image.Image, error) {
+	if data == nil {
+		return nil, nil
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, usecase.NewEs(usecase.EsArgConvFail, fmt.Sprintf("imageRepo decode: %s", err))
+	}
+	return &img, nil
+}
+
+func (ir *imageRepo) set(id uint64, val *image.Image) error {
+	data, err := encodeImage(val)
+	if err != nil {
+		return err
+	}
+	return ir.Pr.modify(id, func(pp *PublicProfile) {
+		pp.Pics[ir.whichField] = data
+	})
+}
+
+func (ir *imageRepo) Create(id uint64, val *image.Image) error {
+	return ir.set(id, val)
+}
+
+func (ir *imageRepo) Update(id uint64, val *image.Image) error {
+	return ir.set(id, val)
+}
+
+func (ir *imageRepo) Delete(id uint64) error {
+	return ir.set(id, nil)
+}
+
+func (ir *imageRepo) Retrieve(id uint64) (*image.Image, error) {
+	var pp *PublicProfile
+	err := ir.Pr.s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		pp, err = ir.Pr.retrieve(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pp == nil {
+		return nil, usecase.NewEs(usecase.EsNotFound, "imageRepo id")
+	}
+	return decodeImage(pp.Pics[ir.whichField])
+}
+
+func (ir *imageRepo) RetrieveCount() (int, error) {
+	//Note this is max count
+	return ir.Pr.count()
+}
+
+func (ir *imageRepo) RetrieveAll() ([]*image.Image, error) {
+	ret := []*image.Image{}
+	err := ir.Pr.forEach(func(pp *PublicProfile) error {
+		img, err := decodeImage(pp.Pics[ir.whichField])
+		if err != nil {
+			return err
+		}
+		if img != nil {
+			ret = append(ret, img)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
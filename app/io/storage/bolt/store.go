@@ -0,0 +1,119 @@
+package bolt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Store is one bbolt database file, each repo opened from it is a bucket in the file that's
+// created on the first write. The "meta" bucket is reserved for the store itself.
+// Values are gob encoded, so the types put in the generic repos have to be registered
+// with gob.Register by whoever stores them.
+type Store struct {
+	id string
+	db *bbolt.DB
+}
+
+// Open stores are kept by the id written in the file, so a generic repo nested in a stored
+// value (eg the per account folder repos) can find its database again when it's decoded.
+var (
+	storesMtx = &sync.Mutex{}
+	stores    = map[string]*Store{}
+)
+
+const metaBucket = "meta"
+
+// Open opens or creates the database file at path
+func Open(path string) (*Store, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	var id string
+	err = db.Update(func(tx *bbolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+		if err != nil {
+			return err
+		}
+		if v := meta.Get([]byte("id")); v != nil {
+			id = string(v)
+			return nil
+		}
+		id = randomName()
+		return meta.Put([]byte("id"), []byte(id))
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	storesMtx.Lock()
+	defer storesMtx.Unlock()
+	if _, ok := stores[id]; ok {
+		db.Close()
+		return nil, fmt.Errorf("bolt store %s already open", path)
+	}
+	s := &Store{id: id, db: db}
+	stores[id] = s
+	return s, nil
+}
+
+// Close flushes and closes the database file, repos from the store can't be used after
+func (s *Store) Close() error {
+	storesMtx.Lock()
+	delete(stores, s.id)
+	storesMtx.Unlock()
+	return s.db.Close()
+}
+
+func lookupStore(id string) (*Store, error) {
+	storesMtx.Lock()
+	defer storesMtx.Unlock()
+	s, ok := stores[id]
+	if !ok {
+		return nil, fmt.Errorf("bolt store %s not open", id)
+	}
+	return s, nil
+}
+
+// Helpers for keys and values
+
+// randomName for store ids and bucket names
+func randomName() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(fmt.Errorf("bolt random name: %s", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+func toKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+func fromKey(k []byte) uint64 {
+	return binary.BigEndian.Uint64(k)
+}
+
+func encode(val interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(val); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte, pVal interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(pVal)
+}
@@ -0,0 +1,171 @@
+package bolt
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/domain/repo/repotest"
+	"github.com/git-sim/tc/app/domain/service"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// testSystem the account, folder and message usecases on a bolt store
+type testSystem struct {
+	s   *Store
+	acc usecase.AccountUsecase
+	fol usecase.FoldersUsecase
+	msg usecase.MsgUsecase
+}
+
+func newTestSystem(t *testing.T, path string) *testSystem {
+	s := openTestStore(t, path)
+	dbAccounts := s.NewAccountRepo()
+	dbPending := OpenStore[usecase.PendingKeyType, entity.PendingMsgEntry](s, "pendingMsgs")
+	accServ := service.NewAccountService(dbAccounts)
+
+	ts := &testSystem{s: s}
+	ts.acc = usecase.NewAccountUsecase(dbAccounts, nil, accServ, usecase.NewRandomAccountIDs())
+	ts.fol = usecase.NewFoldersUsecase(OpenStore[entity.AccountIDType, usecase.AccountFolders](s, "folders"),
+		IndexedStoreFactory[entity.MsgIDType, entity.MsgEntry](s, usecase.FolderIndexes...),
+		StoreFactory[entity.ThreadIDType, bool](s), accServ)
+	rules := usecase.NewRulesUsecase(OpenStore[entity.AccountIDType, usecase.AccountRules](s, "rules"), ts.fol)
+	dbMsgs := OpenStore[entity.MsgIDType, entity.Msg](s, "msgs")
+	ids, err := usecase.NewPersistedIDGenerator(OpenStore[repo.GenericKeyT, uint64](s, "idMarks"), 10,
+		usecase.StoredIDs(dbMsgs, ts.fol))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.msg = usecase.NewMsgUsecase(dbMsgs, dbPending, OpenStore[entity.ThreadIDType, []entity.MsgIDType](s, "threads"),
+		ts.fol, rules, accServ, usecase.NewScheduler(usecase.NewRealClock()), ids)
+	if err := usecase.InitSubscribers(accServ, ts.fol, ts.acc, rules, dbPending); err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func (ts *testSystem) count(t *testing.T, email string, folderIdx int) int {
+	acc, err := ts.acc.GetAccount(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := usecase.ToAccountID(acc.ID)
+	out, err := ts.fol.QueryMsgs(id, usecase.QueryParams{FolderIdx: folderIdx, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.NumTotal
+}
+
+func TestRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "msgserver.db")
+	ts := newTestSystem(t, path)
+	for _, email := range []string{"alice@mail.com", "bob@mail.com"} {
+		if _, err := ts.acc.RegisterAccount(email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		SenderEmail: "alice@mail.com",
+		Recipients:  []string{"bob@mail.com", "carol@mail.com"},
+		Subject:     "hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.s.Close()
+
+	// Everything comes back from the file, carol's copy is still pending
+	ts = newTestSystem(t, path)
+	defer ts.s.Close()
+	if n := ts.count(t, "bob@mail.com", usecase.EnumInbox); n != 1 {
+		t.Errorf("bob's inbox expected 1 got %d", n)
+	}
+	if n := ts.count(t, "alice@mail.com", usecase.EnumSent); n != 1 {
+		t.Errorf("alice's sent expected 1 got %d", n)
+	}
+	msg, err := ts.msg.RetrieveMsg(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.M.Subject != "hello" {
+		t.Errorf("subject expected hello got %s", msg.M.Subject)
+	}
+	if _, err := ts.acc.RegisterAccount("carol@mail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count(t, "carol@mail.com", usecase.EnumInbox); n != 1 {
+		t.Errorf("carol's inbox expected the pending msg got %d", n)
+	}
+
+	// The ids carry on from before the restart
+	mid2, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		SenderEmail: "bob@mail.com",
+		Recipients:  []string{"alice@mail.com"},
+		Subject:     "after",
+	})
+	if err != nil || mid2 <= mid {
+		t.Errorf("msg after the restart expected an id past %d got %d %v", mid, mid2, err)
+	}
+	if msg, _ := ts.msg.RetrieveMsg(mid); msg == nil || msg.M.Subject != "hello" {
+		t.Errorf("the msg from before the restart was overwritten by %v", msg)
+	}
+}
+
+func TestAccountEmailsBuilt(t *testing.T) {
+	s := openTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+	defer s.Close()
+	// An account stored before there was the email index
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte(accountsBucket))
+		if err != nil {
+			return err
+		}
+		data, err := encode(&Account{Email: "Alice@mail.com"})
+		if err != nil {
+			return err
+		}
+		return b.Put(toKey(1), data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ar := s.NewAccountRepo()
+	if id, err := ar.RetrieveIDByEmail("alice@mail.com"); err != nil || id != 1 {
+		t.Errorf("alice before the index expected 1 got %d %v", id, err)
+	}
+	ar.Create(entity.NewAccount(2, "bob@mail.com"))
+	for email, want := range map[string]entity.AccountIDType{"alice@mail.com": 1, "bob@mail.com": 2} {
+		if id, err := ar.RetrieveIDByEmail(email); err != nil || id != want {
+			t.Errorf("%s after the index was built expected %d got %d %v", email, want, id, err)
+		}
+	}
+}
+
+func TestAccountConformance(t *testing.T) {
+	repotest.TestAccount(t, func() repo.AccountRepo {
+		s := openTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+		t.Cleanup(func() { s.Close() })
+		return s.NewAccountRepo()
+	})
+}
+
+func TestStringConformance(t *testing.T) {
+	repotest.TestString(t, func() repo.StringRepo {
+		s := openTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+		t.Cleanup(func() { s.Close() })
+		return NewStringRepo(s.NewProfileRepo(), EnumBio)
+	})
+}
+
+func TestImageConformance(t *testing.T) {
+	repotest.TestImage(t, func() repo.ImageRepo {
+		s := openTestStore(t, filepath.Join(t.TempDir(), "test.db"))
+		t.Cleanup(func() { s.Close() })
+		return NewImageRepo(s.NewProfileRepo(), EnumAvatar)
+	})
+}
@@ -0,0 +1,469 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// typedStore a bucket of gob encoded V keyed by K. Each index is a bucket of its own keyed
+// by the escaped index key followed by the id, it's kept in step in the same transaction.
+type typedStore[K repo.Key, V any] struct {
+	s       *Store
+	bucket  string
+	indexes []repo.Index[V]
+}
+
+// The indexes of the stores made by the IndexedStoreFactorys, by store type. A store
+// decoded from a reference gets them from here.
+var (
+	indexesMtx   = &sync.Mutex{}
+	storeIndexes = map[reflect.Type]interface{}{}
+)
+
+// OpenStore opens the named store, it keeps its contents across restarts
+func OpenStore[K repo.Key, V any](s *Store, name string) repo.Store[K, V] {
+	return &typedStore[K, V]{s: s, bucket: name}
+}
+
+// NewStore a store with a fresh unnamed bucket, to be found again after a restart it has to
+// be kept in another store eg the folder stores in the per account folders.
+func NewStore[K repo.Key, V any](s *Store) repo.Store[K, V] {
+	return &typedStore[K, V]{s: s, bucket: "store." + randomName()}
+}
+
+// StoreFactory makes unnamed stores. The store type is registered with gob up front so the
+// stores kept in other stores decode after a restart.
+func StoreFactory[K repo.Key, V any](s *Store) func() repo.Store[K, V] {
+	gob.Register(&typedStore[K, V]{})
+	return func() repo.Store[K, V] {
+		return NewStore[K, V](s)
+	}
+}
+
+// IndexedStoreFactory makes unnamed stores keeping the indexes. Like the StoreFactory the store
+// type is registered with gob, there's one set of indexes for each K, V.
+func IndexedStoreFactory[K repo.Key, V any](s *Store, indexes ...repo.Index[V]) func() repo.IndexedStore[K, V] {
+	gob.Register(&typedStore[K, V]{})
+	indexesMtx.Lock()
+	storeIndexes[reflect.TypeOf(&typedStore[K, V]{})] = indexes
+	indexesMtx.Unlock()
+	return func() repo.IndexedStore[K, V] {
+		return &typedStore[K, V]{s: s, bucket: "store." + randomName(), indexes: indexes}
+	}
+}
+
+// OpenIndexedStore opens the named store keeping the indexes
+func OpenIndexedStore[K repo.Key, V any](s *Store, name string, indexes ...repo.Index[V]) repo.IndexedStore[K, V] {
+	return &typedStore[K, V]{s: s, bucket: name, indexes: indexes}
+}
+
+// GobEncode a typedStore is stored as a reference to its bucket
+func (ts *typedStore[K, V]) GobEncode() ([]byte, error) {
+	return []byte(ts.s.id + "\x00" + ts.bucket), nil
+}
+
+// GobDecode reattaches to the bucket in the open store
+func (ts *typedStore[K, V]) GobDecode(data []byte) error {
+	parts := strings.SplitN(string(data), "\x00", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("bad store reference")
+	}
+	s, err := lookupStore(parts[0])
+	if err != nil {
+		return err
+	}
+	ts.s = s
+	ts.bucket = parts[1]
+	indexesMtx.Lock()
+	ts.indexes, _ = storeIndexes[reflect.TypeOf(ts)].([]repo.Index[V])
+	indexesMtx.Unlock()
+	return nil
+}
+
+func (ts *typedStore[K, V]) indexBucket(name string) []byte {
+	return []byte(ts.bucket + ".ix." + name)
+}
+
+// indexEntry the key of id in an index bucket, the escaping keeps the order of the index keys
+// whatever bytes they have: 0 is written 0 0xff and the key ends with 0 1
+func indexEntry(ixKey string, id uint64) []byte {
+	k := make([]byte, 0, len(ixKey)+10)
+	for i := 0; i < len(ixKey); i++ {
+		k = append(k, ixKey[i])
+		if ixKey[i] == 0 {
+			k = append(k, 0xff)
+		}
+	}
+	k = append(k, 0, 1)
+	return append(k, toKey(id)...)
+}
+
+// openIndex the index's bucket, it's built from the values already stored the first time so a
+// store written before it had the index gets it
+func (ts *typedStore[K, V]) openIndex(tx *bbolt.Tx, ix repo.Index[V]) (*bbolt.Bucket, error) {
+	if b := tx.Bucket(ts.indexBucket(ix.Name)); b != nil {
+		return b, nil
+	}
+	b, err := tx.CreateBucket(ts.indexBucket(ix.Name))
+	if err != nil {
+		return nil, err
+	}
+	vals := tx.Bucket([]byte(ts.bucket))
+	if vals == nil {
+		return b, nil
+	}
+	err = vals.ForEach(func(k, data []byte) error {
+		var val V
+		if err := decode(data, &val); err != nil {
+			return err
+		}
+		return b.Put(indexEntry(ix.Key(val), fromKey(k)), []byte{})
+	})
+	return b, err
+}
+
+// reindex moves id from old to val in the indexes, either can be nil
+func (ts *typedStore[K, V]) reindex(tx *bbolt.Tx, id K, old *V, val *V) error {
+	for _, ix := range ts.indexes {
+		b, err := ts.openIndex(tx, ix)
+		if err != nil {
+			return err
+		}
+		if old != nil {
+			if err := b.Delete(indexEntry(ix.Key(*old), uint64(id))); err != nil {
+				return err
+			}
+		}
+		if val != nil {
+			if err := b.Put(indexEntry(ix.Key(*val), uint64(id)), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// current the value stored for id, nil if there's none or the store has no indexes to update
+func (ts *typedStore[K, V]) current(b *bbolt.Bucket, id K) (*V, error) {
+	if len(ts.indexes) == 0 {
+		return nil, nil
+	}
+	data := b.Get(toKey(uint64(id)))
+	if data == nil {
+		return nil, nil
+	}
+	var old V
+	if err := decode(data, &old); err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+// count the values in the store's bucket. It's kept as the bucket's sequence plus one by the
+// writes, a bucket from before it was kept has 0 and is counted the slow way.
+func count(b *bbolt.Bucket) int {
+	if seq := b.Sequence(); seq > 0 {
+		return int(seq - 1)
+	}
+	return b.Stats().KeyN
+}
+
+func setCount(b *bbolt.Bucket, n int) error {
+	return b.SetSequence(uint64(n) + 1)
+}
+
+func (ts *typedStore[K, V]) createOrUpdate(id K, val V) error {
+	data, err := encode(val)
+	if err != nil {
+		return usecase.NewEs(usecase.EsArgConvFail, fmt.Sprintf("store encode %T: %s", val, err))
+	}
+	return ts.s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(ts.bucket))
+		if err != nil {
+			return err
+		}
+		old, err := ts.current(b, id)
+		if err != nil {
+			return err
+		}
+		if err := ts.reindex(tx, id, old, &val); err != nil {
+			return err
+		}
+		if b.Get(toKey(uint64(id))) == nil {
+			if err := setCount(b, count(b)+1); err != nil {
+				return err
+			}
+		}
+		return b.Put(toKey(uint64(id)), data)
+	})
+}
+
+func (ts *typedStore[K, V]) Create(id K, val V) error {
+	return ts.createOrUpdate(id, val)
+}
+
+func (ts *typedStore[K, V]) Update(id K, val V) error {
+	return ts.createOrUpdate(id, val)
+}
+
+func (ts *typedStore[K, V]) Delete(id K) error {
+	return ts.s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(ts.bucket))
+		if b == nil {
+			return nil
+		}
+		old, err := ts.current(b, id)
+		if err != nil {
+			return err
+		}
+		if old != nil {
+			if err := ts.reindex(tx, id, old, nil); err != nil {
+				return err
+			}
+		}
+		if b.Get(toKey(uint64(id))) == nil {
+			return nil
+		}
+		if err := setCount(b, count(b)-1); err != nil {
+			return err
+		}
+		return b.Delete(toKey(uint64(id)))
+	})
+}
+
+// Drop deletes the store's bucket and its index buckets
+func (ts *typedStore[K, V]) Drop() error {
+	return ts.s.db.Update(func(tx *bbolt.Tx) error {
+		names := [][]byte{}
+		prefix := ts.indexBucket("")
+		err := tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if string(name) == ts.bucket || bytes.HasPrefix(name, prefix) {
+				names = append(names, append([]byte{}, name...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ts *typedStore[K, V]) Retrieve(id K) (V, error) {
+	var ret V
+	err := ts.s.db.View(func(tx *bbolt.Tx) error {
+		var data []byte
+		if b := tx.Bucket([]byte(ts.bucket)); b != nil {
+			data = b.Get(toKey(uint64(id)))
+		}
+		if data == nil {
+			return usecase.NewEs(usecase.EsNotFound, "store id")
+		}
+		return decode(data, &ret)
+	})
+	return ret, err
+}
+
+func (ts *typedStore[K, V]) RetrieveCount() (int, error) {
+	if err := ts.keepCount(); err != nil {
+		return 0, err
+	}
+	var n int
+	err := ts.s.db.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket([]byte(ts.bucket)); b != nil {
+			n = count(b)
+		}
+		return nil
+	})
+	return n, err
+}
+
+// RetrieveFiltered the values fn keeps in key order
+func (ts *typedStore[K, V]) RetrieveFiltered(fn func(V) bool) ([]V, error) {
+	ret := []V{}
+	err := ts.s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(ts.bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, data []byte) error {
+			var val V
+			if err := decode(data, &val); err != nil {
+				return err
+			}
+			if fn(val) {
+				ret = append(ret, val)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// RetrieveAll the values in key order
+func (ts *typedStore[K, V]) RetrieveAll() ([]V, error) {
+	return ts.RetrieveFiltered(func(V) bool { return true })
+}
+
+// buildIndex makes sure the index's bucket is there when the store is
+func (ts *typedStore[K, V]) buildIndex(ix repo.Index[V]) error {
+	missing := false
+	ts.s.db.View(func(tx *bbolt.Tx) error {
+		missing = tx.Bucket([]byte(ts.bucket)) != nil && tx.Bucket(ts.indexBucket(ix.Name)) == nil
+		return nil
+	})
+	if !missing {
+		return nil
+	}
+	return ts.s.db.Update(func(tx *bbolt.Tx) error {
+		_, err := ts.openIndex(tx, ix)
+		return err
+	})
+}
+
+// keepCount makes sure the count is kept for a store written before it was, so it's only
+// counted the slow way once
+func (ts *typedStore[K, V]) keepCount() error {
+	missing := false
+	ts.s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(ts.bucket))
+		missing = b != nil && b.Sequence() == 0
+		return nil
+	})
+	if !missing {
+		return nil
+	}
+	return ts.s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(ts.bucket))
+		if b == nil || b.Sequence() > 0 {
+			return nil
+		}
+		return setCount(b, b.Stats().KeyN)
+	})
+}
+
+// Query walks the index bucket, or the store's own bucket for key order, with a cursor. The
+// values are read from the store's bucket as the index entries end with the id.
+func (ts *typedStore[K, V]) Query(q repo.Query[K, V]) (repo.QueryResult[V], error) {
+	ret := repo.QueryResult[V]{Vals: []V{}}
+	order := []byte(ts.bucket)
+	toPos := func(c *repo.Cursor[K]) []byte { return toKey(uint64(c.ID)) }
+	if q.Index != "" {
+		var index *repo.Index[V]
+		for i := range ts.indexes {
+			if ts.indexes[i].Name == q.Index {
+				index = &ts.indexes[i]
+			}
+		}
+		if index == nil {
+			return ret, usecase.NewEs(usecase.EsArgInvalid, fmt.Sprintf("index %q", q.Index))
+		}
+		order = ts.indexBucket(q.Index)
+		if err := ts.buildIndex(*index); err != nil {
+			return repo.QueryResult[V]{}, err
+		}
+		toPos = func(c *repo.Cursor[K]) []byte { return indexEntry(c.IndexKey, uint64(c.ID)) }
+	}
+
+	if q.Filter == nil {
+		if err := ts.keepCount(); err != nil {
+			return repo.QueryResult[V]{}, err
+		}
+	}
+	err := ts.s.db.View(func(tx *bbolt.Tx) error {
+		b, ob := tx.Bucket([]byte(ts.bucket)), tx.Bucket(order)
+		if b == nil || ob == nil {
+			return nil
+		}
+		c := ob.Cursor()
+		first, next := c.First, c.Next
+		if q.Desc {
+			first, next = c.Last, c.Prev
+		}
+		// pastStart whether k comes after q.After in the query order
+		pastStart := func(k []byte) bool { return true }
+		if q.After != nil {
+			pos := toPos(q.After)
+			pastStart = func(k []byte) bool {
+				if q.Desc {
+					return bytes.Compare(k, pos) < 0
+				}
+				return bytes.Compare(k, pos) > 0
+			}
+			if q.Filter == nil {
+				// Nothing to count on the way, start from there
+				k, _ := c.Seek(pos)
+				switch {
+				case q.Desc && k == nil:
+					k, _ = c.Last()
+				case q.Desc:
+					k, _ = c.Prev()
+				case bytes.Equal(k, pos):
+					k, _ = c.Next()
+				}
+				first = func() ([]byte, []byte) { return k, nil }
+			}
+		}
+		value := func(k []byte) (V, error) {
+			var val V
+			err := decode(b.Get(k[len(k)-8:]), &val)
+			return val, err
+		}
+
+		skipped := 0
+		for k, _ := first(); k != nil; k, _ = next() {
+			if q.Filter == nil && len(ret.Vals) == q.Limit {
+				break
+			}
+			if q.Filter == nil && skipped < q.Offset {
+				skipped++
+				continue
+			}
+			val, err := value(k)
+			if err != nil {
+				return err
+			}
+			if q.Filter != nil {
+				if !q.Filter(val) {
+					continue
+				}
+				ret.Total++
+				if !pastStart(k) {
+					continue
+				}
+				if skipped < q.Offset {
+					skipped++
+					continue
+				}
+			}
+			if len(ret.Vals) < q.Limit {
+				ret.Vals = append(ret.Vals, val)
+			}
+		}
+		if q.Filter == nil {
+			ret.Total = count(b)
+		}
+		return nil
+	})
+	if err != nil {
+		return repo.QueryResult[V]{}, err
+	}
+	return ret, nil
+}
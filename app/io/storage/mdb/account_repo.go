@@ -0,0 +1,194 @@
+package mdb
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// Account the stored form of entity.Account, EmailKey is the normalized email it's looked up by
+type Account struct {
+	ID        int64  `bson:"_id"`
+	Email     string `bson:"email"`
+	EmailKey  string `bson:"emailKey"`
+	FirstName string `bson:"firstName"`
+	LastName  string `bson:"lastName"`
+}
+
+// Account.toEntityAccount conversion helper
+func (ma *Account) toEntityAccount() *entity.Account {
+	ret := entity.NewAccount(entity.AccountIDType(fromKey(ma.ID)), ma.Email)
+	ret.FirstName = ma.FirstName
+	ret.LastName = ma.LastName
+	return ret
+}
+
+func fromEntityAccount(a *entity.Account) *Account {
+	return &Account{
+		ID:        toKey(uint64(a.GetID())),
+		Email:     a.GetEmail(),
+		EmailKey:  entity.NormalizeEmail(a.GetEmail()),
+		FirstName: a.GetFirstName(),
+		LastName:  a.GetLastName(),
+	}
+}
+
+// Impl of mongodb based account repository, the accounts collection keyed by account id
+// with a unique index on emailKey, the normalized email, and a plain one on email for the order
+type accountRepo struct {
+	s *Store
+}
+
+func (s *Store) NewAccountRepo() *accountRepo {
+	return &accountRepo{s: s}
+}
+
+func (r *accountRepo) c() *mongo.Collection {
+	return r.s.db.Collection(accountsColl)
+}
+
+func idFilter(id entity.AccountIDType) bson.D {
+	return bson.D{{Key: "_id", Value: toKey(uint64(id))}}
+}
+
+func (r *accountRepo) Create(a *entity.Account) error {
+	if a == nil {
+		return usecase.NewEs(usecase.EsArgInvalid, "*entity.Account")
+	}
+	// The _id and the emailKey index (unique since init) both reject a duplicate, it's the
+	// same error for either
+	_, err := r.c().InsertOne(context.Background(), fromEntityAccount(a))
+	if mongo.IsDuplicateKeyError(err) {
+		return usecase.NewEs(usecase.EsAlreadyExists, "entity.Account")
+	}
+	return err
+}
+
+func (r *accountRepo) Update(a *entity.Account) error {
+	if a == nil {
+		return usecase.NewEs(usecase.EsArgInvalid, "*entity.Account")
+	}
+	res, err := r.c().ReplaceOne(context.Background(), idFilter(a.GetID()), fromEntityAccount(a))
+	if mongo.IsDuplicateKeyError(err) {
+		return usecase.NewEs(usecase.EsAlreadyExists, "Email")
+	}
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return usecase.NewEs(usecase.EsNotFound, "entity.Account")
+	}
+	return nil
+}
+
+func (r *accountRepo) Delete(a *entity.Account) error {
+	if a == nil {
+		return usecase.NewEs(usecase.EsArgInvalid, "*entity.Account")
+	}
+	_, err := r.c().DeleteOne(context.Background(), idFilter(a.GetID()))
+	return err
+}
+
+func (r *accountRepo) findOne(filter bson.D, what string) (*entity.Account, error) {
+	var ma Account
+	err := r.c().FindOne(context.Background(), filter).Decode(&ma)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, usecase.NewEs(usecase.EsNotFound, what)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ma.toEntityAccount(), nil
+}
+
+func (r *accountRepo) Retrieve(email string) (*entity.Account, error) {
+	return r.findOne(bson.D{{Key: "emailKey", Value: entity.NormalizeEmail(email)}}, "Email")
+}
+
+func (r *accountRepo) RetrieveIDByEmail(email string) (entity.AccountIDType, error) {
+	var ma Account
+	err := r.c().FindOne(context.Background(),
+		bson.D{{Key: "emailKey", Value: entity.NormalizeEmail(email)}},
+		options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}})).Decode(&ma)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, usecase.NewEs(usecase.EsNotFound, "Email")
+	}
+	if err != nil {
+		return 0, err
+	}
+	return entity.AccountIDType(fromKey(ma.ID)), nil
+}
+
+// fillEmailKeys sets the emailKey of the accounts stored before there was one
+func (r *accountRepo) fillEmailKeys(ctx context.Context) error {
+	cur, err := r.c().Find(ctx, bson.D{{Key: "emailKey", Value: bson.D{{Key: "$exists", Value: false}}}})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var ma Account
+		if err := cur.Decode(&ma); err != nil {
+			return err
+		}
+		_, err := r.c().UpdateOne(ctx, bson.D{{Key: "_id", Value: ma.ID}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "emailKey", Value: entity.NormalizeEmail(ma.Email)}}}})
+		if err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// dropStaleIndexes drops the indexes of a store from before emailKey was the unique one, the
+// email index was unique and the emailKey one wasn't. They're made again by init.
+func (r *accountRepo) dropStaleIndexes(ctx context.Context) error {
+	specs, err := r.c().Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		unique := spec.Unique != nil && *spec.Unique
+		if (spec.Name == "email_1" && unique) || (spec.Name == "emailKey_1" && !unique) {
+			if err := r.c().Indexes().DropOne(ctx, spec.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *accountRepo) RetrieveByID(id entity.AccountIDType) (*entity.Account, error) {
+	return r.findOne(idFilter(id), "account id")
+}
+
+func (r *accountRepo) RetrieveCount() (int, error) {
+	count, err := r.c().CountDocuments(context.Background(), bson.D{})
+	return int(count), err
+}
+
+// RetrieveAll the accounts sorted by email, same as the ram repo
+func (r *accountRepo) RetrieveAll() ([]*entity.Account, error) {
+	ctx := context.Background()
+	cur, err := r.c().Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "email", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	accounts := []*entity.Account{}
+	for cur.Next(ctx) {
+		var ma Account
+		if err := cur.Decode(&ma); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, ma.toEntityAccount())
+	}
+	return accounts, cur.Err()
+}
@@ -0,0 +1,72 @@
+package mdb
+
+import (
+	"encoding/gob"
+
+	"github.com/git-sim/tc/app/domain/repo"
+)
+
+// genericRepo gob encoded values keyed by id, a typedStore of genericVals
+type genericRepo struct {
+	typedStore[repo.GenericKeyT, genericVal]
+}
+
+// genericVal wraps the value so gob records its concrete type
+type genericVal struct {
+	V interface{}
+}
+
+func init() {
+	// genericRepos can be stored inside values of other genericRepos
+	gob.Register(&genericRepo{})
+}
+
+func newGenericRepo(s *Store, coll string, repoID string) *genericRepo {
+	return &genericRepo{typedStore[repo.GenericKeyT, genericVal]{s: s, coll: coll, repo: repoID}}
+}
+
+// GenericRepo opens the repo kept in the named collection
+func (s *Store) GenericRepo(name string) repo.Generic {
+	return newGenericRepo(s, name, "")
+}
+
+// NewGenericRepo a new repo in the entries collection, to be found again after a restart it
+// has to be stored in another repo eg the folder repos in the per account folders.
+func (s *Store) NewGenericRepo() repo.Generic {
+	return newGenericRepo(s, entriesColl, randomName())
+}
+
+func (gr *genericRepo) Create(id repo.GenericKeyT, val interface{}) error {
+	return gr.typedStore.Create(id, genericVal{V: val})
+}
+
+func (gr *genericRepo) Update(id repo.GenericKeyT, val interface{}) error {
+	return gr.typedStore.Update(id, genericVal{V: val})
+}
+
+func (gr *genericRepo) Retrieve(id repo.GenericKeyT) (interface{}, error) {
+	gv, err := gr.typedStore.Retrieve(id)
+	if err != nil {
+		return nil, err
+	}
+	return gv.V, nil
+}
+
+func (gr *genericRepo) RetrieveFiltered(fn func(interface{}) bool) ([]interface{}, error) {
+	gvs, err := gr.typedStore.RetrieveFiltered(func(gv genericVal) bool {
+		return gv.V != nil && fn(gv.V)
+	})
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]interface{}, len(gvs))
+	for i, gv := range gvs {
+		ret[i] = gv.V
+	}
+	return ret, nil
+}
+
+// RetrieveAll the values in key order
+func (gr *genericRepo) RetrieveAll() ([]interface{}, error) {
+	return gr.RetrieveFiltered(func(interface{}) bool { return true })
+}
@@ -0,0 +1,95 @@
+package mdb
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// testURI the mongodb the tests run against. MDB_TEST_URI picks one, otherwise a mongod
+// is started from the PATH for the run. With neither the tests are skipped.
+var testURI string
+
+func TestMain(m *testing.M) {
+	stop := func() {}
+	testURI = os.Getenv("MDB_TEST_URI")
+	if testURI == "" {
+		var err error
+		testURI, stop, err = startMongod()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "mdb tests: couldn't start mongod:", err)
+		}
+	}
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+// startMongod runs a throwaway mongod on a free port, no uri if there's no mongod installed
+func startMongod() (string, func(), error) {
+	bin, err := exec.LookPath("mongod")
+	if err != nil {
+		return "", func() {}, nil
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", func() {}, err
+	}
+	addr := l.Addr().String()
+	port := fmt.Sprint(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+	dir, err := os.MkdirTemp("", "mdbtest")
+	if err != nil {
+		return "", func() {}, err
+	}
+
+	cmd := exec.Command(bin, "--dbpath", dir, "--port", port, "--bind_ip", "127.0.0.1", "--quiet")
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return "", func() {}, err
+	}
+	stop := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.RemoveAll(dir)
+	}
+	for deadline := time.Now().Add(20 * time.Second); time.Now().Before(deadline); {
+		if c, err := net.Dial("tcp", addr); err == nil {
+			c.Close()
+			return "mongodb://" + addr, stop, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	stop()
+	return "", func() {}, fmt.Errorf("mongod didn't come up on %s", addr)
+}
+
+func skipWithoutMongod(t *testing.T) {
+	if testURI == "" {
+		t.Skip("no mongod, set MDB_TEST_URI or install mongod to run")
+	}
+}
+
+// openTestStore opens the named database, a fresh one if dbName is empty. It's dropped
+// at the end of the test.
+func openTestStore(t *testing.T, dbName string) *Store {
+	skipWithoutMongod(t)
+	if dbName == "" {
+		dbName = "tctest_" + randomName()[:12]
+	}
+	s, err := Open(testURI, dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+		if s, err := Open(testURI, dbName); err == nil {
+			s.Drop()
+			s.Close()
+		}
+	})
+	return s
+}
@@ -0,0 +1,279 @@
+package mdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/git-sim/tc/app/usecase"
+)
+
+const (
+	EnumFirstName = iota
+	EnumLastName
+	EnumSalutation //Mr., Ms., Dr., Lt Col., etc
+	EnumSuffix     //Jr., Sr., III, PhD, Esq, etc
+	EnumBio
+	EnumNumProfileStringFields
+)
+const (
+	EnumAvatar = iota
+	EnumBackground
+	EnumNumProfileImageFields
+)
+
+// PublicProfile the stored form of a profile, fields are keyed by their enum, the pics
+// are png encoded
+type PublicProfile struct {
+	ID          int64             `bson:"_id"`
+	NameAndBios map[string]string `bson:"strings,omitempty"`
+	Pics        map[string][]byte `bson:"pics,omitempty"`
+}
+
+// Impl of mongodb based profile repository, the profiles collection keyed by id. The string
+// and image repos below are views on one field of the profiles.
+type profileRepo struct {
+	s *Store
+}
+
+func (s *Store) NewProfileRepo() *profileRepo {
+	return &profileRepo{s: s}
+}
+
+func (pr *profileRepo) c() *mongo.Collection {
+	return pr.s.db.Collection(profilesColl)
+}
+
+// set one field of the profile for id, creating the profile if needed. nil val removes
+// the field, and the profile once it has no fields left.
+func (pr *profileRepo) set(id uint64, field string, val interface{}) error {
+	ctx := context.Background()
+	filter := bson.D{{Key: "_id", Value: toKey(id)}}
+	if val != nil {
+		_, err := pr.c().UpdateOne(ctx, filter,
+			bson.D{{Key: "$set", Value: bson.D{{Key: field, Value: val}}}},
+			options.UpdateOne().SetUpsert(true))
+		return err
+	}
+
+	_, err := pr.c().UpdateOne(ctx, filter,
+		bson.D{{Key: "$unset", Value: bson.D{{Key: field, Value: ""}}}})
+	if err != nil {
+		return err
+	}
+	empty := func(name string) bson.D {
+		return bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: name, Value: bson.D{{Key: "$exists", Value: false}}}},
+			bson.D{{Key: name, Value: bson.D{}}},
+		}}}
+	}
+	_, err = pr.c().DeleteOne(ctx, bson.D{
+		{Key: "_id", Value: toKey(id)},
+		{Key: "$and", Value: bson.A{empty("strings"), empty("pics")}},
+	})
+	return err
+}
+
+func (pr *profileRepo) retrieve(id uint64) (*PublicProfile, error) {
+	var pp PublicProfile
+	err := pr.c().FindOne(context.Background(), bson.D{{Key: "_id", Value: toKey(id)}}).Decode(&pp)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pp, nil
+}
+
+// forEach calls fn for every profile with field set, in id order
+func (pr *profileRepo) forEach(field string, fn func(pp *PublicProfile) error) error {
+	ctx := context.Background()
+	cur, err := pr.c().Find(ctx, bson.D{{Key: field, Value: bson.D{{Key: "$exists", Value: true}}}},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var pp PublicProfile
+		if err := cur.Decode(&pp); err != nil {
+			return err
+		}
+		if err := fn(&pp); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (pr *profileRepo) count() (int, error) {
+	count, err := pr.c().CountDocuments(context.Background(), bson.D{})
+	return int(count), err
+}
+
+// A port replicator so the usecases can treat the repo's separately
+type stringRepo struct {
+	Pr         *profileRepo
+	whichField int
+}
+
+func NewStringRepo(pr *profileRepo, which int) *stringRepo {
+	// Should be an assert
+	if which >= EnumNumProfileStringFields {
+		log.Fatal(fmt.Errorf("invalid String Repo Enum"))
+	}
+	return &stringRepo{
+		Pr:         pr,
+		whichField: which,
+	}
+}
+
+func (sr *stringRepo) key() string {
+	return strconv.Itoa(sr.whichField)
+}
+
+func (sr *stringRepo) set(id uint64, val string) error {
+	if val == "" {
+		return sr.Pr.set(id, "strings."+sr.key(), nil)
+	}
+	return sr.Pr.set(id, "strings."+sr.key(), val)
+}
+
+func (sr *stringRepo) Create(id uint64, val string) error {
+	return sr.set(id, val)
+}
+
+func (sr *stringRepo) Update(id uint64, val string) error {
+	return sr.set(id, val)
+}
+
+func (sr *stringRepo) Delete(id uint64) error {
+	return sr.set(id, "")
+}
+
+func (sr *stringRepo) Retrieve(id uint64) (string, error) {
+	pp, err := sr.Pr.retrieve(id)
+	if err != nil {
+		return "", err
+	}
+	if pp == nil {
+		return "", usecase.NewEs(usecase.EsNotFound, "stringRepo id")
+	}
+	return pp.NameAndBios[sr.key()], nil
+}
+
+func (sr *stringRepo) RetrieveCount() (int, error) {
+	//Note this is max count
+	return sr.Pr.count()
+}
+
+func (sr *stringRepo) RetrieveAll() ([]*string, error) {
+	ret := []*string{}
+	err := sr.Pr.forEach("strings."+sr.key(), func(pp *PublicProfile) error {
+		val := pp.NameAndBios[sr.key()]
+		ret = append(ret, &val)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Image Repo making interfaces to the profileRepo, same as the stringRepo except the
+// images are png encoded on the way in.
+type imageRepo struct {
+	Pr         *profileRepo
+	whichField int
+}
+
+func NewImageRepo(pr *profileRepo, which int) *imageRepo {
+	// Should be an assert
+	if which >= EnumNumProfileImageFields {
+		log.Fatal(fmt.Errorf("invalid image Repo Enum"))
+	}
+	return &imageRepo{
+		Pr:         pr,
+		whichField: which,
+	}
+}
+
+func (ir *imageRepo) key() string {
+	return strconv.Itoa(ir.whichField)
+}
+
+func decodeImage(data []byte) (*image.Image, error) {
+	if data == nil {
+		return nil, nil
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, usecase.NewEs(usecase.EsArgConvFail, fmt.Sprintf("imageRepo decode: %s", err))
+	}
+	return &img, nil
+}
+
+func (ir *imageRepo) set(id uint64, val *image.Image) error {
+	if val == nil || *val == nil {
+		return ir.Pr.set(id, "pics."+ir.key(), nil)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, *val); err != nil {
+		return usecase.NewEs(usecase.EsArgConvFail, fmt.Sprintf("imageRepo encode: %s", err))
+	}
+	return ir.Pr.set(id, "pics."+ir.key(), buf.Bytes())
+}
+
+func (ir *imageRepo) Create(id uint64, val *image.Image) error {
+	return ir.set(id, val)
+}
+
+func (ir *imageRepo) Update(id uint64, val *image.Image) error {
+	return ir.set(id, val)
+}
+
+func (ir *imageRepo) Delete(id uint64) error {
+	return ir.set(id, nil)
+}
+
+func (ir *imageRepo) Retrieve(id uint64) (*image.Image, error) {
+	pp, err := ir.Pr.retrieve(id)
+	if err != nil {
+		return nil, err
+	}
+	if pp == nil {
+		return nil, usecase.NewEs(usecase.EsNotFound, "imageRepo id")
+	}
+	return decodeImage(pp.Pics[ir.key()])
+}
+
+func (ir *imageRepo) RetrieveCount() (int, error) {
+	//Note this is max count
+	return ir.Pr.count()
+}
+
+func (ir *imageRepo) RetrieveAll() ([]*image.Image, error) {
+	ret := []*image.Image{}
+	err := ir.Pr.forEach("pics."+ir.key(), func(pp *PublicProfile) error {
+		img, err := decodeImage(pp.Pics[ir.key()])
+		if err != nil {
+			return err
+		}
+		ret = append(ret, img)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,196 @@
+package mdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Store is one mongodb database. The named repos are collections, messages are keyed by
+// their id in _id. The repos created on demand (the per account folders) share the
+// entries collection, indexed by repo and key, and are found from the folders collection
+// keyed by account id. Emails are unique in the accounts collection, by their normalized emailKey.
+// Values are gob encoded, so the types put in the generic repos have to be registered
+// with gob.Register by whoever stores them.
+type Store struct {
+	id     string
+	client *mongo.Client
+	db     *mongo.Database
+
+	ixMtx  *sync.Mutex
+	ixMade map[string]bool // the indexes of the indexed stores created or filled so far
+}
+
+// Open stores are kept by the id saved in the database, so a generic repo nested in a stored
+// value can find its database again when it's decoded.
+var (
+	storesMtx = &sync.Mutex{}
+	stores    = map[string]*Store{}
+)
+
+const (
+	metaColl     = "meta"
+	entriesColl  = "entries"
+	accountsColl = "accounts"
+	profilesColl = "profiles"
+)
+
+// opTimeout bounds every operation on the database
+const opTimeout = 10 * time.Second
+
+// Open connects to the mongodb at uri and uses the named database, creating the indexes
+func Open(uri string, dbName string) (*Store, error) {
+	client, err := mongo.Connect(options.Client().ApplyURI(uri).
+		SetTimeout(opTimeout).SetServerSelectionTimeout(opTimeout))
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{client: client, db: client.Database(dbName), ixMtx: &sync.Mutex{}, ixMade: map[string]bool{}}
+	if err := s.init(); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	storesMtx.Lock()
+	defer storesMtx.Unlock()
+	if _, ok := stores[s.id]; ok {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("mdb store %s already open", dbName)
+	}
+	stores[s.id] = s
+	return s, nil
+}
+
+// init reads or assigns the store id and creates the indexes
+func (s *Store) init() error {
+	ctx := context.Background()
+	var meta struct {
+		StoreID string `bson:"storeId"`
+	}
+	err := s.db.Collection(metaColl).FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: "store"}},
+		bson.D{{Key: "$setOnInsert", Value: bson.D{{Key: "storeId", Value: randomName()}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&meta)
+	if err != nil {
+		return err
+	}
+	s.id = meta.StoreID
+
+	// The emailKey has to be there on every account before its index is made unique
+	accounts := s.NewAccountRepo()
+	if err := accounts.fillEmailKeys(ctx); err != nil {
+		return err
+	}
+	if err := accounts.dropStaleIndexes(ctx); err != nil {
+		return err
+	}
+
+	indexes := []struct {
+		coll  string
+		model mongo.IndexModel
+	}{
+		{accountsColl, mongo.IndexModel{
+			Keys: bson.D{{Key: "email", Value: 1}},
+		}},
+		{accountsColl, mongo.IndexModel{
+			Keys:    bson.D{{Key: "emailKey", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		{entriesColl, mongo.IndexModel{
+			Keys:    bson.D{{Key: "repo", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+	}
+	for _, idx := range indexes {
+		if _, err := s.db.Collection(idx.coll).Indexes().CreateOne(ctx, idx.model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureIndex creates the index on keys in coll the first time it's asked for
+func (s *Store) ensureIndex(coll string, keys bson.D) error {
+	name := coll
+	for _, k := range keys {
+		name += "\x00" + k.Key
+	}
+	s.ixMtx.Lock()
+	defer s.ixMtx.Unlock()
+	if s.ixMade[name] {
+		return nil
+	}
+	_, err := s.db.Collection(coll).Indexes().CreateOne(context.Background(), mongo.IndexModel{Keys: keys})
+	if err != nil {
+		return err
+	}
+	s.ixMade[name] = true
+	return nil
+}
+
+// Close disconnects, repos from the store can't be used after
+func (s *Store) Close() error {
+	storesMtx.Lock()
+	delete(stores, s.id)
+	storesMtx.Unlock()
+	return s.client.Disconnect(context.Background())
+}
+
+// Drop removes the database and everything in it, for tests
+func (s *Store) Drop() error {
+	return s.db.Drop(context.Background())
+}
+
+func lookupStore(id string) (*Store, error) {
+	storesMtx.Lock()
+	defer storesMtx.Unlock()
+	s, ok := stores[id]
+	if !ok {
+		return nil, fmt.Errorf("mdb store %s not open", id)
+	}
+	return s, nil
+}
+
+// Helpers for keys and values
+
+// randomName for store and repo ids
+func randomName() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(fmt.Errorf("mdb random name: %s", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// toKey mongodb has no unsigned ints, the ids are stored as int64 with the top bit flipped
+// so they sort the same as the uint64s
+func toKey(id uint64) int64 {
+	return int64(id ^ 1<<63)
+}
+
+func fromKey(k int64) uint64 {
+	return uint64(k) ^ 1<<63
+}
+
+func encode(val interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(val); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte, pVal interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(pVal)
+}
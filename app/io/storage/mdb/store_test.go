@@ -0,0 +1,165 @@
+package mdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/domain/repo/repotest"
+	"github.com/git-sim/tc/app/domain/service"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+func TestGenericConformance(t *testing.T) {
+	s := openTestStore(t, "")
+	t.Run("named", func(t *testing.T) {
+		n := 0
+		repotest.TestGeneric(t, func() repo.Generic {
+			n++
+			return s.GenericRepo(fmt.Sprintf("generic%d", n))
+		})
+	})
+	t.Run("unnamed", func(t *testing.T) {
+		repotest.TestGeneric(t, s.NewGenericRepo)
+	})
+}
+
+func TestStoreConformance(t *testing.T) {
+	s := openTestStore(t, "")
+	t.Run("named", func(t *testing.T) {
+		n := 0
+		repotest.TestStore(t, func() repo.Store[repo.GenericKeyT, repotest.Value] {
+			n++
+			return OpenStore[repo.GenericKeyT, repotest.Value](s, fmt.Sprintf("store%d", n))
+		})
+	})
+	t.Run("unnamed", func(t *testing.T) {
+		repotest.TestStore(t, StoreFactory[repo.GenericKeyT, repotest.Value](s))
+	})
+}
+
+func TestIndexedStoreConformance(t *testing.T) {
+	s := openTestStore(t, "")
+	t.Run("named", func(t *testing.T) {
+		n := 0
+		repotest.TestIndexedStore(t, func(indexes ...repo.Index[repotest.Value]) repo.IndexedStore[repo.GenericKeyT, repotest.Value] {
+			n++
+			return OpenIndexedStore[repo.GenericKeyT, repotest.Value](s, fmt.Sprintf("indexed%d", n), indexes...)
+		})
+	})
+	t.Run("unnamed", func(t *testing.T) {
+		repotest.TestIndexedStore(t, func(indexes ...repo.Index[repotest.Value]) repo.IndexedStore[repo.GenericKeyT, repotest.Value] {
+			return IndexedStoreFactory[repo.GenericKeyT, repotest.Value](s, indexes...)()
+		})
+	})
+}
+
+func TestAccountConformance(t *testing.T) {
+	skipWithoutMongod(t)
+	repotest.TestAccount(t, func() repo.AccountRepo {
+		return openTestStore(t, "").NewAccountRepo()
+	})
+}
+
+func TestStringConformance(t *testing.T) {
+	skipWithoutMongod(t)
+	repotest.TestString(t, func() repo.StringRepo {
+		return NewStringRepo(openTestStore(t, "").NewProfileRepo(), EnumBio)
+	})
+}
+
+func TestImageConformance(t *testing.T) {
+	skipWithoutMongod(t)
+	repotest.TestImage(t, func() repo.ImageRepo {
+		return NewImageRepo(openTestStore(t, "").NewProfileRepo(), EnumAvatar)
+	})
+}
+
+func TestUniqueEmail(t *testing.T) {
+	ar := openTestStore(t, "").NewAccountRepo()
+	if err := ar.Create(entity.NewAccount(1, "alice@mail.com")); err != nil {
+		t.Fatal(err)
+	}
+	err := ar.Create(entity.NewAccount(2, "alice@mail.com"))
+	if !usecase.CheckEs(err, usecase.EsAlreadyExists) {
+		t.Errorf("second account with the same email expected EsAlreadyExists got %v", err)
+	}
+}
+
+// testSystem the account, folder and message usecases on a mongodb store
+type testSystem struct {
+	s   *Store
+	acc usecase.AccountUsecase
+	fol usecase.FoldersUsecase
+	msg usecase.MsgUsecase
+}
+
+func newTestSystem(t *testing.T, dbName string) *testSystem {
+	s := openTestStore(t, dbName)
+	dbAccounts := s.NewAccountRepo()
+	dbPending := OpenStore[usecase.PendingKeyType, entity.PendingMsgEntry](s, "pendingMsgs")
+	accServ := service.NewAccountService(dbAccounts)
+
+	ts := &testSystem{s: s}
+	ts.acc = usecase.NewAccountUsecase(dbAccounts, nil, accServ, usecase.NewRandomAccountIDs())
+	ts.fol = usecase.NewFoldersUsecase(OpenStore[entity.AccountIDType, usecase.AccountFolders](s, "folders"),
+		IndexedStoreFactory[entity.MsgIDType, entity.MsgEntry](s, usecase.FolderIndexes...),
+		StoreFactory[entity.ThreadIDType, bool](s), accServ)
+	rules := usecase.NewRulesUsecase(OpenStore[entity.AccountIDType, usecase.AccountRules](s, "rules"), ts.fol)
+	dbMsgs := OpenStore[entity.MsgIDType, entity.Msg](s, "msgs")
+	ids, err := usecase.NewPersistedIDGenerator(OpenStore[repo.GenericKeyT, uint64](s, "idMarks"), 10,
+		usecase.StoredIDs(dbMsgs, ts.fol))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.msg = usecase.NewMsgUsecase(dbMsgs, dbPending, OpenStore[entity.ThreadIDType, []entity.MsgIDType](s, "threads"),
+		ts.fol, rules, accServ, usecase.NewScheduler(usecase.NewRealClock()), ids)
+	if err := usecase.InitSubscribers(accServ, ts.fol, ts.acc, rules, dbPending); err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func (ts *testSystem) count(t *testing.T, email string, folderIdx int) int {
+	acc, err := ts.acc.GetAccount(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := usecase.ToAccountID(acc.ID)
+	out, err := ts.fol.QueryMsgs(id, usecase.QueryParams{FolderIdx: folderIdx, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.NumTotal
+}
+
+func TestRestart(t *testing.T) {
+	dbName := "tctest_" + randomName()[:12]
+	ts := newTestSystem(t, dbName)
+	for _, email := range []string{"alice@mail.com", "bob@mail.com"} {
+		if _, err := ts.acc.RegisterAccount(email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		SenderEmail: "alice@mail.com",
+		Recipients:  []string{"bob@mail.com", "carol@mail.com"},
+		Subject:     "hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.s.Close()
+
+	ts = newTestSystem(t, dbName)
+	if n := ts.count(t, "bob@mail.com", usecase.EnumInbox); n != 1 {
+		t.Errorf("bob's inbox expected 1 got %d", n)
+	}
+	if _, err := ts.acc.RegisterAccount("carol@mail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count(t, "carol@mail.com", usecase.EnumInbox); n != 1 {
+		t.Errorf("carol's inbox expected the pending msg got %d", n)
+	}
+}
@@ -0,0 +1,412 @@
+package mdb
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// typedStore gob encoded V keyed by K. A named store has its own collection keyed by _id,
+// the unnamed ones share the entries collection and are told apart by the repo field.
+// The index keys are kept in the document's ix field, queries sort on them in mongo.
+type typedStore[K repo.Key, V any] struct {
+	s       *Store
+	coll    string
+	repo    string // empty for named stores
+	indexes []repo.Index[V]
+}
+
+// storeDoc the stored document
+type storeDoc struct {
+	V  []byte            `bson:"v"`
+	Ix map[string]string `bson:"ix,omitempty"`
+}
+
+// The indexes of the stores made by the IndexedStoreFactorys, by store type. A store
+// decoded from a reference gets them from here.
+var (
+	indexesMtx   = &sync.Mutex{}
+	storeIndexes = map[reflect.Type]interface{}{}
+)
+
+// OpenStore opens the store kept in the named collection
+func OpenStore[K repo.Key, V any](s *Store, name string) repo.Store[K, V] {
+	return &typedStore[K, V]{s: s, coll: name}
+}
+
+// NewStore a new store in the entries collection, to be found again after a restart it has
+// to be kept in another store eg the folder stores in the per account folders.
+func NewStore[K repo.Key, V any](s *Store) repo.Store[K, V] {
+	return &typedStore[K, V]{s: s, coll: entriesColl, repo: randomName()}
+}
+
+// StoreFactory makes unnamed stores. The store type is registered with gob up front so the
+// stores kept in other stores decode after a restart.
+func StoreFactory[K repo.Key, V any](s *Store) func() repo.Store[K, V] {
+	gob.Register(&typedStore[K, V]{})
+	return func() repo.Store[K, V] {
+		return NewStore[K, V](s)
+	}
+}
+
+// IndexedStoreFactory makes unnamed stores keeping the indexes. Like the StoreFactory the store
+// type is registered with gob, there's one set of indexes for each K, V.
+func IndexedStoreFactory[K repo.Key, V any](s *Store, indexes ...repo.Index[V]) func() repo.IndexedStore[K, V] {
+	gob.Register(&typedStore[K, V]{})
+	indexesMtx.Lock()
+	storeIndexes[reflect.TypeOf(&typedStore[K, V]{})] = indexes
+	indexesMtx.Unlock()
+	return func() repo.IndexedStore[K, V] {
+		return &typedStore[K, V]{s: s, coll: entriesColl, repo: randomName(), indexes: indexes}
+	}
+}
+
+// OpenIndexedStore opens the store kept in the named collection keeping the indexes
+func OpenIndexedStore[K repo.Key, V any](s *Store, name string, indexes ...repo.Index[V]) repo.IndexedStore[K, V] {
+	return &typedStore[K, V]{s: s, coll: name, indexes: indexes}
+}
+
+// GobEncode a typedStore is stored as a reference to its collection and repo id
+func (ts *typedStore[K, V]) GobEncode() ([]byte, error) {
+	return []byte(strings.Join([]string{ts.s.id, ts.coll, ts.repo}, "\x00")), nil
+}
+
+// GobDecode reattaches to the open store
+func (ts *typedStore[K, V]) GobDecode(data []byte) error {
+	parts := strings.Split(string(data), "\x00")
+	if len(parts) != 3 {
+		return fmt.Errorf("bad store reference")
+	}
+	s, err := lookupStore(parts[0])
+	if err != nil {
+		return err
+	}
+	ts.s = s
+	ts.coll = parts[1]
+	ts.repo = parts[2]
+	indexesMtx.Lock()
+	ts.indexes, _ = storeIndexes[reflect.TypeOf(ts)].([]repo.Index[V])
+	indexesMtx.Unlock()
+	return nil
+}
+
+func (ts *typedStore[K, V]) c() *mongo.Collection {
+	return ts.s.db.Collection(ts.coll)
+}
+
+// keyFilter selects the document for id
+func (ts *typedStore[K, V]) keyFilter(id K) bson.D {
+	if ts.repo == "" {
+		return bson.D{{Key: "_id", Value: toKey(uint64(id))}}
+	}
+	return bson.D{{Key: "repo", Value: ts.repo}, {Key: "key", Value: toKey(uint64(id))}}
+}
+
+// allFilter selects all the documents of the store, sortKey orders them by key
+func (ts *typedStore[K, V]) allFilter() (filter bson.D, sortKey bson.D) {
+	return ts.repoFilter(), bson.D{{Key: ts.keyField(), Value: 1}}
+}
+
+func (ts *typedStore[K, V]) repoFilter() bson.D {
+	if ts.repo == "" {
+		return bson.D{}
+	}
+	return bson.D{{Key: "repo", Value: ts.repo}}
+}
+
+func (ts *typedStore[K, V]) keyField() string {
+	if ts.repo == "" {
+		return "_id"
+	}
+	return "key"
+}
+
+// ensureIndexes creates the mongo indexes backing the store's indexes
+func (ts *typedStore[K, V]) ensureIndexes() error {
+	for _, ix := range ts.indexes {
+		keys := bson.D{{Key: "ix." + ix.Name, Value: 1}, {Key: ts.keyField(), Value: 1}}
+		if ts.repo != "" {
+			keys = append(bson.D{{Key: "repo", Value: 1}}, keys...)
+		}
+		if err := ts.s.ensureIndex(ts.coll, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ts *typedStore[K, V]) createOrUpdate(id K, val V) error {
+	data, err := encode(val)
+	if err != nil {
+		return usecase.NewEs(usecase.EsArgConvFail, fmt.Sprintf("store encode %T: %s", val, err))
+	}
+	doc := append(ts.keyFilter(id), bson.E{Key: "v", Value: data})
+	if len(ts.indexes) > 0 {
+		if err := ts.ensureIndexes(); err != nil {
+			return err
+		}
+		ix := bson.D{}
+		for _, index := range ts.indexes {
+			ix = append(ix, bson.E{Key: index.Name, Value: index.Key(val)})
+		}
+		doc = append(doc, bson.E{Key: "ix", Value: ix})
+	}
+	_, err = ts.c().ReplaceOne(context.Background(), ts.keyFilter(id), doc,
+		options.Replace().SetUpsert(true))
+	return err
+}
+
+func (ts *typedStore[K, V]) Create(id K, val V) error {
+	return ts.createOrUpdate(id, val)
+}
+
+func (ts *typedStore[K, V]) Update(id K, val V) error {
+	return ts.createOrUpdate(id, val)
+}
+
+func (ts *typedStore[K, V]) Delete(id K) error {
+	_, err := ts.c().DeleteOne(context.Background(), ts.keyFilter(id))
+	return err
+}
+
+// Drop a named store drops its collection, an unnamed one deletes its documents from the
+// shared entries collection
+func (ts *typedStore[K, V]) Drop() error {
+	if ts.repo == "" {
+		return ts.c().Drop(context.Background())
+	}
+	_, err := ts.c().DeleteMany(context.Background(), ts.repoFilter())
+	return err
+}
+
+func (ts *typedStore[K, V]) Retrieve(id K) (V, error) {
+	var ret V
+	var doc storeDoc
+	err := ts.c().FindOne(context.Background(), ts.keyFilter(id)).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ret, usecase.NewEs(usecase.EsNotFound, "store id")
+	}
+	if err != nil {
+		return ret, err
+	}
+	err = decode(doc.V, &ret)
+	return ret, err
+}
+
+func (ts *typedStore[K, V]) RetrieveCount() (int, error) {
+	filter, _ := ts.allFilter()
+	count, err := ts.c().CountDocuments(context.Background(), filter)
+	return int(count), err
+}
+
+// RetrieveFiltered the values fn keeps in key order
+func (ts *typedStore[K, V]) RetrieveFiltered(fn func(V) bool) ([]V, error) {
+	ctx := context.Background()
+	filter, sortKey := ts.allFilter()
+	cur, err := ts.c().Find(ctx, filter, options.Find().SetSort(sortKey))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	ret := []V{}
+	for cur.Next(ctx) {
+		var doc storeDoc
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		var val V
+		if err := decode(doc.V, &val); err != nil {
+			return nil, err
+		}
+		if fn(val) {
+			ret = append(ret, val)
+		}
+	}
+	return ret, cur.Err()
+}
+
+// RetrieveAll the values in key order
+func (ts *typedStore[K, V]) RetrieveAll() ([]V, error) {
+	return ts.RetrieveFiltered(func(V) bool { return true })
+}
+
+// fillIndex sets the index key of the documents stored before the store had the index, it's
+// checked once per store and index
+func (ts *typedStore[K, V]) fillIndex(name string) error {
+	done := strings.Join([]string{"fill", ts.coll, ts.repo, name}, "\x00")
+	ts.s.ixMtx.Lock()
+	filled := ts.s.ixMade[done]
+	ts.s.ixMtx.Unlock()
+	if filled {
+		return nil
+	}
+
+	var index repo.Index[V]
+	for _, ix := range ts.indexes {
+		if ix.Name == name {
+			index = ix
+		}
+	}
+	ctx := context.Background()
+	filter := append(ts.repoFilter(), bson.E{Key: "ix." + name, Value: bson.D{{Key: "$exists", Value: false}}})
+	cur, err := ts.c().Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var doc storeDoc
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		var val V
+		if err := decode(doc.V, &val); err != nil {
+			return err
+		}
+		_, err := ts.c().UpdateOne(ctx, ts.keyFilter(ts.docID(cur)),
+			bson.D{{Key: "$set", Value: bson.D{{Key: "ix." + name, Value: index.Key(val)}}}})
+		if err != nil {
+			return err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	ts.s.ixMtx.Lock()
+	ts.s.ixMade[done] = true
+	ts.s.ixMtx.Unlock()
+	return nil
+}
+
+// Query sorts on the index key in mongo. Without a filter mongo skips to the page and counts,
+// with one every value is read in order and filtered here.
+func (ts *typedStore[K, V]) Query(q repo.Query[K, V]) (repo.QueryResult[V], error) {
+	ret := repo.QueryResult[V]{Vals: []V{}}
+	ixField := ""
+	if q.Index != "" {
+		for _, ix := range ts.indexes {
+			if ix.Name == q.Index {
+				ixField = "ix." + ix.Name
+			}
+		}
+		if ixField == "" {
+			return ret, usecase.NewEs(usecase.EsArgInvalid, fmt.Sprintf("index %q", q.Index))
+		}
+		if err := ts.ensureIndexes(); err != nil {
+			return ret, err
+		}
+		if err := ts.fillIndex(q.Index); err != nil {
+			return ret, err
+		}
+	}
+	ctx := context.Background()
+	dir, cmp := 1, "$gt"
+	if q.Desc {
+		dir, cmp = -1, "$lt"
+	}
+	sortKey := bson.D{{Key: ts.keyField(), Value: dir}}
+	if ixField != "" {
+		sortKey = append(bson.D{{Key: ixField, Value: dir}}, sortKey...)
+	}
+
+	filter := ts.repoFilter()
+	opts := options.Find().SetSort(sortKey)
+	if q.Filter == nil {
+		count, err := ts.c().CountDocuments(ctx, filter)
+		if err != nil {
+			return ret, err
+		}
+		ret.Total = int(count)
+		if q.Limit == 0 {
+			return ret, nil
+		}
+		if q.After != nil {
+			after := bson.D{{Key: ts.keyField(), Value: bson.D{{Key: cmp, Value: toKey(uint64(q.After.ID))}}}}
+			if ixField != "" {
+				after = bson.D{{Key: "$or", Value: bson.A{
+					bson.D{{Key: ixField, Value: bson.D{{Key: cmp, Value: q.After.IndexKey}}}},
+					append(bson.D{{Key: ixField, Value: q.After.IndexKey}}, after...),
+				}}}
+			}
+			filter = append(filter, after...)
+		}
+		opts.SetSkip(int64(q.Offset)).SetLimit(int64(q.Limit))
+	}
+
+	cur, err := ts.c().Find(ctx, filter, opts)
+	if err != nil {
+		return repo.QueryResult[V]{}, err
+	}
+	defer cur.Close(ctx)
+
+	// pastStart whether the document comes after q.After in the query order
+	pastStart := func(doc *storeDoc, id K) bool {
+		if q.After == nil {
+			return true
+		}
+		ixKey := ""
+		if ixField != "" {
+			ixKey = doc.Ix[q.Index]
+		}
+		c := strings.Compare(ixKey, q.After.IndexKey)
+		if c == 0 && id != q.After.ID {
+			c = 1
+			if id < q.After.ID {
+				c = -1
+			}
+		}
+		if q.Desc {
+			return c < 0
+		}
+		return c > 0
+	}
+	skipped := 0
+	for cur.Next(ctx) {
+		var doc storeDoc
+		if err := cur.Decode(&doc); err != nil {
+			return repo.QueryResult[V]{}, err
+		}
+		var val V
+		if err := decode(doc.V, &val); err != nil {
+			return repo.QueryResult[V]{}, err
+		}
+		if q.Filter != nil {
+			if !q.Filter(val) {
+				continue
+			}
+			ret.Total++
+			if !pastStart(&doc, ts.docID(cur)) {
+				continue
+			}
+			if skipped < q.Offset {
+				skipped++
+				continue
+			}
+		}
+		if len(ret.Vals) < q.Limit {
+			ret.Vals = append(ret.Vals, val)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return repo.QueryResult[V]{}, err
+	}
+	return ret, nil
+}
+
+// docID the store key of the cursor's current document
+func (ts *typedStore[K, V]) docID(cur *mongo.Cursor) K {
+	k, _ := cur.Current.Lookup(ts.keyField()).AsInt64OK()
+	return K(fromKey(k))
+}
@@ -1,6 +1,8 @@
 package ram
 
 import (
+	"fmt"
+	"log"
 	"sort"
 	"strconv"
 	"sync"
@@ -25,55 +27,121 @@ func (ra *Account) toEntityAccount(id64 entity.AccountIDType) *entity.Account {
 	return ret
 }
 
-// Impl of ram based account repository. Just a map[string]*Account
+// Impl of ram based account repository. Just a map[string]*Account plus the index of the
+// normalized emails
 type accountRepo struct {
 	mtx      *sync.Mutex
 	accounts map[entity.AccountIDType]*Account
+	ids      map[string]entity.AccountIDType
+	log      *repoLog
 }
 
 func NewAccountRepo() *accountRepo {
 	return &accountRepo{
 		mtx:      &sync.Mutex{},
 		accounts: map[entity.AccountIDType]*Account{},
+		ids:      map[string]entity.AccountIDType{},
 	}
 }
 
-func (r *accountRepo) Create(a *entity.Account) error {
-	if a == nil {
-		return usecase.NewEs(usecase.EsArgInvalid, "*entity.Account")
+// put the account and its email in the index, the caller holds the lock
+func (r *accountRepo) put(id entity.AccountIDType, ra *Account) {
+	r.remove(id)
+	r.accounts[id] = ra
+	r.ids[entity.NormalizeEmail(ra.Email)] = id
+}
+
+// remove the account and its email from the index, the caller holds the lock
+func (r *accountRepo) remove(id entity.AccountIDType) {
+	old, ok := r.accounts[id]
+	if !ok {
+		return
+	}
+	if email := entity.NormalizeEmail(old.Email); r.ids[email] == id {
+		delete(r.ids, email)
 	}
+	delete(r.accounts, id)
+}
 
+// NewAccountRepo the account repo kept in the log
+func (l *Log) NewAccountRepo() *accountRepo {
+	r := NewAccountRepo()
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
+	r.log = &repoLog{l: l, name: "accounts"}
+	for key, data := range l.attach(r.log.name, r) {
+		ra := &Account{}
+		if err := decode(data, ra); err != nil {
+			log.Fatal(fmt.Errorf("ram log accounts: %s", err))
+		}
+		r.put(entity.AccountIDType(key), ra)
+	}
+	return r
+}
 
-	r.accounts[a.GetID()] = &Account{
+func (r *accountRepo) dump(put func(key uint64, data []byte) error) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for id, ra := range r.accounts {
+		data, err := encode(ra)
+		if err != nil {
+			return err
+		}
+		if err := put(uint64(id), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set the account, the caller holds the lock
+func (r *accountRepo) set(a *entity.Account) error {
+	ra := &Account{
 		ID:        GetIDString(a.GetID()),
 		Email:     a.GetEmail(),
 		FirstName: a.GetFirstName(),
 		LastName:  a.GetLastName(),
 	}
+	if err := r.log.put(uint64(a.GetID()), ra); err != nil {
+		return err
+	}
+	r.put(a.GetID(), ra)
 	return nil
 }
 
+func (r *accountRepo) Create(a *entity.Account) error {
+	if a == nil {
+		return usecase.NewEs(usecase.EsArgInvalid, "*entity.Account")
+	}
+
+	defer r.log.begin()()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.accounts[a.GetID()]; ok {
+		return usecase.NewEs(usecase.EsAlreadyExists, "entity.Account")
+	}
+	if _, ok := r.ids[entity.NormalizeEmail(a.GetEmail())]; ok {
+		return usecase.NewEs(usecase.EsAlreadyExists, "Email")
+	}
+	return r.set(a)
+}
+
 func (r *accountRepo) Update(a *entity.Account) error {
 	if a == nil {
 		return usecase.NewEs(usecase.EsArgInvalid, "*entity.Account")
 	}
 
+	defer r.log.begin()()
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
-	if _, ok := r.accounts[a.GetID()]; ok {
-		r.accounts[a.GetID()] = &Account{
-			ID:        GetIDString(a.GetID()),
-			Email:     a.GetEmail(),
-			FirstName: a.GetFirstName(),
-			LastName:  a.GetLastName(),
-		}
-		return nil
-	} else {
+	if _, ok := r.accounts[a.GetID()]; !ok {
 		return usecase.NewEs(usecase.EsNotFound, "entity.Account")
 	}
+	if id, ok := r.ids[entity.NormalizeEmail(a.GetEmail())]; ok && id != a.GetID() {
+		return usecase.NewEs(usecase.EsAlreadyExists, "Email")
+	}
+	return r.set(a)
 }
 
 func (r *accountRepo) Delete(a *entity.Account) error {
@@ -81,24 +149,37 @@ func (r *accountRepo) Delete(a *entity.Account) error {
 		return usecase.NewEs(usecase.EsArgInvalid, "*entity.Account")
 	}
 
+	defer r.log.begin()()
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
-	delete(r.accounts, a.GetID())
+	if _, ok := r.accounts[a.GetID()]; !ok {
+		return nil
+	}
+	if err := r.log.delete(uint64(a.GetID())); err != nil {
+		return err
+	}
+	r.remove(a.GetID())
 	return nil
 }
 
 func (r *accountRepo) Retrieve(email string) (*entity.Account, error) {
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
-
-	for k, v := range r.accounts {
-		if v.Email == email {
-			ret := v.toEntityAccount(k)
-			return ret, nil
-		}
+	id, ok := r.ids[entity.NormalizeEmail(email)]
+	if !ok {
+		return nil, usecase.NewEs(usecase.EsNotFound, "Email")
 	}
-	return nil, usecase.NewEs(usecase.EsNotFound, "Email")
+	return r.accounts[id].toEntityAccount(id), nil
+}
 
+func (r *accountRepo) RetrieveIDByEmail(email string) (entity.AccountIDType, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	id, ok := r.ids[entity.NormalizeEmail(email)]
+	if !ok {
+		return 0, usecase.NewEs(usecase.EsNotFound, "Email")
+	}
+	return id, nil
 }
 
 func (r *accountRepo) RetrieveByID(id entity.AccountIDType) (*entity.Account, error) {
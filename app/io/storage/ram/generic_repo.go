@@ -2,15 +2,55 @@ package ram
 
 import (
 	"fmt"
+	"log"
+	"sort"
 	"sync"
 
 	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/usecase"
 )
 
 // GenericRepo Impl of ram based account repository. Just a map[id]interface{}
 type genericRepo struct {
 	mtx   *sync.Mutex
 	elems map[repo.GenericKeyT]interface{}
+	log   *repoLog
+}
+
+// genericVal wraps the logged value so gob records its concrete type
+type genericVal struct {
+	V interface{}
+}
+
+// GenericRepo opens the named repo kept in the log
+func (l *Log) GenericRepo(name string) repo.Generic {
+	gr := NewGenericRepo().(*genericRepo)
+	gr.mtx.Lock()
+	defer gr.mtx.Unlock()
+	gr.log = &repoLog{l: l, name: name}
+	for key, data := range l.attach(name, gr) {
+		var gv genericVal
+		if err := decode(data, &gv); err != nil {
+			log.Fatal(fmt.Errorf("ram log repo %s: %s", name, err))
+		}
+		gr.elems[repo.GenericKeyT(key)] = gv.V
+	}
+	return gr
+}
+
+func (gr *genericRepo) dump(put func(key uint64, data []byte) error) error {
+	gr.mtx.Lock()
+	defer gr.mtx.Unlock()
+	for id, val := range gr.elems {
+		data, err := encode(genericVal{V: val})
+		if err != nil {
+			return err
+		}
+		if err := put(uint64(id), data); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // NewStructRepo a just an alias
@@ -27,25 +67,37 @@ func NewGenericRepo() repo.Generic {
 }
 
 func (gr *genericRepo) createOrUpdate(id repo.GenericKeyT, val interface{}) error {
+	if err := gr.log.put(uint64(id), genericVal{V: val}); err != nil {
+		return err
+	}
 	gr.elems[id] = val
 	return nil
 }
 
 func (gr *genericRepo) Create(id repo.GenericKeyT, val interface{}) error {
+	defer gr.log.begin()()
 	gr.mtx.Lock()
 	defer gr.mtx.Unlock()
 	return gr.createOrUpdate(id, val)
 }
 
 func (gr *genericRepo) Update(id repo.GenericKeyT, val interface{}) error {
+	defer gr.log.begin()()
 	gr.mtx.Lock()
 	defer gr.mtx.Unlock()
 	return gr.createOrUpdate(id, val)
 }
 
 func (gr *genericRepo) Delete(id repo.GenericKeyT) error {
+	defer gr.log.begin()()
 	gr.mtx.Lock()
 	defer gr.mtx.Unlock()
+	if _, ok := gr.elems[id]; !ok {
+		return nil
+	}
+	if err := gr.log.delete(uint64(id)); err != nil {
+		return err
+	}
 	delete(gr.elems, id)
 	return nil
 }
@@ -58,7 +110,7 @@ func (gr *genericRepo) Retrieve(id repo.GenericKeyT) (interface{}, error) {
 		ret := val
 		return ret, nil
 	}
-	return nil, fmt.Errorf("genericRepo id not found")
+	return nil, usecase.NewEs(usecase.EsNotFound, "genericRepo id")
 }
 
 func (gr *genericRepo) RetrieveCount() (int, error) {
@@ -85,16 +137,21 @@ func (gr *genericRepo) RetrieveFiltered(fn func(interface{}) bool) ([]interface{
 	return ret[:cursor], nil
 }
 
+// RetrieveAll the values in key order
 func (gr *genericRepo) RetrieveAll() ([]interface{}, error) {
 	gr.mtx.Lock()
 	defer gr.mtx.Unlock()
-	ret := make([]interface{}, len(gr.elems))
-	var i int
-	for _, e := range gr.elems {
-		if e != nil {
-			ret[i] = e
-			i++
+	keys := make([]repo.GenericKeyT, 0, len(gr.elems))
+	for k := range gr.elems {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	ret := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		if e := gr.elems[k]; e != nil {
+			ret = append(ret, e)
 		}
 	}
-	return ret[0:i], nil
+	return ret, nil
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/domain/repo/repotest"
 )
 
 func TestWithStringStruct(t *testing.T) {
@@ -65,3 +66,15 @@ func TestWithStringStruct(t *testing.T) {
 		t.Error("Count not 0 after delete")
 	}
 }
+
+func TestGenericConformance(t *testing.T) {
+	repotest.TestGeneric(t, NewGenericRepo)
+}
+
+func TestStoreConformance(t *testing.T) {
+	repotest.TestStore(t, NewStore[repo.GenericKeyT, repotest.Value])
+}
+
+func TestIndexedStoreConformance(t *testing.T) {
+	repotest.TestIndexedStore(t, NewIndexedStore[repo.GenericKeyT, repotest.Value])
+}
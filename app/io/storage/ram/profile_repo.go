@@ -1,17 +1,22 @@
 package ram
 
 import (
+	"bytes"
 	"fmt"
 	"image"
-	_ "image/png"
+	"image/png"
 	"log"
+	"sort"
 	"sync"
+
+	"github.com/git-sim/tc/app/usecase"
 )
 
 // Impl of ram based profile repository
 type profileRepo struct {
 	mtx      *sync.Mutex
 	Profiles map[uint64]PublicProfile
+	log      *repoLog
 }
 
 func NewProfileRepo() *profileRepo {
@@ -21,19 +26,109 @@ func NewProfileRepo() *profileRepo {
 	}
 }
 
-// Gets notified that a field from the public profile has been removed
-// clears out the entry in the map, if all fields are removed
-func (pr *profileRepo) DeleteNotify(id uint64) {
+// NewProfileRepo the profile repo kept in the log
+func (l *Log) NewProfileRepo() *profileRepo {
+	pr := NewProfileRepo()
 	pr.mtx.Lock()
 	defer pr.mtx.Unlock()
+	pr.log = &repoLog{l: l, name: "profiles"}
+	for key, data := range l.attach(pr.log.name, pr) {
+		var lp loggedProfile
+		err := decode(data, &lp)
+		if err == nil {
+			pr.Profiles[key], err = lp.toProfile()
+		}
+		if err != nil {
+			log.Fatal(fmt.Errorf("ram log profiles: %s", err))
+		}
+	}
+	return pr
+}
+
+// loggedProfile the logged form of a profile, the pics are png encoded
+type loggedProfile struct {
+	NameAndBios [EnumNumProfileStringFields]string
+	Pics        [EnumNumProfileImageFields][]byte
+}
+
+func toLoggedProfile(pp PublicProfile) (*loggedProfile, error) {
+	lp := &loggedProfile{NameAndBios: pp.NameAndBios}
+	for i, pic := range pp.Pics {
+		if pic == nil || *pic == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, *pic); err != nil {
+			return nil, usecase.NewEs(usecase.EsArgConvFail, fmt.Sprintf("imageRepo encode: %s", err))
+		}
+		lp.Pics[i] = buf.Bytes()
+	}
+	return lp, nil
+}
+
+func (lp *loggedProfile) toProfile() (PublicProfile, error) {
+	pp := PublicProfile{NameAndBios: lp.NameAndBios}
+	for i, data := range lp.Pics {
+		if data == nil {
+			continue
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return pp, err
+		}
+		pp.Pics[i] = &img
+	}
+	return pp, nil
+}
 
+func (pr *profileRepo) dump(put func(key uint64, data []byte) error) error {
+	pr.mtx.Lock()
+	defer pr.mtx.Unlock()
+	for id, pp := range pr.Profiles {
+		lp, err := toLoggedProfile(pp)
+		if err != nil {
+			return err
+		}
+		data, err := encode(lp)
+		if err != nil {
+			return err
+		}
+		if err := put(id, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save the profile, called with the mtx held
+func (pr *profileRepo) save(id uint64, pp PublicProfile) error {
+	if pr.log != nil {
+		lp, err := toLoggedProfile(pp)
+		if err != nil {
+			return err
+		}
+		if err := pr.log.put(id, lp); err != nil {
+			return err
+		}
+	}
+	pr.Profiles[id] = pp
+	return nil
+}
+
+// Gets notified that a field from the public profile has been removed
+// clears out the entry in the map, if all fields are removed. Called with the mtx held.
+func (pr *profileRepo) DeleteNotify(id uint64) error {
 	pubProfile, ok := pr.Profiles[id]
 	if ok {
 		EmptyProfile := PublicProfile{}
 		if pubProfile == EmptyProfile {
+			if err := pr.log.delete(id); err != nil {
+				return err
+			}
 			delete(pr.Profiles, id)
 		}
 	}
+	return nil
 }
 
 const (
@@ -50,6 +145,16 @@ const (
 	EnumNumProfileImageFields
 )
 
+// sortedIDs the ids of the profiles in order, called with the mtx held
+func (pr *profileRepo) sortedIDs() []uint64 {
+	ids := make([]uint64, 0, len(pr.Profiles))
+	for id := range pr.Profiles {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
 type PublicProfile struct {
 	NameAndBios [EnumNumProfileStringFields]string
 	Pics        [EnumNumProfileImageFields]*image.Image
@@ -79,39 +184,35 @@ func (sr *stringRepo) createOrUpdate(id uint64, val string) error {
 		return fmt.Errorf("profileRepo: Invalid string field")
 	}
 
-	pp, ok := sr.Pr.Profiles[id]
-	if ok {
-		// The profile exists fill it in
-		pp.NameAndBios[sr.whichField] = val
-	} else {
-		// Create the profile and assign it
-		pp = PublicProfile{}
-	}
-	sr.Pr.Profiles[id] = pp
-	return nil
+	// A missing profile starts out empty
+	pp := sr.Pr.Profiles[id]
+	pp.NameAndBios[sr.whichField] = val
+	return sr.Pr.save(id, pp)
 }
 
 func (sr *stringRepo) Create(id uint64, val string) error {
+	defer sr.Pr.log.begin()()
 	sr.Pr.mtx.Lock()
 	defer sr.Pr.mtx.Unlock()
 	return sr.createOrUpdate(id, val)
 }
 
 func (sr *stringRepo) Update(id uint64, val string) error {
+	defer sr.Pr.log.begin()()
 	sr.Pr.mtx.Lock()
 	defer sr.Pr.mtx.Unlock()
 	return sr.createOrUpdate(id, val)
 }
 
 func (sr *stringRepo) Delete(id uint64) error {
+	defer sr.Pr.log.begin()()
 	sr.Pr.mtx.Lock()
 	defer sr.Pr.mtx.Unlock()
-	err := sr.Update(id, "")
+	err := sr.createOrUpdate(id, "")
 	if err != nil {
 		return err
 	}
-	sr.Pr.DeleteNotify(id)
-	return nil
+	return sr.Pr.DeleteNotify(id)
 }
 
 func (sr *stringRepo) Retrieve(id uint64) (string, error) {
@@ -122,7 +223,7 @@ func (sr *stringRepo) Retrieve(id uint64) (string, error) {
 		ret := val.NameAndBios[sr.whichField]
 		return ret, nil
 	}
-	return "", fmt.Errorf("stringRepo id not found")
+	return "", usecase.NewEs(usecase.EsNotFound, "stringRepo id")
 }
 
 func (sr *stringRepo) RetrieveCount() (int, error) {
@@ -132,19 +233,18 @@ func (sr *stringRepo) RetrieveCount() (int, error) {
 	return len(sr.Pr.Profiles), nil
 }
 
+// RetrieveAll the set values in id order
 func (sr *stringRepo) RetrieveAll() ([]*string, error) {
 	sr.Pr.mtx.Lock()
 	defer sr.Pr.mtx.Unlock()
-	ret := make([]*string, len(sr.Pr.Profiles))
-	var i int = 0
-	for _, pp := range sr.Pr.Profiles {
-		val := pp.NameAndBios[sr.whichField]
+	ret := []*string{}
+	for _, id := range sr.Pr.sortedIDs() {
+		val := sr.Pr.Profiles[id].NameAndBios[sr.whichField]
 		if val != "" {
-			ret[i] = &val
-			i++
+			ret = append(ret, &val)
 		}
 	}
-	return ret[0:i], nil
+	return ret, nil
 }
 
 // Image Repo making interfaces to the profileRepo, to isolate the usecases from the detail
@@ -173,40 +273,36 @@ func (ir *imageRepo) createOrUpdate(id uint64, val *image.Image) error {
 		return fmt.Errorf("profileRepo: Invalid image field")
 	}
 
-	pp, ok := ir.Pr.Profiles[id]
-	if ok {
-		// The profile exists fill it in
-		pp.Pics[ir.whichField] = val
-	} else {
-		// Create the profile and assign it
-		pp = PublicProfile{}
-	}
-	ir.Pr.Profiles[id] = pp
-	return nil
+	// A missing profile starts out empty
+	pp := ir.Pr.Profiles[id]
+	pp.Pics[ir.whichField] = val
+	return ir.Pr.save(id, pp)
 }
 
 func (ir *imageRepo) Create(id uint64, val *image.Image) error {
+	defer ir.Pr.log.begin()()
 	ir.Pr.mtx.Lock()
 	defer ir.Pr.mtx.Unlock()
 	return ir.createOrUpdate(id, val)
 }
 
 func (ir *imageRepo) Update(id uint64, val *image.Image) error {
+	defer ir.Pr.log.begin()()
 	ir.Pr.mtx.Lock()
 	defer ir.Pr.mtx.Unlock()
 	return ir.createOrUpdate(id, val)
 }
 
 func (ir *imageRepo) Delete(id uint64) error {
+	defer ir.Pr.log.begin()()
 	ir.Pr.mtx.Lock()
 	defer ir.Pr.mtx.Unlock()
 
-	err := ir.Update(id, nil)
+	err := ir.createOrUpdate(id, nil)
 	if err != nil {
 		return err
 	}
-	ir.Pr.DeleteNotify(id)
-	return nil
+	return ir.Pr.DeleteNotify(id)
 }
 
 func (ir *imageRepo) Retrieve(id uint64) (*image.Image, error) {
@@ -218,7 +314,7 @@ func (ir *imageRepo) Retrieve(id uint64) (*image.Image, error) {
 		ret := val.Pics[ir.whichField]
 		return ret, nil
 	}
-	return nil, fmt.Errorf("imageRepo id not found")
+	return nil, usecase.NewEs(usecase.EsNotFound, "imageRepo id")
 }
 
 func (ir *imageRepo) RetrieveCount() (int, error) {
@@ -228,18 +324,17 @@ func (ir *imageRepo) RetrieveCount() (int, error) {
 	return len(ir.Pr.Profiles), nil
 }
 
+// RetrieveAll the set images in id order
 func (ir *imageRepo) RetrieveAll() ([]*image.Image, error) {
 	ir.Pr.mtx.Lock()
 	defer ir.Pr.mtx.Unlock()
 
-	ret := make([]*image.Image, len(ir.Pr.Profiles))
-	var i int = 0
-	for _, pp := range ir.Pr.Profiles {
-		val := pp.Pics[ir.whichField]
+	ret := []*image.Image{}
+	for _, id := range ir.Pr.sortedIDs() {
+		val := ir.Pr.Profiles[id].Pics[ir.whichField]
 		if val != nil {
-			ret[i] = val
-			i++
+			ret = append(ret, val)
 		}
 	}
-	return ret[0:i], nil
+	return ret, nil
 }
@@ -0,0 +1,20 @@
+package ram
+
+import (
+	"testing"
+
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/domain/repo/repotest"
+)
+
+func TestAccountConformance(t *testing.T) {
+	repotest.TestAccount(t, func() repo.AccountRepo { return NewAccountRepo() })
+}
+
+func TestStringConformance(t *testing.T) {
+	repotest.TestString(t, func() repo.StringRepo { return NewStringRepo(NewProfileRepo(), EnumBio) })
+}
+
+func TestImageConformance(t *testing.T) {
+	repotest.TestImage(t, func() repo.ImageRepo { return NewImageRepo(NewProfileRepo(), EnumAvatar) })
+}
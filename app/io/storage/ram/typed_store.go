@@ -0,0 +1,371 @@
+package ram
+
+import (
+	"encoding/gob"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// typedStore Impl of the ram based repo.Store. A map[K]V plus the sorted indexes, key order is
+// kept as the index named "". The stores opened from a Log journal their writes to it.
+type typedStore[K repo.Key, V any] struct {
+	mtx     *sync.Mutex
+	elems   map[K]V
+	indexes map[string]*sortedIndex[K, V]
+	log     *repoLog
+}
+
+// indexEntry the position of a value in an index
+type indexEntry[K repo.Key] struct {
+	ix string
+	id K
+}
+
+func (e indexEntry[K]) less(o indexEntry[K]) bool {
+	if e.ix != o.ix {
+		return e.ix < o.ix
+	}
+	return e.id < o.id
+}
+
+// sortedIndex the entries of one index in order. A write shifts the entries after it,
+// O(n) per insert or remove, fine for the folder sizes the ram store is used with
+type sortedIndex[K repo.Key, V any] struct {
+	key     func(V) string
+	entries []indexEntry[K]
+}
+
+// search the idx of the first entry not before e
+func (si *sortedIndex[K, V]) search(e indexEntry[K]) int {
+	return sort.Search(len(si.entries), func(i int) bool { return !si.entries[i].less(e) })
+}
+
+func (si *sortedIndex[K, V]) insert(id K, val V) {
+	e := indexEntry[K]{ix: si.key(val), id: id}
+	i := si.search(e)
+	si.entries = append(si.entries, indexEntry[K]{})
+	copy(si.entries[i+1:], si.entries[i:])
+	si.entries[i] = e
+}
+
+func (si *sortedIndex[K, V]) remove(id K, val V) {
+	e := indexEntry[K]{ix: si.key(val), id: id}
+	if i := si.search(e); i < len(si.entries) && si.entries[i] == e {
+		si.entries = append(si.entries[:i], si.entries[i+1:]...)
+	}
+}
+
+// NewStore a repo.Store holding values of type V
+func NewStore[K repo.Key, V any]() repo.Store[K, V] {
+	return newTypedStore[K, V]()
+}
+
+// NewIndexedStore a repo.IndexedStore keeping the values sorted by each of the indexes
+func NewIndexedStore[K repo.Key, V any](indexes ...repo.Index[V]) repo.IndexedStore[K, V] {
+	return newTypedStore[K, V](indexes...)
+}
+
+// IndexedStoreFactory makes new NewIndexedStores with the indexes
+func IndexedStoreFactory[K repo.Key, V any](indexes ...repo.Index[V]) func() repo.IndexedStore[K, V] {
+	return func() repo.IndexedStore[K, V] {
+		return NewIndexedStore[K, V](indexes...)
+	}
+}
+
+// OpenStore opens the named store kept in the log
+func OpenStore[K repo.Key, V any](l *Log, name string) repo.Store[K, V] {
+	return openLoggedStore[K, V](l, name)
+}
+
+// OpenIndexedStore opens the named store kept in the log keeping the indexes
+func OpenIndexedStore[K repo.Key, V any](l *Log, name string, indexes ...repo.Index[V]) repo.IndexedStore[K, V] {
+	return openLoggedStore[K, V](l, name, indexes...)
+}
+
+// LogStoreFactory makes unnamed stores kept in the log, to be found again after a restart
+// they have to be kept in another store eg the folder stores in the per account folders. The
+// store type is registered with gob up front so they can be replayed.
+func LogStoreFactory[K repo.Key, V any](l *Log) func() repo.Store[K, V] {
+	gob.Register(&typedStore[K, V]{})
+	return func() repo.Store[K, V] {
+		return openLoggedStore[K, V](l, "store."+randomName())
+	}
+}
+
+// LogIndexedStoreFactory makes unnamed stores kept in the log keeping the indexes, like the
+// LogStoreFactory. There's one set of indexes for each K, V.
+func LogIndexedStoreFactory[K repo.Key, V any](l *Log, indexes ...repo.Index[V]) func() repo.IndexedStore[K, V] {
+	gob.Register(&typedStore[K, V]{})
+	indexesMtx.Lock()
+	storeIndexes[reflect.TypeOf(&typedStore[K, V]{})] = indexes
+	indexesMtx.Unlock()
+	return func() repo.IndexedStore[K, V] {
+		return openLoggedStore[K, V](l, "store."+randomName(), indexes...)
+	}
+}
+
+// The indexes of the stores made by the LogIndexedStoreFactorys, by store type. A store
+// replayed from a reference gets them from here.
+var (
+	indexesMtx   = &sync.Mutex{}
+	storeIndexes = map[reflect.Type]interface{}{}
+)
+
+func openLoggedStore[K repo.Key, V any](l *Log, name string, indexes ...repo.Index[V]) *typedStore[K, V] {
+	ts := newTypedStore[K, V](indexes...)
+	if err := ts.attach(l, name); err != nil {
+		log.Fatal(fmt.Errorf("ram log store %s: %s", name, err))
+	}
+	return ts
+}
+
+// attach the store to the log and load its replayed entries
+func (ts *typedStore[K, V]) attach(l *Log, name string) error {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	ts.log = &repoLog{l: l, name: name}
+	for key, data := range l.attach(name, ts) {
+		var val V
+		if err := decode(data, &val); err != nil {
+			return err
+		}
+		ts.set(K(key), val)
+	}
+	return nil
+}
+
+func (ts *typedStore[K, V]) dump(put func(key uint64, data []byte) error) error {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	for id, val := range ts.elems {
+		data, err := encode(val)
+		if err != nil {
+			return err
+		}
+		if err := put(uint64(id), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GobEncode a store kept in a log is logged as a reference to it
+func (ts *typedStore[K, V]) GobEncode() ([]byte, error) {
+	if ts.log == nil {
+		return nil, fmt.Errorf("ram store isn't kept in a log")
+	}
+	return []byte(ts.log.l.id + "\x00" + ts.log.name), nil
+}
+
+// GobDecode reattaches to the open log
+func (ts *typedStore[K, V]) GobDecode(data []byte) error {
+	parts := strings.SplitN(string(data), "\x00", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("bad store reference")
+	}
+	l, err := lookupLog(parts[0])
+	if err != nil {
+		return err
+	}
+	indexesMtx.Lock()
+	indexes, _ := storeIndexes[reflect.TypeOf(ts)].([]repo.Index[V])
+	indexesMtx.Unlock()
+	*ts = *newTypedStore[K, V](indexes...)
+	return ts.attach(l, parts[1])
+}
+
+func newTypedStore[K repo.Key, V any](indexes ...repo.Index[V]) *typedStore[K, V] {
+	ts := &typedStore[K, V]{
+		mtx:     &sync.Mutex{},
+		elems:   make(map[K]V),
+		indexes: map[string]*sortedIndex[K, V]{"": {key: func(V) string { return "" }}},
+	}
+	for _, ix := range indexes {
+		ts.indexes[ix.Name] = &sortedIndex[K, V]{key: ix.Key}
+	}
+	return ts
+}
+
+// createOrUpdate the caller holds the lock
+func (ts *typedStore[K, V]) createOrUpdate(id K, val V) error {
+	if err := ts.log.put(uint64(id), val); err != nil {
+		return err
+	}
+	ts.set(id, val)
+	return nil
+}
+
+// set the value and its index entries, the caller holds the lock
+func (ts *typedStore[K, V]) set(id K, val V) {
+	old, ok := ts.elems[id]
+	for _, si := range ts.indexes {
+		if ok {
+			si.remove(id, old)
+		}
+		si.insert(id, val)
+	}
+	ts.elems[id] = val
+}
+
+func (ts *typedStore[K, V]) Create(id K, val V) error {
+	defer ts.log.begin()()
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	return ts.createOrUpdate(id, val)
+}
+
+func (ts *typedStore[K, V]) Update(id K, val V) error {
+	defer ts.log.begin()()
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	return ts.createOrUpdate(id, val)
+}
+
+func (ts *typedStore[K, V]) Delete(id K) error {
+	defer ts.log.begin()()
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	old, ok := ts.elems[id]
+	if !ok {
+		return nil
+	}
+	if err := ts.log.delete(uint64(id)); err != nil {
+		return err
+	}
+	for _, si := range ts.indexes {
+		si.remove(id, old)
+	}
+	delete(ts.elems, id)
+	return nil
+}
+
+// Drop empties the store and takes it out of the log, so the snapshots leave it out
+func (ts *typedStore[K, V]) Drop() error {
+	defer ts.log.begin()()
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	if err := ts.log.drop(); err != nil {
+		return err
+	}
+	ts.elems = make(map[K]V)
+	for _, si := range ts.indexes {
+		si.entries = nil
+	}
+	return nil
+}
+
+func (ts *typedStore[K, V]) Retrieve(id K) (V, error) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	val, ok := ts.elems[id]
+	if !ok {
+		return val, usecase.NewEs(usecase.EsNotFound, "store id")
+	}
+	return val, nil
+}
+
+func (ts *typedStore[K, V]) RetrieveCount() (int, error) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	return len(ts.elems), nil
+}
+
+// RetrieveFiltered the values fn keeps in key order, fn is called without the lock held
+func (ts *typedStore[K, V]) RetrieveFiltered(fn func(V) bool) ([]V, error) {
+	all, err := ts.RetrieveAll()
+	if err != nil {
+		return nil, err
+	}
+	ret := all[:0]
+	for _, v := range all {
+		if fn(v) {
+			ret = append(ret, v)
+		}
+	}
+	return ret, nil
+}
+
+// RetrieveAll the values in key order
+func (ts *typedStore[K, V]) RetrieveAll() ([]V, error) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	return ts.values(ts.indexes[""].entries), nil
+}
+
+// values looks up the values of the entries, the caller holds the lock
+func (ts *typedStore[K, V]) values(entries []indexEntry[K]) []V {
+	ret := make([]V, len(entries))
+	for i, e := range entries {
+		ret[i] = ts.elems[e.id]
+	}
+	return ret
+}
+
+// Query walks the index from the query's start position. Without a filter only the page is
+// read, with one the values are copied out and the filter is called without the lock held.
+func (ts *typedStore[K, V]) Query(q repo.Query[K, V]) (repo.QueryResult[V], error) {
+	ts.mtx.Lock()
+	si, ok := ts.indexes[q.Index]
+	if !ok {
+		ts.mtx.Unlock()
+		return repo.QueryResult[V]{}, usecase.NewEs(usecase.EsArgInvalid, fmt.Sprintf("index %q", q.Index))
+	}
+	n := len(si.entries)
+
+	// order the entries in query order, start is the first one after q.After
+	order := func(i int) int { return i }
+	if q.Desc {
+		order = func(i int) int { return n - 1 - i }
+	}
+	start := 0
+	if q.After != nil {
+		after := indexEntry[K]{ix: q.After.IndexKey, id: q.After.ID}
+		if q.Desc {
+			start = n - si.search(after)
+		} else {
+			start = si.search(after)
+			if start < n && si.entries[start] == after {
+				start++
+			}
+		}
+	}
+
+	ret := repo.QueryResult[V]{Vals: []V{}}
+	if q.Filter == nil {
+		for i := start + q.Offset; i < n && len(ret.Vals) < q.Limit; i++ {
+			ret.Vals = append(ret.Vals, ts.elems[si.entries[order(i)].id])
+		}
+		ret.Total = n
+		ts.mtx.Unlock()
+		return ret, nil
+	}
+	vals := ts.values(si.entries)
+	ts.mtx.Unlock()
+
+	skipped := 0
+	for i := 0; i < n; i++ {
+		val := vals[order(i)]
+		if !q.Filter(val) {
+			continue
+		}
+		ret.Total++
+		if i < start {
+			continue
+		}
+		if skipped < q.Offset {
+			skipped++
+			continue
+		}
+		if len(ret.Vals) < q.Limit {
+			ret.Vals = append(ret.Vals, val)
+		}
+	}
+	return ret, nil
+}
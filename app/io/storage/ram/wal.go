@@ -0,0 +1,546 @@
+package ram
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// Log makes the ram repos opened from it survive restarts. Every write is appended to the
+// wal file in the log's directory before it's applied, once enough have piled up the contents
+// of all the repos are written to the snapshot file and the wal starts over. Open replays the
+// snapshot then the wal. The repos keep the plain ram repos' semantics, reads never touch
+// the files. Values are gob encoded, so the types put in the generic repos have to be
+// registered with gob.Register by whoever stores them.
+type Log struct {
+	id   string
+	dir  string
+	opts LogOptions
+
+	mtx     *sync.RWMutex // writes share it while they append, a snapshot takes it alone
+	fileMtx *sync.Mutex   // the wal and the counts below
+	wal     *os.File
+	records int  // in the wal
+	dirty   bool // written since the last fsync
+
+	reposMtx *sync.Mutex
+	repos    map[string]loggedRepo
+	loaded   map[string]map[uint64][]byte // the replayed entries of the repos not opened yet
+
+	snapshotDue chan struct{}
+	done        chan struct{}
+	wg          *sync.WaitGroup
+}
+
+// SyncPolicy when the wal is fsynced, a write is in the OS once it returns whatever the policy
+type SyncPolicy int
+
+const (
+	SyncAlways   SyncPolicy = iota // every write, before it returns
+	SyncInterval                   // every LogOptions.SyncInterval, a crash of the machine loses up to that much
+	SyncNever                      // left to the OS
+)
+
+// LogOptions the zero value syncs every write and snapshots every DefaultSnapshotEvery writes
+type LogOptions struct {
+	Sync          SyncPolicy
+	SyncInterval  time.Duration
+	SnapshotEvery int // writes in the wal before it's compacted into a snapshot
+}
+
+const DefaultSnapshotEvery = 10000
+
+const (
+	walFile      = "wal"
+	snapshotFile = "snapshot"
+	idFile       = "id"
+)
+
+// loggedRepo a repo kept in a Log
+type loggedRepo interface {
+	// dump calls put for each entry, it's called with no writes in flight
+	dump(put func(key uint64, data []byte) error) error
+}
+
+// logRecord one entry of the wal or the snapshot, Val is the gob encoded value. Drop
+// deletes the whole repo.
+type logRecord struct {
+	Repo string
+	Key  uint64
+	Del  bool
+	Drop bool
+	Val  []byte
+}
+
+// Open logs are kept by the id written in their directory, so a store nested in a logged
+// value (eg the per account folder stores) can find its log again when it's replayed.
+var (
+	logsMtx = &sync.Mutex{}
+	logs    = map[string]*Log{}
+)
+
+// OpenLog opens or creates the log in dir and replays it
+func OpenLog(dir string, opts LogOptions) (*Log, error) {
+	if opts.SnapshotEvery <= 0 {
+		opts.SnapshotEvery = DefaultSnapshotEvery
+	}
+	if opts.Sync == SyncInterval && opts.SyncInterval <= 0 {
+		return nil, usecase.NewEs(usecase.EsArgInvalid, "SyncInterval")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	id, err := readID(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{
+		id:          id,
+		dir:         dir,
+		opts:        opts,
+		mtx:         &sync.RWMutex{},
+		fileMtx:     &sync.Mutex{},
+		reposMtx:    &sync.Mutex{},
+		repos:       map[string]loggedRepo{},
+		loaded:      map[string]map[uint64][]byte{},
+		snapshotDue: make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		wg:          &sync.WaitGroup{},
+	}
+	if err := l.replay(); err != nil {
+		return nil, err
+	}
+
+	logsMtx.Lock()
+	defer logsMtx.Unlock()
+	if _, ok := logs[id]; ok {
+		l.wal.Close()
+		return nil, fmt.Errorf("ram log %s already open", dir)
+	}
+	logs[id] = l
+	l.wg.Add(1)
+	go l.background()
+	return l, nil
+}
+
+// Close snapshots and closes the files, repos from the log can't be written after
+func (l *Log) Close() error {
+	close(l.done)
+	l.wg.Wait()
+	logsMtx.Lock()
+	delete(logs, l.id)
+	logsMtx.Unlock()
+
+	err := l.snapshot()
+	l.fileMtx.Lock()
+	defer l.fileMtx.Unlock()
+	if cerr := l.wal.Close(); err == nil {
+		err = cerr
+	}
+	l.wal = nil
+	return err
+}
+
+func lookupLog(id string) (*Log, error) {
+	logsMtx.Lock()
+	defer logsMtx.Unlock()
+	l, ok := logs[id]
+	if !ok {
+		return nil, fmt.Errorf("ram log %s not open", id)
+	}
+	return l, nil
+}
+
+// readID the log's id, assigned on the first open
+func readID(dir string) (string, error) {
+	path := filepath.Join(dir, idFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return string(bytes.TrimSpace(data)), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	id := randomName()
+	return id, writeFileSync(path, []byte(id))
+}
+
+// background syncs the wal on the SyncInterval and takes the snapshots
+func (l *Log) background() {
+	defer l.wg.Done()
+	var tick <-chan time.Time
+	if l.opts.Sync == SyncInterval {
+		ticker := time.NewTicker(l.opts.SyncInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-tick:
+			if err := l.sync(); err != nil {
+				log.Println("ram log sync:", err)
+			}
+		case <-l.snapshotDue:
+			if err := l.snapshot(); err != nil {
+				log.Println("ram log snapshot:", err)
+			}
+		}
+	}
+}
+
+// attach adds the repo to the log under name and hands back its replayed entries, the repo
+// holds its own lock until they're loaded so a snapshot waits for them
+func (l *Log) attach(name string, r loggedRepo) map[uint64][]byte {
+	l.reposMtx.Lock()
+	defer l.reposMtx.Unlock()
+	if _, ok := l.repos[name]; ok {
+		log.Fatal(fmt.Errorf("ram log repo %s opened twice", name))
+	}
+	l.repos[name] = r
+	entries := l.loaded[name]
+	delete(l.loaded, name)
+	return entries
+}
+
+// detach takes the dropped repo out of the log
+func (l *Log) detach(name string) {
+	l.reposMtx.Lock()
+	defer l.reposMtx.Unlock()
+	delete(l.repos, name)
+	delete(l.loaded, name)
+}
+
+// append writes the record to the wal, the caller holds l.mtx shared
+func (l *Log) append(rec logRecord) error {
+	frame, err := encodeFrame(rec)
+	if err != nil {
+		return err
+	}
+	l.fileMtx.Lock()
+	defer l.fileMtx.Unlock()
+	if l.wal == nil {
+		return usecase.NewEs(usecase.EsInternalError, "ram log closed")
+	}
+	if _, err := l.wal.Write(frame); err != nil {
+		return err
+	}
+	l.dirty = true
+	if l.opts.Sync == SyncAlways {
+		if err := l.wal.Sync(); err != nil {
+			return err
+		}
+		l.dirty = false
+	}
+	l.records++
+	if l.records >= l.opts.SnapshotEvery {
+		select {
+		case l.snapshotDue <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (l *Log) sync() error {
+	l.fileMtx.Lock()
+	defer l.fileMtx.Unlock()
+	if l.wal == nil || !l.dirty {
+		return nil
+	}
+	l.dirty = false
+	return l.wal.Sync()
+}
+
+// snapshot writes every repo's entries to a new snapshot file, swaps it in and empties the wal
+func (l *Log) snapshot() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.reposMtx.Lock()
+	repos := make(map[string]loggedRepo, len(l.repos))
+	for name, r := range l.repos {
+		repos[name] = r
+	}
+	loaded := make(map[string]map[uint64][]byte, len(l.loaded))
+	for name, entries := range l.loaded {
+		loaded[name] = entries
+	}
+	l.reposMtx.Unlock()
+
+	tmp := filepath.Join(l.dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	put := func(name string) func(key uint64, data []byte) error {
+		return func(key uint64, data []byte) error {
+			frame, err := encodeFrame(logRecord{Repo: name, Key: key, Val: data})
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(frame)
+			return err
+		}
+	}
+	for name, r := range repos {
+		if err = r.dump(put(name)); err != nil {
+			break
+		}
+	}
+	// Entries of repos that haven't been opened since the restart are kept as they are
+	for name, entries := range loaded {
+		for key, data := range entries {
+			if err = put(name)(key, data); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(l.dir, snapshotFile))
+	}
+	if err == nil {
+		err = syncDir(l.dir)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// The snapshot has everything in the wal, replaying the wal over it again would be harmless
+	// so a crash before the truncate loses nothing
+	l.fileMtx.Lock()
+	defer l.fileMtx.Unlock()
+	if l.wal == nil {
+		return nil
+	}
+	if err := l.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	l.records = 0
+	l.dirty = false
+	return l.wal.Sync()
+}
+
+// replay loads the snapshot and the wal into l.loaded and opens the wal for appending. A
+// torn record at the end of the wal (a crash mid write) is cut off.
+func (l *Log) replay() error {
+	apply := func(rec logRecord) {
+		if rec.Drop {
+			delete(l.loaded, rec.Repo)
+			return
+		}
+		entries, ok := l.loaded[rec.Repo]
+		if !ok {
+			entries = map[uint64][]byte{}
+			l.loaded[rec.Repo] = entries
+		}
+		if rec.Del {
+			delete(entries, rec.Key)
+		} else {
+			entries[rec.Key] = rec.Val
+		}
+	}
+
+	f, err := os.Open(filepath.Join(l.dir, snapshotFile))
+	if err == nil {
+		_, err = readFrames(bufio.NewReader(f), apply)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("ram log snapshot: %s", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	l.wal, err = os.OpenFile(filepath.Join(l.dir, walFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	good, err := readFrames(bufio.NewReader(l.wal), func(rec logRecord) {
+		apply(rec)
+		l.records++
+	})
+	if err != nil {
+		log.Printf("ram log %s: dropping the wal after byte %d: %s", l.dir, good, err)
+		if err := l.wal.Truncate(good); err != nil {
+			l.wal.Close()
+			return err
+		}
+	}
+	if _, err := l.wal.Seek(good, io.SeekStart); err != nil {
+		l.wal.Close()
+		return err
+	}
+	return nil
+}
+
+// A frame is the length and crc32 of the gob encoded record followed by the record
+
+const (
+	frameHeader = 8
+	maxFrame    = 1 << 30
+)
+
+func encodeFrame(rec logRecord) ([]byte, error) {
+	data, err := encode(rec)
+	if err != nil {
+		return nil, usecase.NewEs(usecase.EsArgConvFail, fmt.Sprintf("ram log encode: %s", err))
+	}
+	frame := make([]byte, frameHeader, frameHeader+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	binary.BigEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(data))
+	return append(frame, data...), nil
+}
+
+// readFrames calls fn for each record up to the end or the first bad frame, good is the
+// offset after the last good one
+func readFrames(r io.Reader, fn func(logRecord)) (good int64, err error) {
+	header := make([]byte, frameHeader)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return good, nil
+		} else if err != nil {
+			return good, err
+		}
+		n := binary.BigEndian.Uint32(header)
+		if n > maxFrame {
+			return good, fmt.Errorf("bad frame length %d", n)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return good, err
+		}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+			return good, fmt.Errorf("bad checksum")
+		}
+		var rec logRecord
+		if err := decode(data, &rec); err != nil {
+			return good, err
+		}
+		fn(rec)
+		good += int64(frameHeader + len(data))
+	}
+}
+
+// repoLog journals the writes of one repo, it's nil for the repos that aren't in a Log
+type repoLog struct {
+	l    *Log
+	name string
+}
+
+// begin is called before the repo takes its own lock for a write, the returned func after
+// it's released: defer r.log.begin()()
+func (rl *repoLog) begin() func() {
+	if rl == nil {
+		return func() {}
+	}
+	rl.l.mtx.RLock()
+	return rl.l.mtx.RUnlock
+}
+
+func (rl *repoLog) put(key uint64, val interface{}) error {
+	if rl == nil {
+		return nil
+	}
+	data, err := encode(val)
+	if err != nil {
+		return usecase.NewEs(usecase.EsArgConvFail, fmt.Sprintf("ram log encode %T: %s", val, err))
+	}
+	return rl.l.append(logRecord{Repo: rl.name, Key: key, Val: data})
+}
+
+func (rl *repoLog) delete(key uint64) error {
+	if rl == nil {
+		return nil
+	}
+	return rl.l.append(logRecord{Repo: rl.name, Key: key, Del: true})
+}
+
+func (rl *repoLog) drop() error {
+	if rl == nil {
+		return nil
+	}
+	if err := rl.l.append(logRecord{Repo: rl.name, Drop: true}); err != nil {
+		return err
+	}
+	rl.l.detach(rl.name)
+	return nil
+}
+
+// Helpers
+
+// randomName for log ids and the names of the unnamed stores
+func randomName() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(fmt.Errorf("ram random name: %s", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+func encode(val interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(val); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte, pVal interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(pVal)
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,339 @@
+package ram
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/domain/repo/repotest"
+	"github.com/git-sim/tc/app/domain/service"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+func openTestLog(t *testing.T, dir string, opts LogOptions) *Log {
+	l, err := OpenLog(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// crash drops the log without the snapshot Close takes, like the process dying
+func crash(l *Log) {
+	close(l.done)
+	l.wg.Wait()
+	logsMtx.Lock()
+	delete(logs, l.id)
+	logsMtx.Unlock()
+	l.fileMtx.Lock()
+	l.wal.Close()
+	l.wal = nil
+	l.fileMtx.Unlock()
+}
+
+// testSystem the account, folder and message usecases on a log
+type testSystem struct {
+	l   *Log
+	acc usecase.AccountUsecase
+	fol usecase.FoldersUsecase
+	msg usecase.MsgUsecase
+	bio repo.StringRepo
+}
+
+func newTestSystem(t *testing.T, dir string, opts LogOptions) *testSystem {
+	l := openTestLog(t, dir, opts)
+	dbAccounts := l.NewAccountRepo()
+	dbPending := OpenStore[usecase.PendingKeyType, entity.PendingMsgEntry](l, "pendingMsgs")
+	accServ := service.NewAccountService(dbAccounts)
+
+	ts := &testSystem{l: l, bio: NewStringRepo(l.NewProfileRepo(), EnumBio)}
+	ts.acc = usecase.NewAccountUsecase(dbAccounts, nil, accServ, usecase.NewRandomAccountIDs())
+	ts.fol = usecase.NewFoldersUsecase(OpenStore[entity.AccountIDType, usecase.AccountFolders](l, "folders"),
+		LogIndexedStoreFactory[entity.MsgIDType, entity.MsgEntry](l, usecase.FolderIndexes...),
+		LogStoreFactory[entity.ThreadIDType, bool](l), accServ)
+	rules := usecase.NewRulesUsecase(OpenStore[entity.AccountIDType, usecase.AccountRules](l, "rules"), ts.fol)
+	dbMsgs := OpenStore[entity.MsgIDType, entity.Msg](l, "msgs")
+	ids, err := usecase.NewPersistedIDGenerator(OpenStore[repo.GenericKeyT, uint64](l, "idMarks"), 10,
+		usecase.StoredIDs(dbMsgs, ts.fol))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.msg = usecase.NewMsgUsecase(dbMsgs, dbPending, OpenStore[entity.ThreadIDType, []entity.MsgIDType](l, "threads"),
+		ts.fol, rules, accServ, usecase.NewScheduler(usecase.NewRealClock()), ids)
+	if err := usecase.InitSubscribers(accServ, ts.fol, ts.acc, rules, dbPending); err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func (ts *testSystem) count(t *testing.T, email string, folderIdx int) int {
+	acc, err := ts.acc.GetAccount(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := usecase.ToAccountID(acc.ID)
+	out, err := ts.fol.QueryMsgs(id, usecase.QueryParams{FolderIdx: folderIdx, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.NumTotal
+}
+
+func TestLogRestart(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		stop func(l *Log)
+	}{
+		{"close", func(l *Log) { l.Close() }}, // from the snapshot
+		{"crash", crash},                      // from the wal
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ts := newTestSystem(t, dir, LogOptions{})
+			for _, email := range []string{"alice@mail.com", "bob@mail.com"} {
+				if _, err := ts.acc.RegisterAccount(email); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+				SenderEmail: "alice@mail.com",
+				Recipients:  []string{"bob@mail.com", "carol@mail.com"},
+				Subject:     "hello",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts.bio.Create(1, "bio")
+			tc.stop(ts.l)
+
+			// Everything is replayed, carol's copy is still pending
+			ts = newTestSystem(t, dir, LogOptions{})
+			defer ts.l.Close()
+			if n := ts.count(t, "bob@mail.com", usecase.EnumInbox); n != 1 {
+				t.Errorf("bob's inbox expected 1 got %d", n)
+			}
+			if n := ts.count(t, "alice@mail.com", usecase.EnumSent); n != 1 {
+				t.Errorf("alice's sent expected 1 got %d", n)
+			}
+			msg, err := ts.msg.RetrieveMsg(mid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.M.Subject != "hello" {
+				t.Errorf("subject expected hello got %s", msg.M.Subject)
+			}
+			if bio, _ := ts.bio.Retrieve(1); bio != "bio" {
+				t.Errorf("bio expected bio got %q", bio)
+			}
+			if _, err := ts.acc.RegisterAccount("carol@mail.com"); err != nil {
+				t.Fatal(err)
+			}
+			if n := ts.count(t, "carol@mail.com", usecase.EnumInbox); n != 1 {
+				t.Errorf("carol's inbox expected the pending msg got %d", n)
+			}
+
+			// The ids carry on from before the restart
+			mid2, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+				SenderEmail: "bob@mail.com",
+				Recipients:  []string{"alice@mail.com"},
+				Subject:     "after",
+			})
+			if err != nil || mid2 <= mid {
+				t.Errorf("msg after the restart expected an id past %d got %d %v", mid, mid2, err)
+			}
+			if msg, _ := ts.msg.RetrieveMsg(mid); msg == nil || msg.M.Subject != "hello" {
+				t.Errorf("the msg from before the restart was overwritten by %v", msg)
+			}
+		})
+	}
+}
+
+func TestLogSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, LogOptions{Sync: SyncNever, SnapshotEvery: 10})
+	st := OpenStore[repo.GenericKeyT, repotest.Value](l, "values")
+	for i := 0; i < 100; i++ {
+		st.Update(repo.GenericKeyT(i%5), repotest.Value{ID: uint64(i % 5), Name: fmt.Sprint(i)})
+	}
+	st.Delete(0)
+	// Wait out the snapshot the last writes asked for
+	if err := l.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, walFile)); err != nil || info.Size() != 0 {
+		t.Errorf("expected an empty wal after the snapshot got %v %v", info, err)
+	}
+	st.Update(1, repotest.Value{ID: 1, Name: "after"})
+	crash(l)
+
+	l = openTestLog(t, dir, LogOptions{})
+	defer l.Close()
+	st = OpenStore[repo.GenericKeyT, repotest.Value](l, "values")
+	all, _ := st.RetrieveAll()
+	names := []string{}
+	for _, v := range all {
+		names = append(names, v.Name)
+	}
+	if fmt.Sprint(names) != "[after 97 98 99]" {
+		t.Errorf("expected [after 97 98 99] got %v", names)
+	}
+}
+
+func TestLogDrop(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		stop func(l *Log)
+	}{
+		{"close", func(l *Log) { l.Close() }},
+		{"crash", crash},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openTestLog(t, dir, LogOptions{})
+			dropped := OpenStore[repo.GenericKeyT, repotest.Value](l, "dropped")
+			kept := OpenStore[repo.GenericKeyT, repotest.Value](l, "kept")
+			for i := uint64(1); i <= 3; i++ {
+				dropped.Create(repo.GenericKeyT(i), repotest.Value{ID: i})
+				kept.Create(repo.GenericKeyT(i), repotest.Value{ID: i})
+			}
+			if err := dropped.Drop(); err != nil {
+				t.Fatal(err)
+			}
+			tc.stop(l)
+
+			// The dropped store's entries are gone from the snapshot and the wal
+			l = openTestLog(t, dir, LogOptions{})
+			defer l.Close()
+			if _, ok := l.loaded["dropped"]; ok {
+				t.Error("the dropped store was replayed")
+			}
+			if n, _ := OpenStore[repo.GenericKeyT, repotest.Value](l, "kept").RetrieveCount(); n != 3 {
+				t.Errorf("kept store count expected 3 got %d", n)
+			}
+		})
+	}
+}
+
+func TestLogDeleteFolder(t *testing.T) {
+	ts := newTestSystem(t, t.TempDir(), LogOptions{})
+	defer ts.l.Close()
+	acc, err := ts.acc.RegisterAccount("alice@mail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := usecase.ToAccountID(acc.ID)
+	repos := func() int {
+		ts.l.reposMtx.Lock()
+		defer ts.l.reposMtx.Unlock()
+		return len(ts.l.repos)
+	}
+
+	// The deleted folder's store doesn't stay in the log
+	before := repos()
+	fi, err := ts.fol.CreateFolder(id, "Receipts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.fol.DeleteFolder(id, fi.Idx); err != nil {
+		t.Fatal(err)
+	}
+	if n := repos(); n != before {
+		t.Errorf("repos in the log expected %d got %d", before, n)
+	}
+}
+
+func TestLogTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, LogOptions{})
+	accounts := l.NewAccountRepo()
+	accounts.Create(entity.NewAccount(1, "alice@mail.com"))
+	crash(l)
+
+	// Half a record at the end of the wal is dropped, what's before it is kept
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+	f.Close()
+
+	l = openTestLog(t, dir, LogOptions{})
+	accounts = l.NewAccountRepo()
+	if _, err := accounts.Retrieve("alice@mail.com"); err != nil {
+		t.Errorf("alice expected to survive the torn write got %v", err)
+	}
+	accounts.Create(entity.NewAccount(2, "bob@mail.com"))
+	crash(l)
+
+	l = openTestLog(t, dir, LogOptions{})
+	defer l.Close()
+	if n, _ := l.NewAccountRepo().RetrieveCount(); n != 2 {
+		t.Errorf("expected the 2 accounts after the torn write was cut off got %d", n)
+	}
+}
+
+func TestLogOpenTwice(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, LogOptions{})
+	defer l.Close()
+	if _, err := OpenLog(dir, LogOptions{}); err == nil {
+		t.Error("opening a log twice should fail")
+	}
+	if _, err := OpenLog(t.TempDir(), LogOptions{Sync: SyncInterval}); !usecase.CheckEs(err, usecase.EsArgInvalid) {
+		t.Errorf("SyncInterval without an interval expected EsArgInvalid got %v", err)
+	}
+}
+
+func TestLogConformance(t *testing.T) {
+	l := openTestLog(t, t.TempDir(), LogOptions{Sync: SyncNever})
+	defer l.Close()
+	n := 0
+	name := func() string {
+		n++
+		return fmt.Sprintf("repo%d", n)
+	}
+	t.Run("generic", func(t *testing.T) {
+		repotest.TestGeneric(t, func() repo.Generic { return l.GenericRepo(name()) })
+	})
+	t.Run("store", func(t *testing.T) {
+		repotest.TestStore(t, LogStoreFactory[repo.GenericKeyT, repotest.Value](l))
+	})
+	t.Run("indexed", func(t *testing.T) {
+		repotest.TestIndexedStore(t, func(indexes ...repo.Index[repotest.Value]) repo.IndexedStore[repo.GenericKeyT, repotest.Value] {
+			return OpenIndexedStore[repo.GenericKeyT, repotest.Value](l, name(), indexes...)
+		})
+	})
+	t.Run("account", func(t *testing.T) {
+		repotest.TestAccount(t, func() repo.AccountRepo {
+			l := openTestLog(t, t.TempDir(), LogOptions{Sync: SyncNever})
+			t.Cleanup(func() { l.Close() })
+			return l.NewAccountRepo()
+		})
+	})
+	t.Run("image", func(t *testing.T) {
+		repotest.TestImage(t, func() repo.ImageRepo {
+			l := openTestLog(t, t.TempDir(), LogOptions{Sync: SyncNever})
+			t.Cleanup(func() { l.Close() })
+			return NewImageRepo(l.NewProfileRepo(), EnumAvatar)
+		})
+	})
+}
+
+func TestLogImage(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, dir, LogOptions{})
+	var img image.Image = image.NewRGBA(image.Rect(0, 0, 2, 3))
+	NewImageRepo(l.NewProfileRepo(), EnumAvatar).Create(1, &img)
+	crash(l)
+
+	l = openTestLog(t, dir, LogOptions{})
+	defer l.Close()
+	got, err := NewImageRepo(l.NewProfileRepo(), EnumAvatar).Retrieve(1)
+	if err != nil || got == nil || (*got).Bounds() != img.Bounds() {
+		t.Errorf("expected the 2x3 image got %v %v", got, err)
+	}
+}
@@ -0,0 +1,68 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+
+	"github.com/git-sim/tc/app/domain/repo"
+)
+
+// maxIDAttempts how many taken ids RegisterAccount skips before giving up
+const maxIDAttempts = 16
+
+// randomAccountIDs random 64 bit ids, with 2^64 of them a retry is very unlikely
+type randomAccountIDs struct{}
+
+// NewRandomAccountIDs the default allocator, the ids give away nothing about the account
+func NewRandomAccountIDs() AccountIDAllocator {
+	return randomAccountIDs{}
+}
+
+func (randomAccountIDs) Next() (AccountIDType, error) {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, NewEs(EsInternalError, "random account id: "+err.Error())
+		}
+		// 0 is never an account
+		if id := AccountIDType(binary.BigEndian.Uint64(b[:])); id != 0 {
+			return id, nil
+		}
+	}
+}
+
+// sequentialAccountIDs 1, 2, 3... carrying on after the highest id already stored
+type sequentialAccountIDs struct {
+	mtx      *sync.Mutex
+	accounts repo.AccountRepo
+	next     AccountIDType
+}
+
+// NewSequentialAccountIDs short ids in the order the accounts are registered
+func NewSequentialAccountIDs(accounts repo.AccountRepo) AccountIDAllocator {
+	return &sequentialAccountIDs{mtx: &sync.Mutex{}, accounts: accounts}
+}
+
+func (a *sequentialAccountIDs) Next() (AccountIDType, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.next == 0 {
+		// First use (or a wrap around), start after what's stored
+		all, err := a.accounts.RetrieveAll()
+		if err != nil {
+			return 0, err
+		}
+		for _, acc := range all {
+			if id := AccountIDType(acc.GetID()); id >= a.next {
+				a.next = id + 1
+			}
+		}
+		if a.next == 0 {
+			a.next = 1
+		}
+	}
+	id := a.next
+	a.next++
+	return id, nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"hash/fnv"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/git-sim/tc/app/domain/entity"
 	"github.com/git-sim/tc/app/domain/repo"
@@ -12,22 +14,30 @@ import (
 
 // accountUsecase impl
 type accountUsecase struct {
+	mtx     *sync.Mutex // the exists check and the write that claims the email go together
 	repo    repo.AccountRepo
 	session SessionUsecase
 	service *service.AccountService
+	ids     AccountIDAllocator
 }
 
-// NewAccountUsecase - repo is the interface for the Account Repository (db Or in memory)
-func NewAccountUsecase(repo repo.AccountRepo, session SessionUsecase, service *service.AccountService) AccountUsecase {
+// NewAccountUsecase - repo is the interface for the Account Repository (db Or in memory), ids
+// picks the ids of new accounts
+func NewAccountUsecase(repo repo.AccountRepo, session SessionUsecase, service *service.AccountService,
+	ids AccountIDAllocator) AccountUsecase {
 	return &accountUsecase{
+		mtx:     &sync.Mutex{},
 		repo:    repo,
 		session: session,
 		service: service,
+		ids:     ids,
 	}
 }
 
 // RegisterAccount this is one of the major events in the system creating the structures needed for the account.
 func (u *accountUsecase) RegisterAccount(email string) (*Account, error) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
 	if u.service.AlreadyExists(email) {
 		return nil, NewEs(EsAlreadyExists, "User Account")
 	}
@@ -35,9 +45,8 @@ func (u *accountUsecase) RegisterAccount(email string) (*Account, error) {
 	// Create the account and associated structures in the system
 	//   A Delete account should undo the below in reverse order to make sure
 	//   we have a good cleanup
-	uid := GetUID(email)
-	acc := entity.NewAccount(entity.AccountIDType(uid), email)
-	if err := u.repo.Create(acc); err != nil {
+	acc, err := u.createAccount(email)
+	if err != nil {
 		return nil, err
 	}
 	u.service.NotifyRegisterAccount(*acc)
@@ -45,6 +54,33 @@ func (u *accountUsecase) RegisterAccount(email string) (*Account, error) {
 	return out[0], nil
 }
 
+// createAccount stores the account under the first free id the allocator comes up with
+func (u *accountUsecase) createAccount(email string) (*entity.Account, error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		id, err := u.ids.Next()
+		if err != nil {
+			return nil, err
+		}
+		if u.service.AlreadyExistsByID(entity.AccountIDType(id)) {
+			continue
+		}
+		acc := entity.NewAccount(entity.AccountIDType(id), email)
+		err = u.repo.Create(acc)
+		if CheckEs(err, EsAlreadyExists) {
+			// Taken since the checks, by another server on the same db
+			if u.service.AlreadyExists(email) {
+				return nil, NewEs(EsAlreadyExists, "User Account")
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return acc, nil
+	}
+	return nil, NewEs(EsOutOfResources, "no free account id")
+}
+
 func (u *accountUsecase) GetSession() SessionUsecase {
 	return u.session
 }
@@ -93,6 +129,61 @@ func (u *accountUsecase) UpdateNameAccount(email string, firstname *string, last
 	return err
 }
 
+// ChangeEmail moves the account to a new address. The id doesn't change so folders, sessions
+// and profiles stay with the account, messages left pending for the new address are delivered
+func (u *accountUsecase) ChangeEmail(email string, newEmail string) (*Account, error) {
+	if strings.TrimSpace(newEmail) == "" {
+		return nil, NewEs(EsArgInvalid, fmt.Sprintf("email %s", newEmail))
+	}
+	return u.UpdateAccount(email, newEmail, nil, nil)
+}
+
+// UpdateAccount changes the names and, when newEmail isn't empty, the address in a single write,
+// so a rejected address leaves the names as they were. A nil name isn't changed
+func (u *accountUsecase) UpdateAccount(email string, newEmail string, firstname *string, lastname *string) (*Account, error) {
+	if newEmail != "" {
+		newEmail = strings.TrimSpace(newEmail)
+		if !IsValidEmailStr(newEmail) {
+			return nil, NewEs(EsArgInvalid, fmt.Sprintf("email %s", newEmail))
+		}
+	}
+
+	u.mtx.Lock()
+	a, err := u.repo.Retrieve(email)
+	if err != nil || a == nil {
+		u.mtx.Unlock()
+		return nil, NewEs(EsNotFound, "Email")
+	}
+	// Only a change of case keeps the same index entry
+	if newEmail != "" {
+		if id, err := u.repo.RetrieveIDByEmail(newEmail); err == nil && id != a.GetID() {
+			u.mtx.Unlock()
+			return nil, NewEs(EsAlreadyExists, fmt.Sprintf("Account with email %s", newEmail))
+		}
+	}
+	if firstname != nil {
+		a.FirstName = *firstname
+	}
+	if lastname != nil {
+		a.LastName = *lastname
+	}
+	oldEmail := a.GetEmail()
+	if newEmail != "" {
+		a.SetEmail(newEmail)
+	}
+	err = u.repo.Update(a)
+	u.mtx.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if newEmail != "" {
+		u.service.NotifyChangeEmail(*a, oldEmail)
+	}
+	out := toAccount([]*entity.Account{a})
+	return out[0], nil
+}
+
 func (u *accountUsecase) DeleteAccount(email string) error {
 	a, err := u.repo.Retrieve(email)
 	if err != nil {
@@ -126,7 +217,8 @@ func toAccount(Accounts []*entity.Account) []*Account {
 	return res
 }
 
-// details of getting a unique id for the account
+// GetUID 64 bit FNV hash of the string, collisions are possible so it's only used for keys
+// that are recomputed from what they identify (the pending msgs)
 func GetUID(in string) uint64 {
 	h := fnv.New64a()
 	h.Write([]byte(in))
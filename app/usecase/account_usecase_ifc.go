@@ -13,6 +13,8 @@ type AccountUsecase interface {
 	GetAccount(email string) (*Account, error)
 	RegisterAccount(email string) (*Account, error)
 	UpdateNameAccount(email string, firstname *string, lastname *string) error
+	ChangeEmail(email string, newEmail string) (*Account, error)
+	UpdateAccount(email string, newEmail string, firstname *string, lastname *string) (*Account, error)
 	DeleteAccount(email string) error
 
 	GetSession() SessionUsecase
@@ -30,6 +32,12 @@ type Account struct {
 
 type AccountIDType entity.AccountIDType
 
+// AccountIDAllocator hands out candidate ids for new accounts. A candidate can still be
+// taken, RegisterAccount checks and asks again
+type AccountIDAllocator interface {
+	Next() (AccountIDType, error)
+}
+
 const AccountIDBits = entity.AccountIDBits
 const AccounIDStringBase = entity.AccountIDStringBase
 
@@ -0,0 +1,157 @@
+package usecase_test
+
+import (
+	"testing"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/domain/service"
+	"github.com/git-sim/tc/app/io/storage/ram"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// fixedIDs hands out the ids in order then repeats the last one
+type fixedIDs []usecase.AccountIDType
+
+func (f *fixedIDs) Next() (usecase.AccountIDType, error) {
+	id := (*f)[0]
+	if len(*f) > 1 {
+		*f = (*f)[1:]
+	}
+	return id, nil
+}
+
+func TestAccountIDs(t *testing.T) {
+	dbAccounts := ram.NewAccountRepo()
+	dbAccounts.Create(entity.NewAccount(7, "old@mail.com"))
+	accServ := service.NewAccountService(dbAccounts)
+
+	// A taken id is skipped, if they're all taken registering fails rather than overwriting
+	ids := &fixedIDs{7, 7, 8}
+	acc := usecase.NewAccountUsecase(dbAccounts, nil, accServ, ids)
+	if got, err := acc.RegisterAccount("alice@mail.com"); err != nil || got.ID != "8" {
+		t.Errorf("alice expected id 8 got %v %v", got, err)
+	}
+	if _, err := acc.RegisterAccount("bob@mail.com"); !usecase.CheckEs(err, usecase.EsOutOfResources) {
+		t.Errorf("bob with only taken ids expected EsOutOfResources got %v", err)
+	}
+	if got, _ := dbAccounts.RetrieveByID(8); got.GetEmail() != "alice@mail.com" {
+		t.Errorf("alice's account was overwritten by %s", got.GetEmail())
+	}
+
+	// Sequential ids carry on after the highest stored one
+	acc = usecase.NewAccountUsecase(dbAccounts, nil, accServ, usecase.NewSequentialAccountIDs(dbAccounts))
+	for _, want := range []string{"9", "a"} {
+		if got, err := acc.RegisterAccount(want + "@mail.com"); err != nil || got.ID != want {
+			t.Errorf("expected id %s got %v %v", want, got, err)
+		}
+	}
+
+	acc = usecase.NewAccountUsecase(dbAccounts, nil, accServ, usecase.NewRandomAccountIDs())
+	got, err := acc.RegisterAccount("carol@mail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := usecase.ToAccountID(got.ID); id == 0 {
+		t.Errorf("random id expected non zero got %v", got)
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	_, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		SenderEmail: "bob@mail.com",
+		Recipients:  []string{"carol@mail.com"},
+		Subject:     "for carol",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forAlice, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		SenderEmail: "bob@mail.com",
+		Recipients:  []string{"alice@mail.com"},
+		Subject:     "for alice",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		email, newEmail string
+		es              int
+	}{
+		{"alice@mail.com", "Bob@mail.com", usecase.EsAlreadyExists},
+		{"alice@mail.com", " ", usecase.EsArgInvalid},
+		{"nobody@mail.com", "dan@mail.com", usecase.EsNotFound},
+	} {
+		if _, err := ts.acc.ChangeEmail(tc.email, tc.newEmail); !usecase.CheckEs(err, tc.es) {
+			t.Errorf("%s to %q expected %d got %v", tc.email, tc.newEmail, tc.es, err)
+		}
+	}
+
+	// Alice takes carol's address, keeps her id and gets the msg waiting there
+	acc, err := ts.acc.ChangeEmail("alice@mail.com", "Carol@mail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := usecase.ToAccountID(acc.ID); id != ids[0] || acc.Email != "Carol@mail.com" {
+		t.Errorf("expected alice's id with the new email got %+v", acc)
+	}
+	if got, err := ts.acc.GetAccount("carol@mail.com"); err != nil || got.ID != acc.ID {
+		t.Errorf("lookup by the new email expected alice got %v %v", got, err)
+	}
+	if _, err := ts.acc.GetAccount("alice@mail.com"); err == nil {
+		t.Error("the old email still finds the account")
+	}
+	if n := ts.count(t, ids[0], usecase.EnumInbox); n != 2 {
+		t.Errorf("inbox expected the pending msg too got %d", n)
+	}
+	// What came to the old address can still be replied to and forwarded
+	_, err = ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		ParentMid:   entity.MsgIDType(forAlice),
+		SenderEmail: "carol@mail.com",
+		Recipients:  []string{"bob@mail.com"},
+	})
+	if err != nil {
+		t.Errorf("reply after the change %v", err)
+	}
+	if _, err := ts.msg.ForwardMsg(ids[0], forAlice, []string{"bob@mail.com"}, false); err != nil {
+		t.Errorf("forward after the change %v", err)
+	}
+	if n, _ := ts.dbPending.RetrieveCount(); n != 0 {
+		t.Errorf("pending expected empty got %d", n)
+	}
+
+	// A change of case is allowed, the old address is free again
+	if acc, err = ts.acc.ChangeEmail("carol@mail.com", "carol@mail.com"); err != nil || acc.Email != "carol@mail.com" {
+		t.Errorf("change of case expected carol@mail.com got %v %v", acc, err)
+	}
+	ts.register(t, "alice@mail.com")
+}
+
+func TestUpdateAccount(t *testing.T) {
+	ts := newTestSystem(t)
+	ts.register(t, "alice@mail.com", "bob@mail.com")
+	first, last := "Alice", "Smith"
+	if _, err := ts.acc.UpdateAccount("alice@mail.com", "", &first, &last); err != nil {
+		t.Fatal(err)
+	}
+
+	// A taken address rejects the whole update, the names stay as they were
+	other := "Eve"
+	if _, err := ts.acc.UpdateAccount("alice@mail.com", "bob@mail.com", &other, nil); !usecase.CheckEs(err, usecase.EsAlreadyExists) {
+		t.Errorf("taken email expected EsAlreadyExists got %v", err)
+	}
+	if got, err := ts.acc.GetAccount("alice@mail.com"); err != nil || got.FirstName != "Alice" {
+		t.Errorf("rejected update expected the name unchanged got %v %v", got, err)
+	}
+
+	// Both at once, the last name is left out so it's kept
+	got, err := ts.acc.UpdateAccount("alice@mail.com", "eve@mail.com", &other, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != "eve@mail.com" || got.FirstName != "Eve" || got.LastName != "Smith" {
+		t.Errorf("expected eve@mail.com Eve Smith got %+v", got)
+	}
+}
@@ -1,9 +1,13 @@
 package usecase
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/git-sim/tc/app/domain/entity"
@@ -12,9 +16,30 @@ import (
 )
 
 type foldersUsecase struct {
-	dbFolders   repo.Generic        // This is a container of collections map[accId][]Folder
-	dbFactoryFn func() repo.Generic // Function used to instantiate new collections
-	service     *service.AccountService
+	mtx             *sync.Mutex                                                 // makes the read-modify-write of entries (moves, flag updates) atomic
+	dbFolders       repo.Store[entity.AccountIDType, AccountFolders]            // This is a container of collections map[accId][]Folder
+	folderFactoryFn func() repo.IndexedStore[entity.MsgIDType, entity.MsgEntry] // Function used to instantiate new folders
+	mutedFactoryFn  func() repo.Store[entity.ThreadIDType, bool]                // and the muted thread sets
+	service         *service.AccountService
+
+	removedSubscribers []func(MsgIDType)
+	changeSubscribers  []func(FolderChange)
+}
+
+// folderEntry is one folder in an account's registry
+type folderEntry struct {
+	Name string
+	Repo repo.IndexedStore[entity.MsgIDType, entity.MsgEntry]
+}
+
+// AccountFolders is what gets stored per account in dbFolders. The system folders sit at their
+// Enum idx, user folders are numbered from EnumFirstUserFolder up. The registry is copy on write,
+// changes store a new AccountFolders so readers holding the old one aren't disturbed.
+type AccountFolders struct {
+	Owner       AccountIDType
+	Folders     map[int]folderEntry
+	NextUserIdx int                                   // user folder idxs aren't reused so a stale idx can't hit a new folder
+	Muted       repo.Store[entity.ThreadIDType, bool] // set of muted thread ids
 }
 
 // Define what folders the user starts with
@@ -22,8 +47,10 @@ type foldersUsecase struct {
 // InboxFolderType def
 type InboxFolderType map[entity.MsgIDType]entity.MsgEntry
 type ArchiveFolderType map[entity.MsgIDType]entity.MsgEntry
-type SentFolderType map[entity.MsgIDType]entity.Msg
-type ScheduledFolderType map[entity.MsgIDType]entity.MsgBase
+type SentFolderType map[entity.MsgIDType]entity.MsgEntry
+type ScheduledFolderType map[entity.MsgIDType]entity.MsgEntry
+type TrashFolderType map[entity.MsgIDType]entity.MsgEntry
+type DraftsFolderType map[entity.MsgIDType]entity.MsgEntry
 
 // Some helper structs for table driven code make it easier to add remove folders in the design
 type folderDesc struct {
@@ -33,68 +60,335 @@ type folderDesc struct {
 }
 
 var folderDescs = [EnumNumFolders]folderDesc{
+	// MAINT NOTE keep in the same order as the folder Enums
 	folderDesc{"Inbox", reflect.TypeOf(InboxFolderType{}), reflect.TypeOf(entity.MsgEntry{})},
 	folderDesc{"Archive", reflect.TypeOf(ArchiveFolderType{}), reflect.TypeOf(entity.MsgEntry{})},
-	folderDesc{"Sent", reflect.TypeOf(SentFolderType{}), reflect.TypeOf(entity.Msg{})},
-	folderDesc{"Scheduled", reflect.TypeOf(ScheduledFolderType{}), reflect.TypeOf(entity.MsgBase{})},
+	folderDesc{"Sent", reflect.TypeOf(SentFolderType{}), reflect.TypeOf(entity.MsgEntry{})},
+	folderDesc{"Scheduled", reflect.TypeOf(ScheduledFolderType{}), reflect.TypeOf(entity.MsgEntry{})},
+	folderDesc{"Trash", reflect.TypeOf(TrashFolderType{}), reflect.TypeOf(entity.MsgEntry{})},
+	folderDesc{"Drafts", reflect.TypeOf(DraftsFolderType{}), reflect.TypeOf(entity.MsgEntry{})},
 }
 
 // NewFoldersUsecase ctor
-func NewFoldersUsecase(dbFolders repo.Generic, dbFactoryFn func() repo.Generic, service *service.AccountService) FoldersUsecase {
+func NewFoldersUsecase(dbFolders repo.Store[entity.AccountIDType, AccountFolders],
+	folderFactoryFn func() repo.IndexedStore[entity.MsgIDType, entity.MsgEntry],
+	mutedFactoryFn func() repo.Store[entity.ThreadIDType, bool],
+	service *service.AccountService) FoldersUsecase {
 	// Create a base repository
 	return &foldersUsecase{
-		dbFolders:   dbFolders,
-		dbFactoryFn: dbFactoryFn,
-		service:     service,
+		mtx:             &sync.Mutex{},
+		dbFolders:       dbFolders,
+		folderFactoryFn: folderFactoryFn,
+		mutedFactoryFn:  mutedFactoryFn,
+		service:         service,
 	}
 }
 
 // CreateNewFolders for a new account
 func (f *foldersUsecase) CreateNewFolders(acc entity.Account) error {
 
-	//Create the array of folders for each account
-	var folderArray = [EnumNumFolders]repo.Generic{}
+	//Create the system folders for each account, the user adds their own later
+	af := AccountFolders{
+		Owner:       AccountIDType(acc.GetID()),
+		Folders:     make(map[int]folderEntry),
+		NextUserIdx: EnumFirstUserFolder,
+		Muted:       f.mutedFactoryFn(),
+	}
 	for i := 0; i < EnumNumFolders; i++ {
-		folderArray[i] = f.dbFactoryFn()
+		af.Folders[i] = folderEntry{Name: folderDescs[i].Name, Repo: f.folderFactoryFn()}
 	}
 	// Add it to the dbFolders
-	f.dbFolders.Create(repo.GenericKeyT(acc.GetID()), folderArray)
-	return nil
+	return f.dbFolders.Create(acc.GetID(), af)
+}
+
+// getAccountFolders retrieves the folders stored for an account
+func (f *foldersUsecase) getAccountFolders(id AccountIDType) (*AccountFolders, error) {
+	af, err := f.dbFolders.Retrieve(entity.AccountIDType(id))
+	if err != nil {
+		return nil, NewEs(EsNotFound,
+			fmt.Sprintf("Folders for account %s", AccountIDToString(id)))
+	}
+	return &af, nil
+}
+
+// folder looks up a folder repo by idx
+func (af *AccountFolders) folder(idx int) (repo.IndexedStore[entity.MsgIDType, entity.MsgEntry], error) {
+	fe, ok := af.Folders[idx]
+	if !ok {
+		return nil, NewEs(EsNotFound,
+			fmt.Sprintf("folder idx %d", idx))
+	}
+	return fe.Repo, nil
+}
+
+// idxs returns the folder idxs in order, system folders first
+func (af *AccountFolders) idxs() []int {
+	idxs := make([]int, 0, len(af.Folders))
+	for idx := range af.Folders {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	return idxs
+}
+
+// isEntryFolder folders whose entries have the viewed, starred state and messages can be
+// moved between them. Sent, Scheduled & Drafts entries carry just the message
+func isEntryFolder(idx int) bool {
+	return idx != EnumSent && idx != EnumScheduled && idx != EnumDrafts
+}
+
+// isMuted checks the thread against the account's muted set
+func (af *AccountFolders) isMuted(tid ThreadIDType) bool {
+	_, err := af.Muted.Retrieve(entity.ThreadIDType(tid))
+	return err == nil
 }
 
 // Add a message to a user's folder
 func (f *foldersUsecase) AddToFolder(folderEnum int, id AccountIDType, msg MsgEntry) error {
-	// should be an assert
-	if folderEnum < 0 || EnumNumFolders <= folderEnum {
-		return NewEs(EsArgInvalid,
-			fmt.Sprintf("folderEnum %d", folderEnum))
+	// Locked so the folder can't be deleted between looking it up and writing to it
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return err
+	}
+	folder, err := af.folder(folderEnum)
+	if err != nil {
+		return err
+	}
+	enmsg := entity.MsgEntry(msg) //Entity messages go in to the repos
+	if folderEnum == EnumInbox {
+		// Muted threads still get delivered, they just arrive already viewed
+		if af.isMuted(ThreadIDType(enmsg.M.Tid)) && !enmsg.IsViewed {
+			enmsg.IsViewed = true
+			enmsg.ViewedAt = time.Now()
+		}
+	}
+	if err := folder.Create(enmsg.Mid, toFolderVal(folderEnum, enmsg)); err != nil {
+		return err
+	}
+	f.notifyChange(FolderChange{ID: id, Mid: MsgIDType(msg.Mid), From: EnumNoFolder, To: folderEnum, Msg: MsgEntry(enmsg)})
+	return nil
+}
+
+// toFolderVal what's kept in the folder, Sent, Scheduled & Drafts keep just the message
+func toFolderVal(folderEnum int, enmsg entity.MsgEntry) entity.MsgEntry {
+	if isEntryFolder(folderEnum) {
+		return enmsg
+	}
+	return *entity.NewMsgEntry(enmsg.M)
+}
+
+// RetrieveFromFolder gets the entry for one message in a user's folder, EsNotFound if it isn't there
+func (f *foldersUsecase) RetrieveFromFolder(folderEnum int, id AccountIDType, mid MsgIDType) (*MsgEntry, error) {
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := af.folder(folderEnum)
+	if err != nil {
+		return nil, err
+	}
+	val, err := folder.Retrieve(entity.MsgIDType(mid))
+	if err != nil {
+		return nil, NewEs(EsNotFound,
+			fmt.Sprintf("msg %s in folder %s", MsgIDToString(mid), af.Folders[folderEnum].Name))
+	}
+	elem := MsgEntry(val)
+	return &elem, nil
+}
+
+// RetrieveFolder every entry of the one folder, in no particular order
+func (f *foldersUsecase) RetrieveFolder(folderEnum int, id AccountIDType) ([]MsgEntry, error) {
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := af.folder(folderEnum)
+	if err != nil {
+		return nil, err
 	}
+	vals, err := folder.RetrieveAll()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]MsgEntry, len(vals))
+	for i, val := range vals {
+		out[i] = MsgEntry(val)
+	}
+	return out, nil
+}
 
-	acckey := repo.GenericKeyT(id)
-	val, err := f.dbFolders.Retrieve(acckey)
+// RemoveFromFolder deletes the message from a user's folder, EsNotFound if it isn't there
+func (f *foldersUsecase) RemoveFromFolder(folderEnum int, id AccountIDType, mid MsgIDType) error {
+	f.mtx.Lock()
+	af, err := f.getAccountFolders(id)
 	if err != nil {
+		f.mtx.Unlock()
 		return err
 	}
-	folders := val.([EnumNumFolders]repo.Generic)
-	msgkey := repo.GenericKeyT(msg.Mid)
+	folder, err := af.folder(folderEnum)
+	if err != nil {
+		f.mtx.Unlock()
+		return err
+	}
+	msgkey := entity.MsgIDType(mid)
+	if _, err := folder.Retrieve(msgkey); err != nil {
+		f.mtx.Unlock()
+		return NewEs(EsNotFound,
+			fmt.Sprintf("msg %s in folder %s", MsgIDToString(mid), af.Folders[folderEnum].Name))
+	}
+	err = folder.Delete(msgkey)
+	f.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	f.notifyChange(FolderChange{ID: id, Mid: mid, From: folderEnum, To: EnumNoFolder})
+	f.notifyRemoved([]MsgIDType{mid})
+	return nil
+}
 
-	enmsg := entity.MsgEntry(msg) //Entity messages go in to the repos
-	//Convert to the desired type in folder (unnecessary complexity?)
-	// reverted to switches, need to figure out how to switch on ElemT
-	switch folderEnum {
-	case EnumInbox:
-		err = folders[folderEnum].Create(msgkey, enmsg)
-	case EnumArchive:
-		err = folders[folderEnum].Create(msgkey, enmsg)
-	case EnumSent:
-		err = folders[folderEnum].Create(msgkey, enmsg.M)
-	case EnumScheduled:
-		err = folders[folderEnum].Create(msgkey, enmsg.M)
-	default:
-		err = NewEs(EsInternalError, "Unknown msg type for folder")
-	}
-	//
-	return err
+// SubscribeRemoved same simple pub-sub as the AccountService, subscribe at bootup
+func (f *foldersUsecase) SubscribeRemoved(fn func(MsgIDType)) {
+	f.removedSubscribers = append(f.removedSubscribers, fn)
+}
+
+// SubscribeChange subscribe at bootup
+func (f *foldersUsecase) SubscribeChange(fn func(FolderChange)) {
+	f.changeSubscribers = append(f.changeSubscribers, fn)
+}
+
+// notifyChange may be called holding f.mtx, subscribers mustn't call back in
+func (f *foldersUsecase) notifyChange(ch FolderChange) {
+	for _, fn := range f.changeSubscribers {
+		fn(ch)
+	}
+}
+
+// notifyRemoved call without holding f.mtx, subscribers may call back in
+func (f *foldersUsecase) notifyRemoved(mids []MsgIDType) {
+	for _, mid := range mids {
+		for _, fn := range f.removedSubscribers {
+			fn(mid)
+		}
+	}
+}
+
+// IsReferenced scans the folders of all accounts for the message
+func (f *foldersUsecase) IsReferenced(mid MsgIDType) bool {
+	vals, err := f.dbFolders.RetrieveAll()
+	if err != nil {
+		return true // can't tell, err on the side of keeping it
+	}
+	msgkey := entity.MsgIDType(mid)
+	for _, af := range vals {
+		for _, fe := range af.Folders {
+			if _, err := fe.Repo.Retrieve(msgkey); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HoldsMsg checks if the message is in any of the account's folders
+func (f *foldersUsecase) HoldsMsg(id AccountIDType, mid MsgIDType) bool {
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return false
+	}
+	msgkey := entity.MsgIDType(mid)
+	for _, fe := range af.Folders {
+		if _, err := fe.Repo.Retrieve(msgkey); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ForEachInFolder walks the given folder for all the accounts
+func (f *foldersUsecase) ForEachInFolder(folderEnum int, fn func(msg MsgEntry)) error {
+	vals, err := f.dbFolders.RetrieveAll()
+	if err != nil {
+		return err
+	}
+	for _, af := range vals {
+		folder, err := af.folder(folderEnum)
+		if err != nil {
+			continue // user folder idxs are per account
+		}
+		msgs, err := folder.RetrieveAll()
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			fn(MsgEntry(msg))
+		}
+	}
+	return nil
+}
+
+// ForEachInAccount walks all the folders of one account
+func (f *foldersUsecase) ForEachInAccount(id AccountIDType, fn func(folderEnum int, msg MsgEntry)) error {
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return err
+	}
+	for _, folderEnum := range af.idxs() {
+		msgs, err := af.Folders[folderEnum].Repo.RetrieveAll()
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			fn(folderEnum, MsgEntry(msg))
+		}
+	}
+	return nil
+}
+
+// ForEachInAllAccounts visits every message in every account's folders, used for bootup scans
+func (f *foldersUsecase) ForEachInAllAccounts(fn func(id AccountIDType, folderEnum int, msg MsgEntry)) error {
+	vals, err := f.dbFolders.RetrieveAll()
+	if err != nil {
+		return err
+	}
+	for _, af := range vals {
+		if err := f.ForEachInAccount(af.Owner, func(folderEnum int, msg MsgEntry) {
+			fn(af.Owner, folderEnum, msg)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetThreadMuted adds or removes the thread from the account's muted set
+func (f *foldersUsecase) SetThreadMuted(id AccountIDType, tid ThreadIDType, muted bool) error {
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return err
+	}
+	tidkey := entity.ThreadIDType(tid)
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	_, err = af.Muted.Retrieve(tidkey)
+	isMuted := err == nil
+	if muted && !isMuted {
+		return af.Muted.Create(tidkey, true)
+	}
+	if !muted && isMuted {
+		return af.Muted.Delete(tidkey)
+	}
+	return nil
+}
+
+// IsThreadMuted checks the account's muted set
+func (f *foldersUsecase) IsThreadMuted(id AccountIDType, tid ThreadIDType) (bool, error) {
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return false, err
+	}
+	return af.isMuted(tid), nil
 }
 
 func (f *foldersUsecase) ArchiveMsg(id AccountIDType, mid MsgIDType) error {
@@ -105,35 +399,364 @@ func (f *foldersUsecase) UnArchiveMsg(id AccountIDType, mid MsgIDType) error {
 	return f.moveBetweenFolders(EnumArchive, EnumInbox, id, mid)
 }
 
+// MoveMsg moves the message to destIdx from whichever of the Inbox, Archive or user folders has it
+func (f *foldersUsecase) MoveMsg(id AccountIDType, mid MsgIDType, destIdx int) error {
+	// The registry is read under the lock so the destination can't be deleted in between
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return err
+	}
+
+	msgkey := entity.MsgIDType(mid)
+	for _, srcIdx := range af.idxs() {
+		if !isEntryFolder(srcIdx) || srcIdx == EnumTrash {
+			continue
+		}
+		if _, err := af.Folders[srcIdx].Repo.Retrieve(msgkey); err == nil {
+			return f.move(id, af, srcIdx, destIdx, mid)
+		}
+	}
+	return NewEs(EsNotFound,
+		fmt.Sprintf("msg %s in folders", MsgIDToString(mid)))
+}
+
 func (f *foldersUsecase) moveBetweenFolders(srcEnum int, destEnum int, id AccountIDType, mid MsgIDType) error {
-	// should be an assert
-	if srcEnum < 0 || EnumNumFolders <= srcEnum {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return err
+	}
+	return f.move(id, af, srcEnum, destEnum, mid)
+}
+
+// move does the move and tells the change subscribers, the caller holds f.mtx
+func (f *foldersUsecase) move(id AccountIDType, af *AccountFolders, srcEnum int, destEnum int, mid MsgIDType) error {
+	if err := af.moveLocked(srcEnum, destEnum, mid); err != nil {
+		return err
+	}
+	if srcEnum != destEnum {
+		f.notifyChange(FolderChange{ID: id, Mid: mid, From: srcEnum, To: destEnum})
+	}
+	return nil
+}
+
+// moveLocked does the move, the caller holds f.mtx
+func (af *AccountFolders) moveLocked(srcEnum int, destEnum int, mid MsgIDType) error {
+	src, err := af.folder(srcEnum)
+	if err != nil {
 		return NewEs(EsArgInvalid,
 			fmt.Sprintf("srcEnum %d", srcEnum))
 	}
-
-	if destEnum < 0 || EnumNumFolders <= destEnum {
+	dest, err := af.folder(destEnum)
+	if err != nil {
 		return NewEs(EsArgInvalid,
 			fmt.Sprintf("destEnum %d", destEnum))
 	}
+	// Sent, Scheduled are managed by the system not moved in and out of,
+	// the Trash goes through DeleteMsg, RestoreMsg so the deletion info is kept
+	if !isEntryFolder(srcEnum) || !isEntryFolder(destEnum) ||
+		srcEnum == EnumTrash || destEnum == EnumTrash {
+		return NewEs(EsForbidden,
+			fmt.Sprintf("move from %d to %d", srcEnum, destEnum))
+	}
 
 	if srcEnum == destEnum {
 		return nil
 	}
 
-	// Todo move.  Get msg from src move to dest
+	msgkey := entity.MsgIDType(mid)
+	// The entry carries the viewed/starred state along with it
+	elem, err := src.Retrieve(msgkey)
+	if err != nil {
+		return NewEs(EsNotFound,
+			fmt.Sprintf("msg %s in folder %s", MsgIDToString(mid), af.Folders[srcEnum].Name))
+	}
+
+	// Write the destination first so the message is never in neither folder,
+	// undo it if the source can't be cleared
+	if err := dest.Create(msgkey, toFolderVal(destEnum, elem)); err != nil {
+		return err
+	}
+	if err := src.Delete(msgkey); err != nil {
+		dest.Delete(msgkey)
+		return err
+	}
 	return nil
 }
 
+// CreateFolder adds a user folder, names are unique per account ignoring case
+func (f *foldersUsecase) CreateFolder(id AccountIDType, name string) (*FolderInfo, error) {
+	name, err := validFolderName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return nil, err
+	}
+	if af.hasName(name, -1) {
+		return nil, NewEs(EsAlreadyExists,
+			fmt.Sprintf("folder %s", name))
+	}
+
+	newAf := af.clone()
+	idx := newAf.NextUserIdx
+	newAf.NextUserIdx++
+	newAf.Folders[idx] = folderEntry{Name: name, Repo: f.folderFactoryFn()}
+	if err := f.dbFolders.Update(entity.AccountIDType(id), newAf); err != nil {
+		return nil, err
+	}
+	return &FolderInfo{FolderName: name, Idx: idx, IsUserFolder: true}, nil
+}
+
+// RenameFolder only user folders can be renamed
+func (f *foldersUsecase) RenameFolder(id AccountIDType, idx int, name string) error {
+	name, err := validFolderName(name)
+	if err != nil {
+		return err
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return err
+	}
+	fe, err := af.userFolder(idx)
+	if err != nil {
+		return err
+	}
+	if af.hasName(name, idx) {
+		return NewEs(EsAlreadyExists,
+			fmt.Sprintf("folder %s", name))
+	}
+
+	newAf := af.clone()
+	newAf.Folders[idx] = folderEntry{Name: name, Repo: fe.Repo}
+	return f.dbFolders.Update(entity.AccountIDType(id), newAf)
+}
+
+// DeleteFolder removes a user folder, any messages still in it are moved to the Archive
+func (f *foldersUsecase) DeleteFolder(id AccountIDType, idx int) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return err
+	}
+	fe, err := af.userFolder(idx)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := fe.Repo.RetrieveAll()
+	if err != nil {
+		return err
+	}
+	for _, elem := range msgs {
+		if err := f.move(id, af, idx, EnumArchive, MsgIDType(elem.Mid)); err != nil {
+			return err
+		}
+	}
+
+	newAf := af.clone()
+	delete(newAf.Folders, idx)
+	if err := f.dbFolders.Update(entity.AccountIDType(id), newAf); err != nil {
+		return err
+	}
+	// Nothing points to the emptied store now
+	return fe.Repo.Drop()
+}
+
+// userFolder looks up idx making sure it isn't a system folder
+func (af *AccountFolders) userFolder(idx int) (folderEntry, error) {
+	if idx < EnumFirstUserFolder {
+		return folderEntry{}, NewEs(EsForbidden,
+			fmt.Sprintf("system folder idx %d", idx))
+	}
+	fe, ok := af.Folders[idx]
+	if !ok {
+		return folderEntry{}, NewEs(EsNotFound,
+			fmt.Sprintf("folder idx %d", idx))
+	}
+	return fe, nil
+}
+
+// hasName checks if any folder other than exceptIdx already uses the name
+func (af *AccountFolders) hasName(name string, exceptIdx int) bool {
+	for idx, fe := range af.Folders {
+		if idx != exceptIdx && strings.EqualFold(fe.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// clone copies the registry so it can be changed without disturbing readers
+func (af *AccountFolders) clone() AccountFolders {
+	newAf := *af
+	newAf.Folders = make(map[int]folderEntry, len(af.Folders))
+	for idx, fe := range af.Folders {
+		newAf.Folders[idx] = fe
+	}
+	return newAf
+}
+
+func validFolderName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 || len(name) > MaxFolderNameLen {
+		return "", NewEs(EsArgInvalid,
+			fmt.Sprintf("folder name must be 1 to %d characters", MaxFolderNameLen))
+	}
+	return name, nil
+}
+
 func (f *foldersUsecase) DeleteMsg(id AccountIDType, mid MsgIDType) error {
-	// Tell the underlying function to do a delete, the filter exec function is a no-op
-	isDelete := true
-	return f.findAndExec(id, mid, isDelete, func(pMsg *entity.MsgEntry) {})
+	f.mtx.Lock()
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		f.mtx.Unlock()
+		return err
+	}
+	msgkey := entity.MsgIDType(mid)
+	trash := af.Folders[EnumTrash].Repo
+	if _, err := trash.Retrieve(msgkey); err == nil {
+		// Already in the Trash, this time it's for good
+		err = trash.Delete(msgkey)
+		f.mtx.Unlock()
+		if err != nil {
+			return err
+		}
+		f.notifyChange(FolderChange{ID: id, Mid: mid, From: EnumTrash, To: EnumNoFolder})
+		f.notifyRemoved([]MsgIDType{mid})
+		return nil
+	}
+	defer f.mtx.Unlock()
+
+	for _, idx := range af.idxs() {
+		if !isEntryFolder(idx) || idx == EnumTrash {
+			continue
+		}
+		folder := af.Folders[idx].Repo
+		elem, err := folder.Retrieve(msgkey)
+		if err != nil {
+			continue //next folder
+		}
+		elem.DeletedAt = time.Now()
+		elem.DeletedFrom = idx
+		if err := trash.Create(msgkey, elem); err != nil {
+			return err
+		}
+		if err := folder.Delete(msgkey); err != nil {
+			trash.Delete(msgkey)
+			return err
+		}
+		f.notifyChange(FolderChange{ID: id, Mid: mid, From: idx, To: EnumTrash})
+		return nil
+	}
+	return NewEs(EsNotFound,
+		fmt.Sprintf("msg %s in folders", MsgIDToString(mid)))
+}
+
+// RestoreMsg if the original folder was deleted in the meantime it goes to the Inbox
+func (f *foldersUsecase) RestoreMsg(id AccountIDType, mid MsgIDType) error {
+	// The registry is read under the lock so the folder it goes back to can't be deleted in between
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return err
+	}
+	msgkey := entity.MsgIDType(mid)
+	trash := af.Folders[EnumTrash].Repo
+
+	elem, err := trash.Retrieve(msgkey)
+	if err != nil {
+		return NewEs(EsNotFound,
+			fmt.Sprintf("msg %s in folder %s", MsgIDToString(mid), af.Folders[EnumTrash].Name))
+	}
+
+	destIdx := elem.DeletedFrom
+	if _, ok := af.Folders[destIdx]; !ok || !isEntryFolder(destIdx) || destIdx == EnumTrash {
+		destIdx = EnumInbox
+	}
+	elem.DeletedAt = time.Time{}
+	elem.DeletedFrom = 0
+
+	dest := af.Folders[destIdx].Repo
+	if err := dest.Create(msgkey, toFolderVal(destIdx, elem)); err != nil {
+		return err
+	}
+	if err := trash.Delete(msgkey); err != nil {
+		dest.Delete(msgkey)
+		return err
+	}
+	f.notifyChange(FolderChange{ID: id, Mid: mid, From: EnumTrash, To: destIdx})
+	return nil
+}
+
+func (f *foldersUsecase) EmptyTrash(id AccountIDType) (int, error) {
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return 0, err
+	}
+	mids, err := f.purgeTrash(af, func(entry entity.MsgEntry) bool { return true })
+	f.notifyRemoved(mids)
+	return len(mids), err
+}
+
+func (f *foldersUsecase) PurgeTrash(cutoff time.Time) (int, error) {
+	vals, err := f.dbFolders.RetrieveAll()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, af := range vals {
+		mids, err := f.purgeTrash(&af, func(entry entity.MsgEntry) bool {
+			return entry.DeletedAt.Before(cutoff)
+		})
+		f.notifyRemoved(mids)
+		n += len(mids)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// purgeTrash deletes the Trash entries selected by fn, returns the removed mids so the caller can notify
+func (f *foldersUsecase) purgeTrash(af *AccountFolders, fn func(entity.MsgEntry) bool) ([]MsgIDType, error) {
+	trash := af.Folders[EnumTrash].Repo
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	entries, err := trash.RetrieveFiltered(fn)
+	if err != nil {
+		return nil, err
+	}
+	mids := []MsgIDType{}
+	for _, entry := range entries {
+		if err := trash.Delete(entry.Mid); err != nil {
+			return mids, err
+		}
+		f.notifyChange(FolderChange{ID: af.Owner, Mid: MsgIDType(entry.Mid), From: EnumTrash, To: EnumNoFolder})
+		mids = append(mids, MsgIDType(entry.Mid))
+	}
+	return mids, nil
 }
 
 func (f *foldersUsecase) UpdateStarred(id AccountIDType, mid MsgIDType, newval bool) error {
-	isDelete := false
-	return f.findAndExec(id, mid, isDelete, func(pMsg *entity.MsgEntry) {
+	return f.findAndExec(id, mid, func(pMsg *entity.MsgEntry) {
 		if pMsg != nil {
 			pMsg.IsStarred = newval
 		}
@@ -141,8 +764,7 @@ func (f *foldersUsecase) UpdateStarred(id AccountIDType, mid MsgIDType, newval b
 }
 
 func (f *foldersUsecase) UpdateViewed(id AccountIDType, mid MsgIDType, newval bool) error {
-	isDelete := false
-	return f.findAndExec(id, mid, isDelete, func(pMsg *entity.MsgEntry) {
+	return f.findAndExec(id, mid, func(pMsg *entity.MsgEntry) {
 		if pMsg != nil {
 			pMsg.IsViewed = newval
 			if newval {
@@ -154,66 +776,197 @@ func (f *foldersUsecase) UpdateViewed(id AccountIDType, mid MsgIDType, newval bo
 	})
 }
 
-func (f *foldersUsecase) findAndExec(id AccountIDType, mid MsgIDType, isDelete bool, fn func(*entity.MsgEntry)) error {
+func (f *foldersUsecase) findAndExec(id AccountIDType, mid MsgIDType, fn func(*entity.MsgEntry)) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
 
 	// Messaging rule: only the messages in the Inbox, Archive (or user folders) have viewed,starred facility
-	acckey := repo.GenericKeyT(id)
-	val, err := f.dbFolders.Retrieve(acckey)
+	af, err := f.getAccountFolders(id)
 	if err != nil {
 		return err
 	}
-	folders := val.([EnumNumFolders]repo.Generic)
-	msgkey := repo.GenericKeyT(mid)
-
-	folderEnums := []int{EnumInbox, EnumArchive}
+	msgkey := entity.MsgIDType(mid)
 
 	found := false
-	for _, folderEnum := range folderEnums {
-		val, err := folders[folderEnum].Retrieve(msgkey)
+	for _, folderEnum := range af.idxs() {
+		if !isEntryFolder(folderEnum) {
+			continue
+		}
+		folder := af.Folders[folderEnum].Repo
+		msg, err := folder.Retrieve(msgkey)
 		if err != nil {
 			continue //next folder
 		}
 		found = true
 
-		msg, ok := val.(entity.MsgEntry)
-		if ok {
-			fn(&msg)
-			if isDelete {
-				folders[folderEnum].Delete(msgkey)
-			} else {
-				folders[folderEnum].Update(msgkey, msg)
-			}
-		}
+		fn(&msg)
+		folder.Update(msgkey, msg)
 	}
 	if found {
 		return nil
 	}
-	return err
+	return NewEs(EsNotFound,
+		fmt.Sprintf("msg %s in folders", MsgIDToString(mid)))
 }
 
 // Presenter Funcionality for the Folders
+
+// ListFolders gets the counts for every folder of the account
+func (f *foldersUsecase) ListFolders(id AccountIDType) (*FolderListOutput, error) {
+	af, err := f.getAccountFolders(id)
+	if err != nil {
+		return nil, err
+	}
+
+	pOut := &FolderListOutput{
+		FolderInfo: make([]FolderInfo, 0, len(af.Folders)),
+	}
+	for _, idx := range af.idxs() {
+		fe := af.Folders[idx]
+		total, err := fe.Repo.RetrieveCount()
+		if err != nil {
+			return nil, NewEs(EsInternalError,
+				fmt.Sprintf("Unable to count Folder idx %d", idx))
+		}
+		info := FolderInfo{
+			FolderName:   fe.Name,
+			Idx:          idx,
+			IsUserFolder: idx >= EnumFirstUserFolder,
+			NumTotal:     total,
+		}
+		if isEntryFolder(idx) {
+			unviewed, err := fe.Repo.Query(repo.Query[entity.MsgIDType, entity.MsgEntry]{
+				Filter: func(e entity.MsgEntry) bool { return af.isUnviewed(idx, e) },
+			})
+			if err != nil {
+				return nil, NewEs(EsInternalError,
+					fmt.Sprintf("Unable to count unviewed in Folder idx %d", idx))
+			}
+			info.NumUnviewed = unviewed.Total
+		}
+		pOut.FolderInfo = append(pOut.FolderInfo, info)
+	}
+	pOut.TotalNumberOfFolders = len(pOut.FolderInfo)
+	return pOut, nil
+}
+
+// isUnviewed only entry folders have the viewed state, muted threads don't count towards the unviewed total
+func (af *AccountFolders) isUnviewed(idx int, entry entity.MsgEntry) bool {
+	return isEntryFolder(idx) && !entry.IsViewed && !af.isMuted(ThreadIDType(entry.M.Tid))
+}
 func isValidQuery(qp QueryParams) (bool, error) {
-	return true, nil //todo checking already done by the handler, but the boundary needs it's own check
+	//todo the rest of the checking is done by the handler, but the boundary needs it's own check
+	if qp.Limit < 0 || qp.Page < 0 {
+		return false, NewEs(EsArgInvalid,
+			fmt.Sprintf("limit %d page %d", qp.Limit, qp.Page))
+	}
+	if qp.SortBy < 0 || qp.SortBy >= len(FolderIndexes) {
+		return false, NewEs(EsArgInvalid,
+			fmt.Sprintf("SortBy %d", qp.SortBy))
+	}
+	if !qp.After.IsZero() && !qp.Before.IsZero() && !qp.Before.After(qp.After) {
+		return false, NewEs(EsArgInvalid, "Before has to be later than After")
+	}
+	return true, nil
 }
 
-// Define out some sorters (these are less functions)
-type sorterMsgEntry func(i, j int, reverse bool, coll []MsgEntry) bool
+// threadSizes counts the messages per thread in the account's folders, the Trash and Drafts
+// aren't counted. A message sent to yourself counts once
+func (f *foldersUsecase) threadSizes(id AccountIDType) (map[entity.ThreadIDType]int, error) {
+	seen := make(map[entity.MsgIDType]bool)
+	sizes := make(map[entity.ThreadIDType]int)
+	err := f.ForEachInAccount(id, func(folderEnum int, msg MsgEntry) {
+		if folderEnum == EnumTrash || folderEnum == EnumDrafts || seen[msg.Mid] {
+			return
+		}
+		seen[msg.Mid] = true
+		sizes[msg.M.Tid]++
+	})
+	return sizes, err
+}
+
+// matchesFilters applies the QueryParams filters to one entry of the queried folder
+func (af *AccountFolders) matchesFilters(qp QueryParams, elem MsgEntry,
+	threadSizes map[entity.ThreadIDType]int) bool {
+	if qp.UnreadOnly && !af.isUnviewed(qp.FolderIdx, entity.MsgEntry(elem)) {
+		return false
+	}
+	if qp.StarredOnly && !elem.IsStarred {
+		return false
+	}
+	if qp.SenderEmail != "" && !strings.EqualFold(qp.SenderEmail, elem.M.M.SenderEmail) {
+		return false
+	}
+	at := msgTime(elem)
+	if !qp.After.IsZero() && at.Before(qp.After) {
+		return false
+	}
+	if !qp.Before.IsZero() && !at.Before(qp.Before) {
+		return false
+	}
+	if qp.HasThread && threadSizes[elem.M.Tid] < 2 {
+		return false
+	}
+	return true
+}
 
-var sortFuncs = map[int]sorterMsgEntry{
-	EnumSortByTime: func(i, j int, reverse bool, coll []MsgEntry) bool {
-		return coll[i].M.SentAt.Before(coll[j].M.SentAt) != reverse
-	},
-	EnumSortBySubject: func(i, j int, reverse bool, coll []MsgEntry) bool {
-		return (coll[i].M.M.Subject < coll[j].M.M.Subject) != reverse
-	},
-	EnumSortBySender: func(i, j int, reverse bool, coll []MsgEntry) bool {
-		return (coll[i].M.M.SenderEmail < coll[j].M.M.SenderEmail) != reverse
-	},
+// FolderIndexes the indexes the folder stores keep, one for each SortBy named by its SortText.
+// Messages with the same key are in mid order
+var FolderIndexes = []repo.Index[entity.MsgEntry]{
+	EnumSortByTime: {Name: sortText[EnumSortByTime], Key: func(e entity.MsgEntry) string {
+		return timeKey(e.M.SentAt)
+	}},
+	EnumSortBySubject: {Name: sortText[EnumSortBySubject], Key: func(e entity.MsgEntry) string {
+		return e.M.M.Subject
+	}},
+	EnumSortBySender: {Name: sortText[EnumSortBySender], Key: func(e entity.MsgEntry) string {
+		return e.M.M.SenderEmail
+	}},
+}
+
+// timeKey orders as t does, the seconds have the sign bit flipped so times before 1970 come first
+func timeKey(t time.Time) string {
+	return fmt.Sprintf("%016x%08x", uint64(t.Unix())^1<<63, t.Nanosecond())
+}
+
+// queryCursor what's behind the opaque MsgQueryOutput.NextCursor, the sort has to match to use it
+type queryCursor struct {
+	FolderIdx int              `json:"f"`
+	SortBy    int              `json:"b"`
+	SortOrder int              `json:"o"`
+	IndexKey  string           `json:"k"`
+	Mid       entity.MsgIDType `json:"m"`
+}
+
+func encodeCursor(qp QueryParams, last MsgEntry) string {
+	key := FolderIndexes[qp.SortBy].Key(entity.MsgEntry(last))
+	buf, _ := json.Marshal(queryCursor{qp.FolderIdx, qp.SortBy, qp.SortOrder, key, last.Mid})
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeCursor(qp QueryParams) (*repo.Cursor[entity.MsgIDType], error) {
+	c := queryCursor{}
+	buf, err := base64.RawURLEncoding.DecodeString(qp.Cursor)
+	if err == nil {
+		err = json.Unmarshal(buf, &c)
+	}
+	if err != nil {
+		return nil, NewEs(EsArgInvalid, "Cursor")
+	}
+	if c.FolderIdx != qp.FolderIdx || c.SortBy != qp.SortBy || c.SortOrder != qp.SortOrder {
+		return nil, NewEs(EsArgInvalid, "Cursor is for a different folder or sort")
+	}
+	return &repo.Cursor[entity.MsgIDType]{IndexKey: c.IndexKey, ID: c.Mid}, nil
+}
+
+// hasFilters whether any of the QueryParams filters are set
+func hasFilters(qp QueryParams) bool {
+	return qp.UnreadOnly || qp.StarredOnly || qp.SenderEmail != "" ||
+		!qp.After.IsZero() || !qp.Before.IsZero() || qp.HasThread
 }
 
 func (f *foldersUsecase) QueryMsgs(id AccountIDType, qp QueryParams) (*MsgQueryOutput, error) {
-	acckey := repo.GenericKeyT(id)
-	val, err := f.dbFolders.Retrieve(acckey)
+	af, err := f.getAccountFolders(id)
 	if err != nil {
 		return nil, err
 	}
@@ -222,77 +975,71 @@ func (f *foldersUsecase) QueryMsgs(id AccountIDType, qp QueryParams) (*MsgQueryO
 		return nil, err
 	}
 
-	folders := val.([EnumNumFolders]repo.Generic)
-	folder := folders[qp.FolderIdx]
+	folder, err := af.folder(qp.FolderIdx)
+	if err != nil {
+		return nil, err
+	}
 
 	pOut := &MsgQueryOutput{
 		Requested:  qp,
 		QueriedAt:  time.Now(),
-		FolderName: FolderText(qp.FolderIdx),
+		FolderName: af.Folders[qp.FolderIdx].Name,
 	}
 
-	msgs, err := folder.RetrieveAll()
-	if err != nil {
-		return nil, NewEs(EsInternalError,
-			fmt.Sprintf("Unable to retrieve from Folder idx %d", qp.FolderIdx))
-	}
-
-	elems := make([]MsgEntry, 0, len(msgs))
-
-	// The folder is a table/collection in the storage repo/database.
-	// todo Take advantage of the query capabilities in repositories.
-	// For now since the data is opaque to the db, we pull it and give it form here
-	// Also need to refactor this to put the type switching/dispatch in one call or interface
-	switch qp.FolderIdx {
-	case EnumSent:
-		fallthrough
-	case EnumScheduled:
-		// Sent & Scheduled contains type entity.Msg
-		for _, val := range msgs {
-			val2, _ := val.(entity.Msg)
-			elem := MsgEntry(*entity.NewMsgEntry(val2))
-			elems = append(elems, elem)
-		}
-
-	default:
-		// Inbox,Archive and all user folders contain entity.MsgEntry
-		numUnviewed := 0
-		for _, val := range msgs {
-			val2, _ := val.(entity.MsgEntry)
-			elem := MsgEntry(val2)
-			elems = append(elems, elem)
-			if !val2.IsViewed {
-				numUnviewed++
-			}
+	if isEntryFolder(qp.FolderIdx) {
+		unviewed, err := folder.Query(repo.Query[entity.MsgIDType, entity.MsgEntry]{
+			Filter: func(e entity.MsgEntry) bool { return af.isUnviewed(qp.FolderIdx, e) },
+		})
+		if err != nil {
+			return nil, NewEs(EsInternalError,
+				fmt.Sprintf("Unable to count unviewed in Folder idx %d", qp.FolderIdx))
 		}
-		pOut.NumUnviewed = numUnviewed
+		pOut.NumUnviewed = unviewed.Total
 	}
 
-	nelems := len(elems)
-	pOut.NumTotal = nelems
-	// Ok we have the data in the elems array now sort it, select it out
-	sort.Slice(elems, func(i, j int) bool {
-		return sorterMsgEntry(sortFuncs[qp.SortBy])(i, j, qp.SortOrder == 0, elems)
-	})
-	// Offset and trim the response, make sure we never send more than the limit
-	startIdx := qp.Page * qp.Limit
-	if startIdx >= nelems {
-		// past the end no items to add
-		pOut.NumElems = 0
-		return pOut, nil
+	// The folder store keeps the messages sorted by each SortBy, it filters and pages.
+	// One more than the limit is asked for to know if there's a next page.
+	// A cursor picks up right after the last message of the previous response,
+	// so messages arriving in between don't shift what comes next
+	q := repo.Query[entity.MsgIDType, entity.MsgEntry]{
+		Index:  FolderIndexes[qp.SortBy].Name,
+		Desc:   qp.SortOrder == 0,
+		Offset: qp.Page * qp.Limit,
+		Limit:  qp.Limit + 1,
 	}
-	nToSend := qp.Limit //min(limit,nelems-startIdx)
-	if nelems-startIdx < nToSend {
-		nToSend = nelems - startIdx
+	if hasFilters(qp) {
+		var threadSizes map[entity.ThreadIDType]int
+		if qp.HasThread {
+			if threadSizes, err = f.threadSizes(id); err != nil {
+				return nil, err
+			}
+		}
+		q.Filter = func(e entity.MsgEntry) bool { return af.matchesFilters(qp, MsgEntry(e), threadSizes) }
 	}
-
-	pOut.NumElems = nToSend
-	if nToSend > 0 {
-		pOut.Elems = elems[startIdx : startIdx+nToSend] //[a:a] is 0 len slice in go which works here
-	} else {
-		// bad etiquette to send null, send empty list instead
-		pOut.Elems = []MsgEntry{}
+	if qp.Cursor != "" {
+		if q.After, err = decodeCursor(qp); err != nil {
+			return nil, err
+		}
+		q.Offset = 0
+	}
+	res, err := folder.Query(q)
+	if err != nil {
+		return nil, NewEs(EsInternalError,
+			fmt.Sprintf("Unable to query Folder idx %d", qp.FolderIdx))
 	}
+	pOut.NumTotal = res.Total
 
+	elems := make([]MsgEntry, 0, len(res.Vals))
+	for _, val := range res.Vals {
+		elems = append(elems, MsgEntry(val))
+	}
+	if len(elems) > qp.Limit {
+		elems = elems[:qp.Limit]
+		if qp.Limit > 0 {
+			pOut.NextCursor = encodeCursor(qp, elems[len(elems)-1])
+		}
+	}
+	pOut.NumElems = len(elems)
+	pOut.Elems = elems
 	return pOut, nil
 }
@@ -11,14 +11,27 @@ const (
 	EnumArchive
 	EnumSent
 	EnumScheduled
-	EnumNumFolders
+	EnumTrash
+	EnumDrafts
+	EnumNumFolders // the system folders every account has
 )
 
+// EnumNoFolder stands in for the missing side of a FolderChange
+const EnumNoFolder = -1
+
+// EnumFirstUserFolder user created folders get idxs from here up, leaves room to add system folders
+const EnumFirstUserFolder = 100
+
+// MaxFolderNameLen limit on user folder names
+const MaxFolderNameLen = 40
+
 var folderText = map[int]string{
 	EnumInbox:     "inbox",
 	EnumArchive:   "archive",
 	EnumSent:      "sent",
 	EnumScheduled: "scheduled",
+	EnumTrash:     "trash",
+	EnumDrafts:    "drafts",
 }
 
 // FolderText returns empty string if invalid
@@ -44,22 +57,57 @@ func SortText(code int) string {
 	return sortText[code]
 }
 
+// FolderInfo describes one of an account's folders
+type FolderInfo struct {
+	FolderName   string
+	Idx          int
+	IsUserFolder bool
+	NumTotal     int
+	NumUnviewed  int
+}
+
+// FolderListOutput result of ListFolders, system folders first then user folders by idx
+type FolderListOutput struct {
+	TotalNumberOfFolders int
+	FolderInfo           []FolderInfo
+}
+
+// FolderChange a message was added to, removed from, or moved between an account's folders.
+// From is EnumNoFolder for an add, To is EnumNoFolder for a remove. Msg is only set on an add
+type FolderChange struct {
+	ID   AccountIDType
+	Mid  MsgIDType
+	From int
+	To   int
+	Msg  MsgEntry
+}
+
 type QueryParams struct {
 	FolderIdx int
 	SortBy    int
 	SortOrder int
 	Limit     int
-	Page      int
+	Page      int    // ignored when there's a Cursor
+	Cursor    string // NextCursor from the previous response, same folder and sort
+
+	// Filters, applied before paging so NumTotal counts what matched. Zero values don't filter
+	UnreadOnly  bool
+	StarredOnly bool
+	SenderEmail string    // ignores case
+	After       time.Time // sent at or after
+	Before      time.Time // sent before
+	HasThread   bool      // the thread has other messages in the account's folders
 }
 
 type MsgQueryOutput struct {
 	Requested   QueryParams
 	QueriedAt   time.Time
 	FolderName  string
-	NumTotal    int
-	NumUnviewed int
+	NumTotal    int // after filtering
+	NumUnviewed int // in the whole folder
 	NumElems    int //in the folder
 	Elems       []MsgEntry
+	NextCursor  string // pass back in QueryParams.Cursor for the next page, empty at the end
 }
 
 // FoldersUsecase handles folder management
@@ -67,21 +115,56 @@ type FoldersUsecase interface {
 
 	// Controller related functionality
 	AddToFolder(folderEnum int, id AccountIDType, msg MsgEntry) error
+	RemoveFromFolder(folderEnum int, id AccountIDType, mid MsgIDType) error
+	RetrieveFromFolder(folderEnum int, id AccountIDType, mid MsgIDType) (*MsgEntry, error)
+	RetrieveFolder(folderEnum int, id AccountIDType) ([]MsgEntry, error)
 
 	UpdateViewed(id AccountIDType, mid MsgIDType, newval bool) error
 	UpdateStarred(id AccountIDType, mid MsgIDType, newval bool) error
 	ArchiveMsg(id AccountIDType, mid MsgIDType) error
 	UnArchiveMsg(id AccountIDType, mid MsgIDType) error
+	// MoveMsg moves between the Inbox, Archive and user folders
+	MoveMsg(id AccountIDType, mid MsgIDType, destIdx int) error
+	// DeleteMsg moves the message to the Trash, deleting a message already in the Trash removes it for good
 	DeleteMsg(id AccountIDType, mid MsgIDType) error
+	// RestoreMsg puts a message in the Trash back in the folder it was deleted from
+	RestoreMsg(id AccountIDType, mid MsgIDType) error
+	// EmptyTrash removes everything in the Trash for good, returns the number removed
+	EmptyTrash(id AccountIDType) (int, error)
+
+	// User folders
+	CreateFolder(id AccountIDType, name string) (*FolderInfo, error)
+	RenameFolder(id AccountIDType, idx int, name string) error
+	DeleteFolder(id AccountIDType, idx int) error
+
+	// Per account set of muted threads, stored alongside the folders
+	SetThreadMuted(id AccountIDType, tid ThreadIDType, muted bool) error
+	IsThreadMuted(id AccountIDType, tid ThreadIDType) (bool, error)
 
 	// Presenter Functions
 	QueryMsgs(id AccountIDType, qp QueryParams) (*MsgQueryOutput, error)
-	//QueryThreads()
-
-	//List
+	// ListFolders all the folders with their counts in one call
+	ListFolders(id AccountIDType) (*FolderListOutput, error)
 
 	// For use by the system
 	//CreateNewFolders ... called by NofityNewAccount
 	CreateNewFolders(acc entity.Account) error
+	// ForEachInFolder visits the folderEnum folder of every account, used for bootup scans
+	ForEachInFolder(folderEnum int, fn func(msg MsgEntry)) error
+	// ForEachInAccount visits every message in all of an account's folders
+	ForEachInAccount(id AccountIDType, fn func(folderEnum int, msg MsgEntry)) error
+	// ForEachInAllAccounts visits every message in every account's folders
+	ForEachInAllAccounts(fn func(id AccountIDType, folderEnum int, msg MsgEntry)) error
+	// PurgeTrash removes the Trash entries of every account that were deleted before cutoff
+	PurgeTrash(cutoff time.Time) (int, error)
+	// SubscribeRemoved fn is called after a message is removed from an account's folders for good
+	SubscribeRemoved(fn func(mid MsgIDType))
+	// SubscribeChange fn is called for every add, remove and move, it may be called with the
+	// folders locked so it mustn't call back into the FoldersUsecase
+	SubscribeChange(fn func(FolderChange))
+	// IsReferenced checks if the message is still in any account's folders
+	IsReferenced(mid MsgIDType) bool
+	// HoldsMsg checks if the message is in any of the account's folders
+	HoldsMsg(id AccountIDType, mid MsgIDType) bool
 	//Delete
 }
@@ -0,0 +1,484 @@
+package usecase_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+func TestArchiveMovesMsg(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	bob := ids[1]
+
+	mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		SenderEmail: "alice@mail.com",
+		Recipients:  []string{"bob@mail.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.fol.UpdateStarred(bob, mid, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.fol.UpdateViewed(bob, mid, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ts.fol.ArchiveMsg(bob, mid); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count(t, bob, usecase.EnumInbox); n != 0 {
+		t.Errorf("inbox count expected 0 got %d", n)
+	}
+	out, err := ts.fol.QueryMsgs(bob, usecase.QueryParams{FolderIdx: usecase.EnumArchive, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.NumTotal != 1 {
+		t.Fatalf("archive count expected 1 got %d", out.NumTotal)
+	}
+	if e := out.Elems[0]; !e.IsStarred || !e.IsViewed || e.ViewedAt.IsZero() {
+		t.Errorf("viewed/starred state lost in the move %+v", e)
+	}
+
+	// Already archived, nothing left in the inbox to move
+	if err := ts.fol.ArchiveMsg(bob, mid); !usecase.CheckEs(err, usecase.EsNotFound) {
+		t.Errorf("archive of missing msg expected EsNotFound got %v", err)
+	}
+
+	if err := ts.fol.UnArchiveMsg(bob, mid); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count(t, bob, usecase.EnumInbox); n != 1 {
+		t.Errorf("inbox count after unarchive expected 1 got %d", n)
+	}
+	if n := ts.count(t, bob, usecase.EnumArchive); n != 0 {
+		t.Errorf("archive count after unarchive expected 0 got %d", n)
+	}
+}
+
+func TestUserFolders(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	bob := ids[1]
+
+	receipts, err := ts.fol.CreateFolder(bob, " Receipts ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipts.FolderName != "Receipts" || receipts.Idx < usecase.EnumFirstUserFolder {
+		t.Errorf("unexpected folder info %+v", receipts)
+	}
+	if _, err := ts.fol.CreateFolder(bob, "receipts"); !usecase.CheckEs(err, usecase.EsAlreadyExists) {
+		t.Errorf("duplicate name expected EsAlreadyExists got %v", err)
+	}
+	if _, err := ts.fol.CreateFolder(bob, "INBOX"); !usecase.CheckEs(err, usecase.EsAlreadyExists) {
+		t.Errorf("system folder name expected EsAlreadyExists got %v", err)
+	}
+	team, err := ts.fol.CreateFolder(bob, "Team")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		SenderEmail: "alice@mail.com",
+		Recipients:  []string{"bob@mail.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.fol.MoveMsg(bob, mid, receipts.Idx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.fol.MoveMsg(bob, mid, usecase.EnumSent); !usecase.CheckEs(err, usecase.EsForbidden) {
+		t.Errorf("move to Sent expected EsForbidden got %v", err)
+	}
+	// The viewed facility works in user folders too
+	if err := ts.fol.UpdateViewed(bob, mid, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ts.fol.RenameFolder(bob, receipts.Idx, "Bills"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.fol.RenameFolder(bob, usecase.EnumInbox, "Stuff"); !usecase.CheckEs(err, usecase.EsForbidden) {
+		t.Errorf("rename of Inbox expected EsForbidden got %v", err)
+	}
+	if err := ts.fol.RenameFolder(bob, receipts.Idx, "team"); !usecase.CheckEs(err, usecase.EsAlreadyExists) {
+		t.Errorf("rename onto existing name expected EsAlreadyExists got %v", err)
+	}
+	out, err := ts.fol.QueryMsgs(bob, usecase.QueryParams{FolderIdx: receipts.Idx, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.FolderName != "Bills" || out.NumTotal != 1 || !out.Elems[0].IsViewed {
+		t.Errorf("unexpected query output %+v", out)
+	}
+
+	// Deleting the folder keeps the message, it lands in the Archive
+	if err := ts.fol.DeleteFolder(bob, receipts.Idx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.fol.QueryMsgs(bob, usecase.QueryParams{FolderIdx: receipts.Idx}); !usecase.CheckEs(err, usecase.EsNotFound) {
+		t.Errorf("query of deleted folder expected EsNotFound got %v", err)
+	}
+	if n := ts.count(t, bob, usecase.EnumArchive); n != 1 {
+		t.Errorf("archive count expected 1 got %d", n)
+	}
+	// Idxs aren't reused
+	again, _ := ts.fol.CreateFolder(bob, "Receipts")
+	if again.Idx == receipts.Idx || again.Idx == team.Idx {
+		t.Errorf("folder idx %d reused", again.Idx)
+	}
+}
+
+func TestDeliverWhileDeletingFolder(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com")
+	alice := ids[0]
+	entry := func(mid usecase.MsgIDType) usecase.MsgEntry {
+		return usecase.MsgEntry(*entity.NewMsgEntry(entity.Msg{Mid: entity.MsgIDType(mid)}))
+	}
+
+	// A msg added, moved or restored to a folder as it's deleted either stays where it was
+	// or is moved to the Archive with the rest, it's never left behind in the dropped folder
+	for _, tc := range []struct {
+		name  string
+		setup func(idx int, mid usecase.MsgIDType) error
+		write func(idx int, mid usecase.MsgIDType) error
+	}{
+		{
+			name:  "add",
+			setup: func(int, usecase.MsgIDType) error { return nil },
+			write: func(idx int, mid usecase.MsgIDType) error { return ts.fol.AddToFolder(idx, alice, entry(mid)) },
+		},
+		{
+			name: "move",
+			setup: func(_ int, mid usecase.MsgIDType) error {
+				return ts.fol.AddToFolder(usecase.EnumInbox, alice, entry(mid))
+			},
+			write: func(idx int, mid usecase.MsgIDType) error { return ts.fol.MoveMsg(alice, mid, idx) },
+		},
+		{
+			name: "restore",
+			setup: func(idx int, mid usecase.MsgIDType) error {
+				if err := ts.fol.AddToFolder(idx, alice, entry(mid)); err != nil {
+					return err
+				}
+				return ts.fol.DeleteMsg(alice, mid)
+			},
+			write: func(_ int, mid usecase.MsgIDType) error { return ts.fol.RestoreMsg(alice, mid) },
+		},
+	} {
+		for i := 1; i <= 50; i++ {
+			fi, err := ts.fol.CreateFolder(alice, fmt.Sprint(tc.name, i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			mid := usecase.MsgIDType(len(tc.name)*1000 + i)
+			if err := tc.setup(fi.Idx, mid); err != nil {
+				t.Fatal(err)
+			}
+			written := make(chan error)
+			go func() {
+				written <- tc.write(fi.Idx, mid)
+			}()
+			if err := ts.fol.DeleteFolder(alice, fi.Idx); err != nil {
+				t.Fatal(err)
+			}
+			err = <-written
+			if tc.name == "add" {
+				_, archived := ts.fol.RetrieveFromFolder(usecase.EnumArchive, alice, mid)
+				if (err == nil) != (archived == nil) {
+					t.Fatalf("%s msg %d err %v archived err %v", tc.name, i, err, archived)
+				}
+			} else if !ts.fol.HoldsMsg(alice, mid) {
+				t.Fatalf("%s msg %d err %v lost with the folder", tc.name, i, err)
+			}
+		}
+	}
+}
+
+func TestListFolders(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	bob := ids[1]
+
+	team, err := ts.fol.CreateFolder(bob, "Team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			SenderEmail: "alice@mail.com",
+			Recipients:  []string{"bob@mail.com"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inbox, _ := ts.fol.QueryMsgs(bob, usecase.QueryParams{FolderIdx: usecase.EnumInbox, Limit: 10})
+	ts.fol.UpdateViewed(bob, usecase.MsgIDType(inbox.Elems[0].Mid), true)
+	ts.fol.MoveMsg(bob, usecase.MsgIDType(inbox.Elems[1].Mid), team.Idx)
+
+	out, err := ts.fol.ListFolders(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.TotalNumberOfFolders != usecase.EnumNumFolders+1 {
+		t.Fatalf("expected %d folders got %d", usecase.EnumNumFolders+1, out.TotalNumberOfFolders)
+	}
+	expected := map[int][2]int{ // idx -> {total, unviewed}
+		usecase.EnumInbox:     {2, 1},
+		usecase.EnumArchive:   {0, 0},
+		usecase.EnumSent:      {0, 0},
+		usecase.EnumScheduled: {0, 0},
+		team.Idx:              {1, 1},
+	}
+	for _, info := range out.FolderInfo {
+		exp := expected[info.Idx]
+		if info.NumTotal != exp[0] || info.NumUnviewed != exp[1] {
+			t.Errorf("folder %s expected %v got {%d %d}", info.FolderName, exp, info.NumTotal, info.NumUnviewed)
+		}
+	}
+	if last := out.FolderInfo[len(out.FolderInfo)-1]; last.FolderName != "Team" || !last.IsUserFolder {
+		t.Errorf("user folder should come last %+v", last)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	alice, bob := ids[0], ids[1]
+
+	team, err := ts.fol.CreateFolder(bob, "Team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func() usecase.MsgIDType {
+		mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			SenderEmail: "alice@mail.com",
+			Recipients:  []string{"bob@mail.com"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mid
+	}
+	first, second := send(), send()
+	if err := ts.fol.MoveMsg(bob, second, team.Idx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Soft delete then restore back to where it came from
+	if err := ts.fol.DeleteMsg(bob, second); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count(t, bob, usecase.EnumTrash); n != 1 {
+		t.Fatalf("trash count expected 1 got %d", n)
+	}
+	if err := ts.fol.MoveMsg(bob, second, usecase.EnumInbox); !usecase.CheckEs(err, usecase.EsNotFound) {
+		t.Errorf("move out of the trash expected EsNotFound got %v", err)
+	}
+	if err := ts.fol.RestoreMsg(bob, second); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count(t, bob, team.Idx); n != 1 {
+		t.Errorf("restore to Team expected 1 got %d", n)
+	}
+
+	// The original folder is gone, restore falls back to the Inbox
+	ts.fol.DeleteMsg(bob, first)
+	ts.fol.MoveMsg(bob, second, usecase.EnumArchive)
+	ts.fol.DeleteMsg(bob, second)
+	if err := ts.fol.DeleteFolder(bob, team.Idx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Purge only what's past the retention
+	if n, err := ts.fol.PurgeTrash(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("purge expected 0 got %d %v", n, err)
+	}
+	if err := ts.fol.RestoreMsg(bob, first); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count(t, bob, usecase.EnumInbox); n != 1 {
+		t.Errorf("inbox count expected 1 got %d", n)
+	}
+
+	// Deleting from the Trash is for good, the message is released once alice drops her copy too
+	if err := ts.fol.DeleteMsg(bob, second); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count(t, bob, usecase.EnumTrash); n != 0 {
+		t.Errorf("trash count expected 0 got %d", n)
+	}
+	if _, err := ts.msg.RetrieveMsg(second); err != nil {
+		t.Errorf("msg still in alice's Sent released %v", err)
+	}
+	if err := ts.fol.RemoveFromFolder(usecase.EnumSent, alice, second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.msg.RetrieveMsg(second); !usecase.CheckEs(err, usecase.EsNotFound) {
+		t.Errorf("unreferenced msg expected EsNotFound got %v", err)
+	}
+
+	ts.fol.DeleteMsg(bob, first)
+	if n, err := ts.fol.PurgeTrash(time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("purge expected 1 got %d %v", n, err)
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com", "carol@mail.com")
+	bob := ids[1]
+
+	send := func(from string, to string, parent usecase.MsgIDType) usecase.MsgIDType {
+		mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			ParentMid:   entity.MsgIDType(parent),
+			SenderEmail: from,
+			Recipients:  []string{to},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mid
+	}
+	root := send("alice@mail.com", "bob@mail.com", 0)
+	send("bob@mail.com", "alice@mail.com", root)
+	fromCarol := send("carol@mail.com", "bob@mail.com", 0)
+	send("alice@mail.com", "bob@mail.com", 0)
+	ts.fol.UpdateViewed(bob, root, true)
+	ts.fol.UpdateStarred(bob, fromCarol, true)
+
+	query := func(qp usecase.QueryParams) *usecase.MsgQueryOutput {
+		qp.FolderIdx = usecase.EnumInbox
+		qp.Limit = 1
+		out, err := ts.fol.QueryMsgs(bob, qp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	if out := query(usecase.QueryParams{UnreadOnly: true}); out.NumTotal != 2 || out.NumElems != 1 || out.NumUnviewed != 2 {
+		t.Errorf("unread expected 2 total 1 elem got %d %d", out.NumTotal, out.NumElems)
+	}
+	if out := query(usecase.QueryParams{StarredOnly: true}); out.NumTotal != 1 || out.Elems[0].Mid != entity.MsgIDType(fromCarol) {
+		t.Errorf("starred expected carol's msg got %+v", out.Elems)
+	}
+	if out := query(usecase.QueryParams{SenderEmail: "Alice@Mail.com"}); out.NumTotal != 2 {
+		t.Errorf("sender expected 2 got %d", out.NumTotal)
+	}
+	if out := query(usecase.QueryParams{HasThread: true}); out.NumTotal != 1 || out.Elems[0].Mid != entity.MsgIDType(root) {
+		t.Errorf("hasthread expected the root got %+v", out.Elems)
+	}
+	if out := query(usecase.QueryParams{Before: time.Now().Add(-time.Hour)}); out.NumTotal != 0 {
+		t.Errorf("before expected 0 got %d", out.NumTotal)
+	}
+	if out := query(usecase.QueryParams{After: time.Now().Add(-time.Hour)}); out.NumTotal != 3 {
+		t.Errorf("after expected 3 got %d", out.NumTotal)
+	}
+	now := time.Now()
+	_, err := ts.fol.QueryMsgs(bob, usecase.QueryParams{After: now, Before: now, Limit: 1})
+	if !usecase.CheckEs(err, usecase.EsArgInvalid) {
+		t.Errorf("empty date range expected EsArgInvalid got %v", err)
+	}
+}
+
+func TestQueryCursor(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	bob := ids[1]
+
+	send := func() {
+		if _, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			SenderEmail: "alice@mail.com",
+			Recipients:  []string{"bob@mail.com"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		send()
+	}
+
+	// Newest first, new mail arriving between pages doesn't shift the rest
+	seen := map[entity.MsgIDType]bool{}
+	qp := usecase.QueryParams{FolderIdx: usecase.EnumInbox, SortOrder: 0, Limit: 2}
+	for pages := 0; ; pages++ {
+		out, err := ts.fol.QueryMsgs(bob, qp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range out.Elems {
+			if seen[e.Mid] {
+				t.Errorf("msg %d returned twice", e.Mid)
+			}
+			seen[e.Mid] = true
+		}
+		if out.NextCursor == "" {
+			break
+		}
+		if pages == 0 {
+			send()
+		}
+		qp.Cursor = out.NextCursor
+	}
+	if len(seen) != 5 {
+		t.Errorf("expected the 5 original msgs got %d", len(seen))
+	}
+
+	qp.SortBy = usecase.EnumSortBySender
+	if _, err := ts.fol.QueryMsgs(bob, qp); !usecase.CheckEs(err, usecase.EsArgInvalid) {
+		t.Errorf("cursor with a different sort expected EsArgInvalid got %v", err)
+	}
+	qp.Cursor = "not a cursor"
+	if _, err := ts.fol.QueryMsgs(bob, qp); !usecase.CheckEs(err, usecase.EsArgInvalid) {
+		t.Errorf("bad cursor expected EsArgInvalid got %v", err)
+	}
+}
+
+func TestQuerySort(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	bob := ids[1]
+	for _, subject := range []string{"b", "c", "a"} {
+		if _, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			SenderEmail: "alice@mail.com",
+			Recipients:  []string{"bob@mail.com"},
+			Subject:     subject,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subjects := func(qp usecase.QueryParams) string {
+		qp.FolderIdx = usecase.EnumInbox
+		qp.SortBy = usecase.EnumSortBySubject
+		out, err := ts.fol.QueryMsgs(bob, qp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.NumTotal != 3 {
+			t.Errorf("expected 3 total got %d", out.NumTotal)
+		}
+		ret := ""
+		for _, e := range out.Elems {
+			ret += e.M.M.Subject
+		}
+		return ret
+	}
+	if got := subjects(usecase.QueryParams{SortOrder: 1, Limit: 10}); got != "abc" {
+		t.Errorf("ascending expected abc got %s", got)
+	}
+	if got := subjects(usecase.QueryParams{SortOrder: 0, Limit: 10}); got != "cba" {
+		t.Errorf("descending expected cba got %s", got)
+	}
+	if got := subjects(usecase.QueryParams{SortOrder: 1, Limit: 2, Page: 1}); got != "c" {
+		t.Errorf("second page expected c got %s", got)
+	}
+}
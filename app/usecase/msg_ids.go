@@ -0,0 +1,190 @@
+package usecase
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/domain/repo"
+)
+
+// memIDs counters from 1, for repos that don't outlive the process
+type memIDs struct {
+	lastMsgID    uint64
+	lastThreadID uint64
+}
+
+// NewMemIDGenerator counts from 1 every time it's created
+func NewMemIDGenerator() IDGenerator {
+	return &memIDs{}
+}
+
+func (g *memIDs) NewMsgID() (MsgIDType, error) {
+	return MsgIDType(atomic.AddUint64(&g.lastMsgID, 1)), nil
+}
+
+func (g *memIDs) NewThreadID() (ThreadIDType, error) {
+	return ThreadIDType(atomic.AddUint64(&g.lastThreadID, 1)), nil
+}
+
+// The keys of the high-water marks
+const (
+	msgIDMark    repo.GenericKeyT = 1
+	threadIDMark repo.GenericKeyT = 2
+)
+
+// DefaultIDBlock how many ids the persisted generator reserves with each write of a mark
+const DefaultIDBlock = 1000
+
+// persistedIDs counters that carry on across restarts. The mark stored for each is the
+// end of the block of ids reserved, it's written before any id of the block is handed
+// out, so after a restart the counting starts past anything that could have been used.
+type persistedIDs struct {
+	mtx      *sync.Mutex
+	marks    repo.Store[repo.GenericKeyT, uint64]
+	block    uint64
+	next     [3]uint64 // by mark key, the next id to hand out
+	reserved [3]uint64 // and the stored mark
+}
+
+// NewPersistedIDGenerator counters kept in marks, block ids are reserved at a time. seed
+// gives the highest ids in use when there are no marks yet, a db from before they were
+// kept, it can be nil.
+func NewPersistedIDGenerator(marks repo.Store[repo.GenericKeyT, uint64], block uint64,
+	seed func() (MsgIDType, ThreadIDType, error)) (IDGenerator, error) {
+	if block == 0 {
+		return nil, NewEs(EsArgInvalid, "id block of 0")
+	}
+	g := &persistedIDs{mtx: &sync.Mutex{}, marks: marks, block: block}
+	seeded := false
+	for _, key := range []repo.GenericKeyT{msgIDMark, threadIDMark} {
+		mark, err := marks.Retrieve(key)
+		if CheckEs(err, EsNotFound) {
+			if seed != nil && !seeded {
+				mid, tid, err := seed()
+				if err != nil {
+					return nil, err
+				}
+				g.reserved[msgIDMark], g.reserved[threadIDMark] = uint64(mid), uint64(tid)
+				seeded = true
+			}
+			if err := marks.Create(key, g.reserved[key]); err != nil {
+				return nil, err
+			}
+			mark = g.reserved[key]
+		} else if err != nil {
+			return nil, err
+		}
+		g.reserved[key] = mark
+		g.next[key] = mark + 1
+	}
+	return g, nil
+}
+
+// newID the next id of the key, reserving another block when the last one's used up
+func (g *persistedIDs) newID(key repo.GenericKeyT) (uint64, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.next[key] > g.reserved[key] {
+		mark := g.reserved[key] + g.block
+		if err := g.marks.Update(key, mark); err != nil {
+			return 0, err
+		}
+		g.reserved[key] = mark
+	}
+	id := g.next[key]
+	g.next[key]++
+	return id, nil
+}
+
+func (g *persistedIDs) NewMsgID() (MsgIDType, error) {
+	id, err := g.newID(msgIDMark)
+	return MsgIDType(id), err
+}
+
+func (g *persistedIDs) NewThreadID() (ThreadIDType, error) {
+	id, err := g.newID(threadIDMark)
+	return ThreadIDType(id), err
+}
+
+// StoredIDs the seed for NewPersistedIDGenerator, the highest ids of the stored msgs and of
+// the folder entries (drafts are only kept in the folders)
+func StoredIDs(dbMsg repo.Store[entity.MsgIDType, entity.Msg], folUsecase FoldersUsecase) func() (MsgIDType, ThreadIDType, error) {
+	return func() (MsgIDType, ThreadIDType, error) {
+		var mid MsgIDType
+		var tid ThreadIDType
+		see := func(m entity.Msg) {
+			if MsgIDType(m.Mid) > mid {
+				mid = MsgIDType(m.Mid)
+			}
+			if ThreadIDType(m.Tid) > tid {
+				tid = ThreadIDType(m.Tid)
+			}
+		}
+		msgs, err := dbMsg.RetrieveAll()
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, m := range msgs {
+			see(m)
+		}
+		err = folUsecase.ForEachInAllAccounts(func(id AccountIDType, folderEnum int, e MsgEntry) {
+			see(e.M)
+		})
+		return mid, tid, err
+	}
+}
+
+// Layout of the time ordered ids, they're kept to 53 bits so they survive as JSON numbers
+// in javascript: 41 bits of milliseconds since idEpoch (till 2089), 4 bits of node and 8
+// of sequence within the millisecond
+const (
+	idNodeBits = 4
+	idSeqBits  = 8
+	MaxIDNode  = 1<<idNodeBits - 1
+)
+
+var idEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// timeIDs ids that sort by when they were made. Msgs and threads share the sequence.
+type timeIDs struct {
+	mtx   *sync.Mutex
+	clock Clock
+	node  uint64
+	last  uint64 // milliseconds of the last id
+	seq   uint64
+}
+
+// NewTimeIDGenerator time ordered ids, node tells apart the servers sharing a db
+func NewTimeIDGenerator(clock Clock, node uint64) (IDGenerator, error) {
+	if node > MaxIDNode {
+		return nil, NewEs(EsArgInvalid, "id node over 15")
+	}
+	return &timeIDs{mtx: &sync.Mutex{}, clock: clock, node: node}, nil
+}
+
+func (g *timeIDs) newID() uint64 {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	ms := uint64(g.clock.Now().Sub(idEpoch) / time.Millisecond)
+	switch {
+	case ms > g.last:
+		g.last, g.seq = ms, 0
+	case g.seq < 1<<idSeqBits-1:
+		// Same millisecond, or the clock went back and the ids carry on from the last one
+		g.seq++
+	default:
+		// Sequence used up, borrow the next millisecond
+		g.last, g.seq = g.last+1, 0
+	}
+	return g.last<<(idNodeBits+idSeqBits) | g.node<<idSeqBits | g.seq
+}
+
+func (g *timeIDs) NewMsgID() (MsgIDType, error) {
+	return MsgIDType(g.newID()), nil
+}
+
+func (g *timeIDs) NewThreadID() (ThreadIDType, error) {
+	return ThreadIDType(g.newID()), nil
+}
@@ -2,7 +2,9 @@ package usecase
 
 import (
 	"fmt"
-	"sync/atomic"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/git-sim/tc/app/domain/entity"
@@ -11,32 +13,35 @@ import (
 )
 
 type msgUsecase struct {
-	dbMsg      repo.Generic
-	dbPending  repo.Generic
+	mtx        *sync.Mutex // serializes dispatch against cancel/reschedule of scheduled messages
+	threadMtx  *sync.Mutex // guards the read-modify-write of the thread index entries
+	dbMsg      repo.Store[entity.MsgIDType, entity.Msg]
+	dbPending  repo.Store[PendingKeyType, entity.PendingMsgEntry]
+	dbThreads  repo.Store[entity.ThreadIDType, []entity.MsgIDType] // index map[Tid][]Mid, the mids kept in ascending order
 	folUsecase FoldersUsecase
+	rules      RulesUsecase
 	service    *service.AccountService
+	sched      Scheduler
+	ids        IDGenerator
 }
 
-// Msg and Thread Id helpers
-var lastMsgID uint64
-var lastThreadID uint64
-
-// Returns a new unique id, the implementation is a detail
-func getNewMsgID() MsgIDType {
-	return MsgIDType(atomic.AddUint64(&lastMsgID, 1))
-}
-
-func getNewThreadID() ThreadIDType {
-	return ThreadIDType(atomic.AddUint64(&lastThreadID, 1))
-}
-
-// NewMsgUsecase news usecase
-func NewMsgUsecase(dbMsg repo.Generic, dbPending repo.Generic, folUsecase FoldersUsecase, service *service.AccountService) MsgUsecase {
+// NewMsgUsecase news usecase, ids hands out the msg and thread ids
+func NewMsgUsecase(dbMsg repo.Store[entity.MsgIDType, entity.Msg],
+	dbPending repo.Store[PendingKeyType, entity.PendingMsgEntry],
+	dbThreads repo.Store[entity.ThreadIDType, []entity.MsgIDType],
+	folUsecase FoldersUsecase, rules RulesUsecase, service *service.AccountService, sched Scheduler,
+	ids IDGenerator) MsgUsecase {
 	return &msgUsecase{
+		mtx:        &sync.Mutex{},
+		threadMtx:  &sync.Mutex{},
 		dbMsg:      dbMsg,
 		dbPending:  dbPending,
+		dbThreads:  dbThreads,
 		folUsecase: folUsecase,
+		rules:      rules,
 		service:    service,
+		sched:      sched,
+		ids:        ids,
 	}
 }
 
@@ -95,6 +100,16 @@ func (u *msgUsecase) IsValid(msg *IngressMsg) (bool, error) {
 
 // EnqueueMsg adds the message to the system for scheduling/delivery
 func (u *msgUsecase) EnqueueMsg(msg *IngressMsg) (MsgIDType, error) {
+	return u.enqueue(msg, false)
+}
+
+// EnqueueAutoMsg same as EnqueueMsg with the message marked Auto
+func (u *msgUsecase) EnqueueAutoMsg(msg *IngressMsg) (MsgIDType, error) {
+	return u.enqueue(msg, true)
+}
+
+// enqueue auto marks messages the system sends on the user's behalf
+func (u *msgUsecase) enqueue(msg *IngressMsg, auto bool) (MsgIDType, error) {
 	// Sanity check
 	if ok, err := u.IsValid(msg); !ok {
 		return 0, err
@@ -102,16 +117,8 @@ func (u *msgUsecase) EnqueueMsg(msg *IngressMsg) (MsgIDType, error) {
 
 	// Prepare the message struct adding meta data as needed
 	//
-	newmsg := entity.Msg{M: entity.MsgBase(*msg)}
-
-	//Validate or Assign ThreadId
-	if msg.ParentMid == 0 {
-		newmsg.Tid = entity.ThreadIDType(getNewThreadID())
-	} else {
-		//todo find the parent message, and assign it's thread id
+	newmsg := entity.Msg{M: entity.MsgBase(*msg), Auto: auto}
 
-	}
-	//Validate ParentMsgId //todo thread handling
 	//Fill in SenderID
 	if senderID, err := u.service.GetIDFromEmail(msg.SenderEmail); err == nil {
 		newmsg.SenderID = senderID
@@ -120,18 +127,44 @@ func (u *msgUsecase) EnqueueMsg(msg *IngressMsg) (MsgIDType, error) {
 		return 0, NewEs(EsNotFound, "Sender Account ID")
 	}
 
+	//Validate or Assign ThreadId
+	if msg.ParentMid == 0 {
+		tid, err := u.ids.NewThreadID()
+		if err != nil {
+			return 0, err
+		}
+		newmsg.Tid = entity.ThreadIDType(tid)
+	} else {
+		// A reply joins the parent's thread
+		parent, err := u.retrieveEntityMsg(MsgIDType(msg.ParentMid))
+		if err != nil {
+			return 0, NewEs(EsNotFound,
+				fmt.Sprintf("Parent message with id %d", msg.ParentMid))
+		}
+		if !u.canSee(parent, AccountIDType(newmsg.SenderID)) {
+			// Don't leak the existence of messages the sender was never part of
+			return 0, NewEs(EsNotFound,
+				fmt.Sprintf("Parent message with id %d", msg.ParentMid))
+		}
+		newmsg.Tid = parent.Tid
+	}
+
 	// Assign new MsgId and Store the Message
 	//
-	newid := getNewMsgID()
+	newid, err := u.ids.NewMsgID()
+	if err != nil {
+		return 0, err
+	}
 	newmsg.Mid = entity.MsgIDType(newid)
-	newmsg.M.CreatedAt = time.Now()
+	now := u.sched.Clock().Now()
+	newmsg.M.CreatedAt = now
 
 	// Handle the scheduling and dispatch if needed
 	//
 	//Check if Scheduled for future delivery
-	//if so add to sender's Scheduled folder and Add timer
-	if msg.ScheduledAt.After(time.Now().Add(time.Second * 10)) {
-		//Put the message in the scheduled folder, notify a timer
+	//if so add to sender's Scheduled folder and hand it to the scheduler
+	if msg.ScheduledAt.After(now.Add(time.Second * 10)) {
+		//Put the message in the scheduled folder, the scheduler dispatches it when due
 		pMsgEntry := entity.NewMsgEntry(newmsg)
 		err := u.folUsecase.AddToFolder(EnumScheduled,
 			AccountIDType(newmsg.SenderID), MsgEntry(*pMsgEntry))
@@ -140,67 +173,450 @@ func (u *msgUsecase) EnqueueMsg(msg *IngressMsg) (MsgIDType, error) {
 				fmt.Sprintf("Couldn't add to scheduled folder sender %d, %s",
 					newmsg.SenderID, err.Error()))
 		}
-		if err := u.dbMsg.Create(repo.GenericKeyT(newmsg.Mid), newmsg); err != nil {
+		if err := u.dbMsg.Create(newmsg.Mid, newmsg); err != nil {
 			return 0, err
 		}
+		if err := u.addToThread(newmsg.Tid, newmsg.Mid); err != nil {
+			return newid, err
+		}
+		u.sched.Schedule(newid, msg.ScheduledAt)
 
 	} else {
 		//else assign SentAt and Dispatch the message to recipients
-		newmsg.SentAt = time.Now()
-		if err := u.dbMsg.Create(repo.GenericKeyT(newmsg.Mid), newmsg); err != nil {
+		newmsg.SentAt = now
+		if err := u.dbMsg.Create(newmsg.Mid, newmsg); err != nil {
 			return 0, err
 		}
+		if err := u.addToThread(newmsg.Tid, newmsg.Mid); err != nil {
+			return newid, err
+		}
+		if err := u.deliver(newmsg); err != nil {
+			return newid, err
+		}
+	}
+	return newid, nil
+}
 
-		// Todo move the dispatch to it's own folder
-		// Add to Sent folder
-		pMsgEntry := entity.NewMsgEntry(newmsg)
-		err := u.folUsecase.AddToFolder(EnumSent, AccountIDType(newmsg.SenderID), MsgEntry(*pMsgEntry))
+// DispatchScheduled called by the scheduler when a message is due. Stamps SentAt, moves it
+// from the sender's Scheduled folder to Sent and fans it out to the recipients
+func (u *msgUsecase) DispatchScheduled(mid MsgIDType, now time.Time) error {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	msg, err := u.retrieveEntityMsg(mid)
+	if err != nil {
+		return err
+	}
+	if !msg.SentAt.IsZero() {
+		// A retry of a dispatch that failed part way finds it still in the Scheduled folder,
+		// the deliveries are made again over what's there
+		_, err := u.folUsecase.RetrieveFromFolder(EnumScheduled, AccountIDType(msg.SenderID), mid)
 		if err != nil {
-			return newid, NewEs(EsInternalError,
-				fmt.Sprintf("%s", err.Error()))
-		}
-		// Dispatch to recipients
-		for _, recip := range newmsg.M.Recipients {
-			recipID, err := u.service.GetIDFromEmail(recip)
-			if err == nil {
-				// Recipient is in the system send message
-				err = u.folUsecase.AddToFolder(EnumInbox, AccountIDType(recipID), MsgEntry(*pMsgEntry))
-				if err != nil {
-					return newid, NewEs(EsInternalError,
-						fmt.Sprintf("%s", err.Error()))
-				}
-			} else {
-				// Recipient isn't in the system, add the message to the pending queue
-				// Going to create a copy for each recipient...trading off space for complexity
-				// Store the msg using the GUID with the email + mid.
-				// The key for dbPending doesn't matter just needs to be unique for every pair {message,recipient}.
-				// the dbPending is being used as a set, so the id just needs to be unique it doens't need to identify a specific message
-				midstr := MsgIDToString(MsgIDType(newmsg.Mid))
-				dbguid := GetUID(recip + midstr)
-				pNewPendMsg := entity.NewPendingMsgEntry(*pMsgEntry, recip)
-				err = u.dbPending.Create(repo.GenericKeyT(dbguid), *pNewPendMsg)
-				if err != nil {
-					return newid, NewEs(EsInternalError,
-						fmt.Sprintf("%s", err.Error()))
-				}
-			}
+			return NewEs(EsAlreadyReported,
+				fmt.Sprintf("Message with id %d already dispatched", mid))
+		}
+	} else {
+		if msg.M.ScheduledAt.After(now) {
+			// Rescheduled while the scheduler was handing it over, put it back
+			u.sched.Schedule(mid, msg.M.ScheduledAt)
+			return nil
+		}
+		msg.SentAt = now
+		if err := u.dbMsg.Update(msg.Mid, *msg); err != nil {
+			return err
 		}
 	}
-	return newid, nil
+	// Deliver first so the message is never without a folder referencing it
+	if err := u.deliver(*msg); err != nil {
+		return err
+	}
+	err = u.folUsecase.RemoveFromFolder(EnumScheduled, AccountIDType(msg.SenderID), mid)
+	if err != nil && !CheckEs(err, EsNotFound) {
+		return err
+	}
+	return nil
 }
 
-// RetrieveMsg gets the specified message from the message store
-func (u *msgUsecase) RetrieveMsg(mid MsgIDType) (*EgressMsg, error) {
-	val, err := u.dbMsg.Retrieve(repo.GenericKeyT(mid))
+// ReleaseMsg drops a sent message from the system once no folder and no pending
+// delivery refers to it. Scheduled messages are left to the scheduler.
+// Called from the folders' removed notification which may run under u.mtx, so it doesn't lock it
+func (u *msgUsecase) ReleaseMsg(mid MsgIDType) error {
+	msg, err := u.retrieveEntityMsg(mid)
+	if err != nil {
+		return err
+	}
+	if msg.SentAt.IsZero() || u.folUsecase.IsReferenced(mid) {
+		return nil
+	}
+	pending, err := u.dbPending.RetrieveFiltered(func(pend entity.PendingMsgEntry) bool {
+		return pend.E.Mid == msg.Mid
+	})
+	if err != nil || len(pending) > 0 {
+		return err
+	}
+	if err := u.dbMsg.Delete(msg.Mid); err != nil {
+		return err
+	}
+	return u.removeFromThread(msg.Tid, msg.Mid)
+}
+
+// CancelScheduled takes the message out of the scheduler and the sender's Scheduled folder.
+// The message is removed from the system, kept as a draft under the same id and handed back as an editable IngressMsg
+func (u *msgUsecase) CancelScheduled(id AccountIDType, mid MsgIDType) (*IngressMsg, error) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	msg, err := u.retrieveScheduled(id, mid)
+	if err != nil {
+		return nil, err
+	}
+
+	u.sched.Cancel(mid)
+	err = u.folUsecase.RemoveFromFolder(EnumScheduled, id, mid)
+	if err != nil && !CheckEs(err, EsNotFound) {
+		return nil, err
+	}
+	if err := u.dbMsg.Delete(entity.MsgIDType(mid)); err != nil {
+		return nil, err
+	}
+	if err := u.removeFromThread(msg.Tid, msg.Mid); err != nil {
+		return nil, err
+	}
+
+	draft := IngressMsg(msg.M)
+	if err := u.saveDraft(id, mid, &draft); err != nil {
+		return nil, err
+	}
+	return &draft, nil
+}
+
+// RescheduleMsg moves the delivery time of a message that hasn't been dispatched yet
+func (u *msgUsecase) RescheduleMsg(id AccountIDType, mid MsgIDType, at time.Time) error {
+	if at.IsZero() {
+		return NewEs(EsArgInvalid, "ScheduledAt")
+	}
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	msg, err := u.retrieveScheduled(id, mid)
+	if err != nil {
+		return err
+	}
+
+	msg.M.ScheduledAt = at
+	if err := u.dbMsg.Update(entity.MsgIDType(mid), *msg); err != nil {
+		return err
+	}
+	// The Scheduled folder keeps a copy of the msg, refresh it
+	err = u.folUsecase.RemoveFromFolder(EnumScheduled, id, mid)
+	if err != nil && !CheckEs(err, EsNotFound) {
+		return err
+	}
+	if err := u.folUsecase.AddToFolder(EnumScheduled, id, MsgEntry(*entity.NewMsgEntry(*msg))); err != nil {
+		return err
+	}
+	u.sched.Schedule(mid, at)
+	return nil
+}
+
+// RetrieveThread gets all the messages in the thread, oldest first
+func (u *msgUsecase) RetrieveThread(tid ThreadIDType) ([]*EgressMsg, error) {
+	mids, err := u.threadMids(entity.ThreadIDType(tid))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*EgressMsg, 0, len(mids))
+	for _, mid := range mids {
+		msg, err := u.retrieveEntityMsg(MsgIDType(mid))
+		if err != nil {
+			continue // removed after the index was read
+		}
+		emsg := EgressMsg(*msg)
+		out = append(out, &emsg)
+	}
+	return out, nil
+}
+
+// canSee the sender and the accounts it was delivered to are the only ones that saw it.
+// It goes by account id, not email, so it follows a change of address and doesn't pass
+// to whoever takes the old one
+func (u *msgUsecase) canSee(msg *entity.Msg, id AccountIDType) bool {
+	if AccountIDType(msg.SenderID) == id {
+		return true
+	}
+	if msg.SentAt.IsZero() {
+		return false
+	}
+	return u.folUsecase.HoldsMsg(id, MsgIDType(msg.Mid))
+}
+
+// Thread index helpers, the entries are map[Tid][]Mid so a thread can be read without scanning dbMsg
+func (u *msgUsecase) threadMids(tid entity.ThreadIDType) ([]entity.MsgIDType, error) {
+	mids, err := u.dbThreads.Retrieve(tid)
+	if err != nil {
+		return nil, NewEs(EsNotFound,
+			fmt.Sprintf("Thread with id %d", tid))
+	}
+	return mids, nil
+}
+
+func (u *msgUsecase) addToThread(tid entity.ThreadIDType, mid entity.MsgIDType) error {
+	u.threadMtx.Lock()
+	defer u.threadMtx.Unlock()
+
+	mids, err := u.threadMids(tid)
+	if err != nil {
+		return u.dbThreads.Create(tid, []entity.MsgIDType{mid})
+	}
+	// Copy on write, readers may hold the old slice
+	idx := sort.Search(len(mids), func(i int) bool { return mids[i] >= mid })
+	if idx < len(mids) && mids[idx] == mid {
+		return nil
+	}
+	newMids := make([]entity.MsgIDType, 0, len(mids)+1)
+	newMids = append(newMids, mids[:idx]...)
+	newMids = append(newMids, mid)
+	newMids = append(newMids, mids[idx:]...)
+	return u.dbThreads.Update(tid, newMids)
+}
+
+func (u *msgUsecase) removeFromThread(tid entity.ThreadIDType, mid entity.MsgIDType) error {
+	u.threadMtx.Lock()
+	defer u.threadMtx.Unlock()
+
+	mids, err := u.threadMids(tid)
+	if err != nil {
+		return nil // nothing to remove
+	}
+	newMids := make([]entity.MsgIDType, 0, len(mids))
+	for _, m := range mids {
+		if m != mid {
+			newMids = append(newMids, m)
+		}
+	}
+	if len(newMids) == 0 {
+		return u.dbThreads.Delete(tid)
+	}
+	return u.dbThreads.Update(tid, newMids)
+}
+
+// retrieveScheduled gets a message owned by id that is still waiting to be dispatched.
+// EsNotFound if it isn't the sender's message, EsForbidden once it has been sent
+func (u *msgUsecase) retrieveScheduled(id AccountIDType, mid MsgIDType) (*entity.Msg, error) {
+	msg, err := u.retrieveEntityMsg(mid)
+	if err != nil {
+		return nil, err
+	}
+	if AccountIDType(msg.SenderID) != id {
+		return nil, NewEs(EsNotFound,
+			fmt.Sprintf("Scheduled message with id %d", mid))
+	}
+	if !msg.SentAt.IsZero() {
+		return nil, NewEs(EsForbidden,
+			fmt.Sprintf("Message with id %d already dispatched", mid))
+	}
+	return msg, nil
+}
+
+func (u *msgUsecase) retrieveEntityMsg(mid MsgIDType) (*entity.Msg, error) {
+	msg, err := u.dbMsg.Retrieve(entity.MsgIDType(mid))
 	if err != nil {
 		return nil, NewEs(EsNotFound,
 			fmt.Sprintf("Message with id %d", mid))
 	}
-	valAsEnt, ok := val.(entity.Msg) //Type assert first
-	if !ok {
-		return nil, NewEs(EsArgConvFail, "Repository to entity.Msg")
+	return &msg, nil
+}
+
+// deliver adds a sent message to the sender's Sent folder and to each recipient's inbox,
+// or the pending repo for recipients that haven't registered yet
+func (u *msgUsecase) deliver(newmsg entity.Msg) error {
+	// Add to Sent folder
+	pMsgEntry := entity.NewMsgEntry(newmsg)
+	err := u.folUsecase.AddToFolder(EnumSent, AccountIDType(newmsg.SenderID), MsgEntry(*pMsgEntry))
+	if err != nil {
+		return NewEs(EsInternalError,
+			fmt.Sprintf("%s", err.Error()))
+	}
+	// Dispatch to recipients
+	for _, recip := range newmsg.M.Recipients {
+		recipID, err := u.service.GetIDFromEmail(recip)
+		if err == nil {
+			// Recipient is in the system send message, their rules decide where it lands
+			err = u.rules.DeliverToInbox(AccountIDType(recipID), MsgEntry(*pMsgEntry))
+			if err != nil {
+				return NewEs(EsInternalError,
+					fmt.Sprintf("%s", err.Error()))
+			}
+		} else {
+			// Recipient isn't in the system, add the message to the pending queue
+			// Going to create a copy for each recipient...trading off space for complexity
+			// Store the msg using the GUID with the email + mid.
+			// The key for dbPending doesn't matter just needs to be unique for every pair {message,recipient}.
+			// the dbPending is being used as a set, so the id just needs to be unique it doens't need to identify a specific message
+			pNewPendMsg := entity.NewPendingMsgEntry(*pMsgEntry, recip)
+			err = u.dbPending.Create(pendingKey(recip, MsgIDType(newmsg.Mid)), *pNewPendMsg)
+			if err != nil {
+				return NewEs(EsInternalError,
+					fmt.Sprintf("%s", err.Error()))
+			}
+		}
 	}
+	return nil
+}
 
-	emsg := EgressMsg(valAsEnt) //convert to outgoing type
+// pendingKey the GUID of the recipient's email + mid
+func pendingKey(recip string, mid MsgIDType) PendingKeyType {
+	return PendingKeyType(GetUID(recip + MsgIDToString(mid)))
+}
+
+// RetrieveMsg gets the specified message from the message store
+func (u *msgUsecase) RetrieveMsg(mid MsgIDType) (*EgressMsg, error) {
+	valAsEnt, err := u.retrieveEntityMsg(mid)
+	if err != nil {
+		return nil, err
+	}
+
+	emsg := EgressMsg(*valAsEnt) //convert to outgoing type
 	return &emsg, nil
 }
+
+// CreateDraft saves a partial message, only the owner is checked
+func (u *msgUsecase) CreateDraft(id AccountIDType, msg *IngressMsg) (MsgIDType, error) {
+	if !u.service.AlreadyExistsByID(entity.AccountIDType(id)) {
+		return 0, NewEs(EsNotFound, fmt.Sprintf("Account with id %s", AccountIDToString(id)))
+	}
+	draft := *msg
+	draft.CreatedAt = u.sched.Clock().Now()
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	mid, err := u.ids.NewMsgID()
+	if err != nil {
+		return 0, err
+	}
+	if err := u.saveDraft(id, mid, &draft); err != nil {
+		return 0, err
+	}
+	return mid, nil
+}
+
+// UpdateDraft replaces the content of the draft, it keeps its id and CreatedAt
+func (u *msgUsecase) UpdateDraft(id AccountIDType, mid MsgIDType, msg *IngressMsg) error {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	old, err := u.folUsecase.RetrieveFromFolder(EnumDrafts, id, mid)
+	if err != nil {
+		return err
+	}
+	draft := *msg
+	draft.CreatedAt = old.M.M.CreatedAt
+
+	// The Drafts folder keeps a copy of the msg, refresh it
+	err = u.folUsecase.RemoveFromFolder(EnumDrafts, id, mid)
+	if err != nil && !CheckEs(err, EsNotFound) {
+		return err
+	}
+	return u.saveDraft(id, mid, &draft)
+}
+
+func (u *msgUsecase) RetrieveDraft(id AccountIDType, mid MsgIDType) (*EgressMsg, error) {
+	entry, err := u.folUsecase.RetrieveFromFolder(EnumDrafts, id, mid)
+	if err != nil {
+		return nil, err
+	}
+	draft := EgressMsg(entry.M)
+	return &draft, nil
+}
+
+func (u *msgUsecase) ListDrafts(id AccountIDType) ([]*EgressMsg, error) {
+	drafts, err := u.folUsecase.RetrieveFolder(EnumDrafts, id)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*EgressMsg, len(drafts))
+	for i, msg := range drafts {
+		draft := EgressMsg(msg.M)
+		out[i] = &draft
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].M.CreatedAt.Equal(out[j].M.CreatedAt) {
+			return out[i].Mid > out[j].Mid
+		}
+		return out[i].M.CreatedAt.After(out[j].M.CreatedAt)
+	})
+	return out, nil
+}
+
+func (u *msgUsecase) DeleteDraft(id AccountIDType, mid MsgIDType) error {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	return u.folUsecase.RemoveFromFolder(EnumDrafts, id, mid)
+}
+
+// SendDraft the draft has to be from the owner's address, a failed send leaves the draft as is
+func (u *msgUsecase) SendDraft(id AccountIDType, mid MsgIDType) (MsgIDType, error) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	entry, err := u.folUsecase.RetrieveFromFolder(EnumDrafts, id, mid)
+	if err != nil {
+		return 0, err
+	}
+	msg := IngressMsg(entry.M.M)
+	if ok, err := u.IsValid(&msg); !ok {
+		return 0, err
+	}
+	if senderID, err := u.service.GetIDFromEmail(msg.SenderEmail); err != nil || AccountIDType(senderID) != id {
+		return 0, NewEs(EsForbidden, "SenderEmail isn't the draft owner's")
+	}
+
+	newid, err := u.EnqueueMsg(&msg)
+	if err != nil {
+		return 0, err
+	}
+	err = u.folUsecase.RemoveFromFolder(EnumDrafts, id, mid)
+	if err != nil && !CheckEs(err, EsNotFound) {
+		return newid, err
+	}
+	return newid, nil
+}
+
+// saveDraft stores the draft in the owner's Drafts folder, call holding u.mtx
+func (u *msgUsecase) saveDraft(id AccountIDType, mid MsgIDType, draft *IngressMsg) error {
+	msg := entity.Msg{
+		Mid:      entity.MsgIDType(mid),
+		SenderID: entity.AccountIDType(id),
+		M:        entity.MsgBase(*draft),
+	}
+	return u.folUsecase.AddToFolder(EnumDrafts, id, MsgEntry(*entity.NewMsgEntry(msg)))
+}
+
+// ForwardMsg the account has to be the sender or a recipient of the message
+func (u *msgUsecase) ForwardMsg(id AccountIDType, mid MsgIDType, to []string, auto bool) (MsgIDType, error) {
+	email, err := u.service.GetEmailFromID(entity.AccountIDType(id))
+	if err != nil {
+		return 0, NewEs(EsNotFound, fmt.Sprintf("Account with id %s", AccountIDToString(id)))
+	}
+	orig, err := u.retrieveEntityMsg(mid)
+	if err != nil || !u.canSee(orig, id) {
+		return 0, NewEs(EsNotFound,
+			fmt.Sprintf("Message with id %d", mid))
+	}
+
+	subject := orig.M.Subject
+	if !strings.HasPrefix(strings.ToLower(subject), "fwd:") {
+		subject = "Fwd: " + subject
+	}
+	header := fmt.Sprintf("---------- Forwarded message ----------\nFrom: %s\nSubject: %s\n\n",
+		orig.M.SenderEmail, orig.M.Subject)
+	fwd := &IngressMsg{
+		SenderEmail: email,
+		Recipients:  to,
+		Subject:     subject,
+		Body:        append([]byte(header), orig.M.Body...),
+	}
+	return u.enqueue(fwd, auto)
+}
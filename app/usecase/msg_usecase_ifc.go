@@ -3,6 +3,7 @@ package usecase
 import (
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/git-sim/tc/app/domain/entity"
 )
@@ -17,6 +18,36 @@ type MsgUsecase interface {
 
 	//Get a message from the msg store
 	RetrieveMsg(mid MsgIDType) (*EgressMsg, error)
+
+	// Get all the messages in a thread ordered oldest first
+	RetrieveThread(tid ThreadIDType) ([]*EgressMsg, error)
+
+	// Delivers a scheduled message once it's due, meets the DispatchFunc signature
+	DispatchScheduled(mid MsgIDType, now time.Time) error
+
+	// Pulls a message back out of the sender's Scheduled folder, returns its content for editing
+	CancelScheduled(id AccountIDType, mid MsgIDType) (*IngressMsg, error)
+	// Changes the ScheduledAt of a message still waiting in the sender's Scheduled folder
+	RescheduleMsg(id AccountIDType, mid MsgIDType, at time.Time) error
+
+	// Drops a sent message no folder refers to anymore
+	ReleaseMsg(mid MsgIDType) error
+
+	// Enqueues a message the system sends on the user's behalf (auto replies), it's marked Auto
+	EnqueueAutoMsg(msg *IngressMsg) (MsgIDType, error)
+	// Sends a copy of a message the account can see to new recipients. auto marks it as sent
+	// by the system (rule and settings forwards) so rules receiving it don't forward it in turn
+	ForwardMsg(id AccountIDType, mid MsgIDType, to []string, auto bool) (MsgIDType, error)
+
+	// Drafts live in the owner's Drafts folder, they aren't validated until they're sent
+	CreateDraft(id AccountIDType, msg *IngressMsg) (MsgIDType, error)
+	UpdateDraft(id AccountIDType, mid MsgIDType, msg *IngressMsg) error
+	RetrieveDraft(id AccountIDType, mid MsgIDType) (*EgressMsg, error)
+	// Most recently created first
+	ListDrafts(id AccountIDType) ([]*EgressMsg, error)
+	DeleteDraft(id AccountIDType, mid MsgIDType) error
+	// Validates and enqueues the draft, the draft is removed once it's in the system
+	SendDraft(id AccountIDType, mid MsgIDType) (MsgIDType, error)
 }
 
 // Forward types from the entity layer so clients don't have to unnecessarily reach into the entity directly
@@ -32,6 +63,16 @@ type ThreadIDType entity.ThreadIDType
 const ThreadIDStringBase = entity.ThreadIDStringBase
 const ThreadIDBits = entity.ThreadIDBits
 
+// IDGenerator hands out the msg and thread ids, they have to stay unique for as long as the
+// stored msgs, folders and threads are kept
+type IDGenerator interface {
+	NewMsgID() (MsgIDType, error)
+	NewThreadID() (ThreadIDType, error)
+}
+
+// PendingKeyType keys the pending deliveries, one for each {message, recipient} pair
+type PendingKeyType uint64
+
 // IngressMsg the type for messages coming into the usecase/interactor layer
 type IngressMsg entity.MsgBase
 
@@ -58,6 +99,21 @@ func ToMsgID(msgIDString string) (MsgIDType, error) {
 	return MsgIDType(id), nil
 }
 
+// ThreadIDToString conversion
+func ThreadIDToString(id ThreadIDType) string {
+	return strconv.FormatUint(uint64(id), ThreadIDStringBase)
+}
+
+// ToThreadID so we're all on the same format
+func ToThreadID(threadIDString string) (ThreadIDType, error) {
+	id, err := strconv.ParseUint(threadIDString, ThreadIDStringBase, ThreadIDBits)
+	if err != nil {
+		return ThreadIDType(0), NewEs(EsArgConvFail,
+			fmt.Sprintf("threadIDString %s", threadIDString))
+	}
+	return ThreadIDType(id), nil
+}
+
 //NOTE MsgUsecase just forward declares the entity.Msg types at the usecase boundary.
 //  It's not against Clean architecture, since the dependency is still inward, but
 //  from an extensibility viewpoint, the better way to do it is have different Msg types
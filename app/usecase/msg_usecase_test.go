@@ -0,0 +1,264 @@
+package usecase_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/io/storage/ram"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+func TestRepliesInheritThread(t *testing.T) {
+	ts := newTestSystem(t)
+	ts.register(t, "alice@mail.com", "bob@mail.com", "eve@mail.com")
+
+	send := func(from string, to string, parent usecase.MsgIDType) (usecase.MsgIDType, error) {
+		return ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			ParentMid:   entity.MsgIDType(parent),
+			SenderEmail: from,
+			Recipients:  []string{to},
+			Subject:     "thread",
+		})
+	}
+
+	root, err := send("alice@mail.com", "bob@mail.com", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := send("bob@mail.com", "alice@mail.com", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replyToReply, err := send("alice@mail.com", "bob@mail.com", reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := send("eve@mail.com", "bob@mail.com", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootMsg, _ := ts.msg.RetrieveMsg(root)
+	otherMsg, _ := ts.msg.RetrieveMsg(other)
+	if rootMsg.Tid == 0 || rootMsg.Tid == otherMsg.Tid {
+		t.Errorf("new conversations need their own thread got %d and %d", rootMsg.Tid, otherMsg.Tid)
+	}
+
+	thread, err := ts.msg.RetrieveThread(usecase.ThreadIDType(rootMsg.Tid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []usecase.MsgIDType{root, reply, replyToReply}
+	if len(thread) != len(expected) {
+		t.Fatalf("thread len expected %d got %d", len(expected), len(thread))
+	}
+	for i, msg := range thread {
+		if usecase.MsgIDType(msg.Mid) != expected[i] {
+			t.Errorf("thread[%d] expected mid %d got %d", i, expected[i], msg.Mid)
+		}
+		if msg.Tid != rootMsg.Tid {
+			t.Errorf("thread[%d] expected tid %d got %d", i, rootMsg.Tid, msg.Tid)
+		}
+	}
+
+	// eve wasn't on the thread, and unknown parents are rejected
+	if _, err := send("eve@mail.com", "alice@mail.com", root); !usecase.CheckEs(err, usecase.EsNotFound) {
+		t.Errorf("reply by outsider expected EsNotFound got %v", err)
+	}
+	if _, err := send("alice@mail.com", "bob@mail.com", 0xdead); !usecase.CheckEs(err, usecase.EsNotFound) {
+		t.Errorf("unknown parent expected EsNotFound got %v", err)
+	}
+}
+
+func TestRepliesIgnoreEmailCase(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+
+	root, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		SenderEmail: "Alice@mail.com",
+		Recipients:  []string{"Bob@Mail.com"},
+		Subject:     "hi",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both ends can reply and forward whatever case the addresses were written in
+	for _, from := range []string{"bob@mail.com", "alice@mail.com"} {
+		_, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			ParentMid:   entity.MsgIDType(root),
+			SenderEmail: from,
+			Recipients:  []string{"alice@mail.com"},
+		})
+		if err != nil {
+			t.Errorf("reply by %s: %v", from, err)
+		}
+	}
+	if _, err := ts.msg.ForwardMsg(ids[1], root, []string{"alice@mail.com"}, false); err != nil {
+		t.Errorf("forward by bob: %v", err)
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	alice, bob := ids[0], ids[1]
+
+	// Partial messages are fine as drafts
+	mid, err := ts.msg.CreateDraft(alice, &usecase.IngressMsg{Subject: "wip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ts.msg.RetrieveDraft(alice, mid); !got.M.CreatedAt.Equal(ts.clock.Now()) {
+		t.Errorf("draft CreatedAt expected the scheduler's clock %s got %s", ts.clock.Now(), got.M.CreatedAt)
+	}
+	other, _ := ts.msg.CreateDraft(alice, &usecase.IngressMsg{Subject: "later"})
+	if _, err := ts.msg.SendDraft(alice, mid); !usecase.CheckEs(err, usecase.EsArgInvalid) {
+		t.Errorf("send incomplete draft expected EsArgInvalid got %v", err)
+	}
+	if _, err := ts.msg.RetrieveDraft(bob, mid); !usecase.CheckEs(err, usecase.EsNotFound) {
+		t.Errorf("draft of another account expected EsNotFound got %v", err)
+	}
+
+	err = ts.msg.UpdateDraft(alice, mid, &usecase.IngressMsg{
+		SenderEmail: "alice@mail.com",
+		Recipients:  []string{"bob@mail.com"},
+		Subject:     "done",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drafts, err := ts.msg.ListDrafts(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drafts) != 2 || drafts[0].Mid != entity.MsgIDType(other) || drafts[1].M.Subject != "done" {
+		t.Errorf("unexpected drafts %+v", drafts)
+	}
+
+	sent, err := ts.msg.SendDraft(alice, mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count(t, bob, usecase.EnumInbox); n != 1 {
+		t.Errorf("bob inbox expected 1 got %d", n)
+	}
+	if msg, _ := ts.msg.RetrieveMsg(sent); msg == nil || msg.M.Subject != "done" {
+		t.Errorf("sent message doesn't match the draft %+v", msg)
+	}
+	if n := ts.count(t, alice, usecase.EnumDrafts); n != 1 {
+		t.Errorf("drafts count after send expected 1 got %d", n)
+	}
+
+	// Can't send as someone else
+	ts.msg.UpdateDraft(alice, other, &usecase.IngressMsg{
+		SenderEmail: "bob@mail.com",
+		Recipients:  []string{"alice@mail.com"},
+	})
+	if _, err := ts.msg.SendDraft(alice, other); !usecase.CheckEs(err, usecase.EsForbidden) {
+		t.Errorf("send with another sender expected EsForbidden got %v", err)
+	}
+	if err := ts.msg.DeleteDraft(alice, other); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count(t, alice, usecase.EnumDrafts); n != 0 {
+		t.Errorf("drafts count after delete expected 0 got %d", n)
+	}
+}
+
+func TestPersistedIDs(t *testing.T) {
+	marks := ram.NewStore[repo.GenericKeyT, uint64]()
+	seeded := 0
+	seed := func() (usecase.MsgIDType, usecase.ThreadIDType, error) {
+		seeded++
+		return 40, 9, nil
+	}
+	ids, err := usecase.NewPersistedIDGenerator(marks, 3, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An empty marks store starts after the seed
+	for want := usecase.MsgIDType(41); want <= 45; want++ {
+		if mid, err := ids.NewMsgID(); err != nil || mid != want {
+			t.Errorf("mid expected %d got %d %v", want, mid, err)
+		}
+	}
+	if tid, _ := ids.NewThreadID(); tid != 10 {
+		t.Errorf("tid expected 10 got %d", tid)
+	}
+
+	// A restart skips what's left of the reserved blocks, the seed isn't needed again
+	ids, err = usecase.NewPersistedIDGenerator(marks, 3, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mid, _ := ids.NewMsgID(); mid != 47 {
+		t.Errorf("mid after the restart expected 47 got %d", mid)
+	}
+	if tid, _ := ids.NewThreadID(); tid != 13 {
+		t.Errorf("tid after the restart expected 13 got %d", tid)
+	}
+	if seeded != 1 {
+		t.Errorf("seed expected to be called once got %d", seeded)
+	}
+	if _, err := usecase.NewPersistedIDGenerator(marks, 0, nil); !usecase.CheckEs(err, usecase.EsArgInvalid) {
+		t.Errorf("block of 0 expected EsArgInvalid got %v", err)
+	}
+}
+
+func TestStoredIDs(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	ts.msg.CreateDraft(ids[0], &usecase.IngressMsg{Subject: "wip"})
+	mid, _ := ts.msg.EnqueueMsg(&usecase.IngressMsg{SenderEmail: "bob@mail.com", Recipients: []string{"alice@mail.com"}})
+	ts.msg.CreateDraft(ids[1], &usecase.IngressMsg{Subject: "last"})
+
+	// The newest draft is only in a folder
+	maxMid, maxTid, err := usecase.StoredIDs(ram.NewStore[entity.MsgIDType, entity.Msg](), ts.fol)()
+	if err != nil || maxMid != mid+1 || maxTid != 1 {
+		t.Errorf("expected mid %d tid 1 got %d %d %v", mid+1, maxMid, maxTid, err)
+	}
+}
+
+func TestTimeIDs(t *testing.T) {
+	clock := newFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	ids, err := usecase.NewTimeIDGenerator(clock, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := usecase.NewTimeIDGenerator(clock, 4)
+
+	// Always increasing, through a used up millisecond and the clock going back
+	last, _ := ids.NewMsgID()
+	next := func(what string) {
+		t.Helper()
+		mid, _ := ids.NewMsgID()
+		tid, _ := ids.NewThreadID()
+		if mid <= last || usecase.MsgIDType(tid) <= mid {
+			t.Fatalf("%s: ids out of order %d, %d, %d", what, last, mid, tid)
+		}
+		if uint64(tid) >= 1<<53 {
+			t.Fatalf("%s: id %d doesn't fit in 53 bits", what, tid)
+		}
+		last = usecase.MsgIDType(tid)
+	}
+	for i := 0; i < 300; i++ {
+		next("same millisecond")
+	}
+	clock.Advance(-time.Second)
+	next("clock went back")
+	clock.Advance(2 * time.Second)
+	next("clock caught up")
+
+	// Another node in the same millisecond gets a different id
+	clock.Advance(time.Millisecond)
+	mid3, _ := ids.NewMsgID()
+	mid4, _ := other.NewMsgID()
+	if mid3 == mid4 {
+		t.Errorf("nodes 3 and 4 both made %d", mid3)
+	}
+	if _, err := usecase.NewTimeIDGenerator(clock, usecase.MaxIDNode+1); !usecase.CheckEs(err, usecase.EsArgInvalid) {
+		t.Errorf("node over the max expected EsArgInvalid got %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package usecase
 
 import (
+	"log"
+
 	"github.com/git-sim/tc/app/domain/entity"
 	"github.com/git-sim/tc/app/domain/repo"
 	"github.com/git-sim/tc/app/domain/service"
@@ -16,8 +18,13 @@ func InitAccounts(accUsecase AccountUsecase) error {
 
 // InitSubscribers called at bootup
 func InitSubscribers(accServ *service.AccountService, folUsecase FoldersUsecase,
-	accUsecase AccountUsecase, dbPendingMsgs repo.Generic) error {
-	err := initRegisterAccountSubsribers(accServ, folUsecase, accUsecase, dbPendingMsgs)
+	accUsecase AccountUsecase, rulesUsecase RulesUsecase,
+	dbPendingMsgs repo.Store[PendingKeyType, entity.PendingMsgEntry]) error {
+	err := initRegisterAccountSubsribers(accServ, folUsecase, accUsecase, rulesUsecase, dbPendingMsgs)
+	if err != nil {
+		return err
+	}
+	err = initChangeEmailSubsribers(accServ, rulesUsecase, dbPendingMsgs)
 	if err != nil {
 		return err
 	}
@@ -33,8 +40,67 @@ func InitSubscribers(accServ *service.AccountService, folUsecase FoldersUsecase,
 	return err
 }
 
+// InitScheduler called at bootup after the repos are loaded. Rescans the Scheduled folders
+// so messages queued before a restart still go out, then starts the scheduler
+func InitScheduler(sched Scheduler, msgUsecase MsgUsecase, folUsecase FoldersUsecase) error {
+	err := folUsecase.ForEachInFolder(EnumScheduled, func(msg MsgEntry) {
+		sched.Schedule(MsgIDType(msg.Mid), msg.M.M.ScheduledAt)
+	})
+	if err != nil {
+		return err
+	}
+	return sched.Start(msgUsecase.DispatchScheduled)
+}
+
+// InitFolderSubscribers called at bootup, messages removed from the last folder are released
+func InitFolderSubscribers(folUsecase FoldersUsecase, msgUsecase MsgUsecase) error {
+	folUsecase.SubscribeRemoved(func(mid MsgIDType) {
+		if err := msgUsecase.ReleaseMsg(mid); err != nil && !CheckEs(err, EsNotFound) {
+			log.Printf("release msg %s: %v", MsgIDToString(mid), err)
+		}
+	})
+	return nil
+}
+
+// InitRules called at bootup, the rules' forward actions go out through the MsgUsecase
+func InitRules(rulesUsecase RulesUsecase, msgUsecase MsgUsecase) error {
+	rulesUsecase.SubscribeForward(func(id AccountIDType, mid MsgIDType, to string) {
+		auto := true
+		if _, err := msgUsecase.ForwardMsg(id, mid, []string{to}, auto); err != nil {
+			log.Printf("rule forward of msg %s to %s: %v", MsgIDToString(mid), to, err)
+		}
+	})
+	return nil
+}
+
+// InitSettings called at bootup, forwarding and the vacation auto-reply run on every
+// delivered message and send through the MsgUsecase
+func InitSettings(settingsUsecase SettingsUsecase, rulesUsecase RulesUsecase, msgUsecase MsgUsecase) error {
+	rulesUsecase.SubscribeDelivered(settingsUsecase.OnInboxDelivery)
+	settingsUsecase.SubscribeForward(func(id AccountIDType, mid MsgIDType, to string) {
+		auto := true
+		if _, err := msgUsecase.ForwardMsg(id, mid, []string{to}, auto); err != nil {
+			log.Printf("forward of msg %s to %s: %v", MsgIDToString(mid), to, err)
+		}
+	})
+	settingsUsecase.SubscribeAutoReply(func(reply *IngressMsg) {
+		if _, err := msgUsecase.EnqueueAutoMsg(reply); err != nil {
+			log.Printf("auto reply to %v: %v", reply.Recipients, err)
+		}
+	})
+	return nil
+}
+
+// InitSearch called at bootup after the repos are loaded, keeps the search index in step
+// with the folders and builds it from what's already there
+func InitSearch(searchUsecase SearchUsecase, folUsecase FoldersUsecase) error {
+	folUsecase.SubscribeChange(searchUsecase.OnFolderChange)
+	return searchUsecase.Rebuild()
+}
+
 func initRegisterAccountSubsribers(accServ *service.AccountService, folUsecase FoldersUsecase,
-	accUsecase AccountUsecase, dbPendingMsgs repo.Generic) error {
+	accUsecase AccountUsecase, rulesUsecase RulesUsecase,
+	dbPendingMsgs repo.Store[PendingKeyType, entity.PendingMsgEntry]) error {
 
 	accServ.SubscribeRegisterAccount(
 		func(acc entity.Account) {
@@ -45,29 +111,43 @@ func initRegisterAccountSubsribers(accServ *service.AccountService, folUsecase F
 	accServ.SubscribeRegisterAccount(
 		// Scan pending messages looking for any meant for the newly created recipient
 		func(acc entity.Account) {
-			// Ugly but it works, there's no concurrency issue because the PendingMsg has been
-			// duplicated for each missing recipient so they'll only update their copy.
-			pendArray, err := dbPendingMsgs.RetrieveAll()
-			if err == nil {
-				for _, val := range pendArray {
-					if pendmsg, ok := val.(entity.PendingMsgEntry); ok {
-						if pendmsg.RecipientLeft == acc.GetEmail() {
-							folUsecase.AddToFolder(EnumInbox,
-								AccountIDType(acc.GetID()),
-								MsgEntry(pendmsg.E))
-
-							// Update/Delete the pending msg
-							msgkey := repo.GenericKeyT(pendmsg.E.Mid)
-							dbPendingMsgs.Delete(msgkey)
-						}
-					}
-				}
-			}
+			deliverPending(acc, rulesUsecase, dbPendingMsgs)
 		})
 
 	return nil
 }
 
+func initChangeEmailSubsribers(accServ *service.AccountService, rulesUsecase RulesUsecase,
+	dbPendingMsgs repo.Store[PendingKeyType, entity.PendingMsgEntry]) error {
+
+	accServ.SubscribeChangeEmail(
+		// Messages sent to the new address before the account moved there
+		func(acc entity.Account, oldEmail string) {
+			deliverPending(acc, rulesUsecase, dbPendingMsgs)
+		})
+	return nil
+}
+
+// deliverPending moves the pending messages addressed to acc's email into its inbox
+func deliverPending(acc entity.Account, rulesUsecase RulesUsecase,
+	dbPendingMsgs repo.Store[PendingKeyType, entity.PendingMsgEntry]) {
+	// Ugly but it works, there's no concurrency issue because the PendingMsg has been
+	// duplicated for each missing recipient so they'll only update their copy.
+	email := entity.NormalizeEmail(acc.GetEmail())
+	pendArray, err := dbPendingMsgs.RetrieveFiltered(func(pendmsg entity.PendingMsgEntry) bool {
+		return entity.NormalizeEmail(pendmsg.RecipientLeft) == email
+	})
+	if err == nil {
+		for _, pendmsg := range pendArray {
+			rulesUsecase.DeliverToInbox(AccountIDType(acc.GetID()),
+				MsgEntry(pendmsg.E))
+
+			// Update/Delete the pending msg
+			dbPendingMsgs.Delete(pendingKey(pendmsg.RecipientLeft, MsgIDType(pendmsg.E.Mid)))
+		}
+	}
+}
+
 func initDeleteAccountSubsribers(accServ *service.AccountService, folUsecase FoldersUsecase,
 	accUsecase AccountUsecase) error {
 	return nil //tbd
@@ -0,0 +1,268 @@
+package usecase
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/domain/repo"
+)
+
+// AccountRules what's stored per account in dbRules, copy-on-write like the folders
+type AccountRules struct {
+	Rules  []Rule // in the order they're applied
+	NextID RuleIDType
+}
+
+type rulesUsecase struct {
+	mtx        *sync.Mutex // guards the read-modify-write of an account's rules
+	dbRules    repo.Store[entity.AccountIDType, AccountRules]
+	folUsecase FoldersUsecase
+
+	forwardSubscribers   []func(AccountIDType, MsgIDType, string)
+	deliveredSubscribers []func(AccountIDType, MsgEntry)
+}
+
+// NewRulesUsecase ctor
+func NewRulesUsecase(dbRules repo.Store[entity.AccountIDType, AccountRules], folUsecase FoldersUsecase) RulesUsecase {
+	return &rulesUsecase{
+		mtx:        &sync.Mutex{},
+		dbRules:    dbRules,
+		folUsecase: folUsecase,
+	}
+}
+
+// getAccountRules an account without rules has none stored
+func (u *rulesUsecase) getAccountRules(id AccountIDType) (AccountRules, error) {
+	ar, err := u.dbRules.Retrieve(entity.AccountIDType(id))
+	if CheckEs(err, EsNotFound) {
+		return AccountRules{NextID: 1}, nil
+	}
+	return ar, err
+}
+
+func (u *rulesUsecase) putAccountRules(id AccountIDType, ar AccountRules) error {
+	return u.dbRules.Update(entity.AccountIDType(id), ar)
+}
+
+// validRule needs something to match and something to do, a move has to go to an existing
+// Inbox, Archive or user folder
+func (u *rulesUsecase) validRule(id AccountIDType, rule Rule) error {
+	if rule.SenderContains == "" && rule.SubjectContains == "" {
+		return NewEs(EsArgInvalid, "Rule has no conditions")
+	}
+	if !rule.Archive && !rule.Star && !rule.MarkRead && rule.MoveTo == 0 && rule.ForwardTo == "" {
+		return NewEs(EsArgInvalid, "Rule has no actions")
+	}
+	if rule.ForwardTo != "" && !IsValidEmailStr(rule.ForwardTo) {
+		return NewEs(EsArgInvalid, "ForwardTo email format")
+	}
+	if rule.MoveTo != 0 {
+		if !isEntryFolder(rule.MoveTo) || rule.MoveTo == EnumTrash {
+			return NewEs(EsArgInvalid,
+				fmt.Sprintf("MoveTo folder %d", rule.MoveTo))
+		}
+		folders, err := u.folUsecase.ListFolders(id)
+		if err != nil {
+			return err
+		}
+		for _, info := range folders.FolderInfo {
+			if info.Idx == rule.MoveTo {
+				return nil
+			}
+		}
+		return NewEs(EsNotFound,
+			fmt.Sprintf("MoveTo folder %d", rule.MoveTo))
+	}
+	return nil
+}
+
+func (u *rulesUsecase) ListRules(id AccountIDType) ([]Rule, error) {
+	ar, err := u.getAccountRules(id)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Rule, len(ar.Rules))
+	copy(out, ar.Rules)
+	return out, nil
+}
+
+func (u *rulesUsecase) CreateRule(id AccountIDType, rule Rule) (*Rule, error) {
+	if err := u.validRule(id, rule); err != nil {
+		return nil, err
+	}
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	ar, err := u.getAccountRules(id)
+	if err != nil {
+		return nil, err
+	}
+	rule.ID = ar.NextID
+	newAr := AccountRules{
+		Rules:  append(append([]Rule{}, ar.Rules...), rule),
+		NextID: ar.NextID + 1,
+	}
+	if err := u.putAccountRules(id, newAr); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (u *rulesUsecase) UpdateRule(id AccountIDType, rule Rule) error {
+	if err := u.validRule(id, rule); err != nil {
+		return err
+	}
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	ar, err := u.getAccountRules(id)
+	if err != nil {
+		return err
+	}
+	newRules := append([]Rule{}, ar.Rules...)
+	for i := range newRules {
+		if newRules[i].ID == rule.ID {
+			newRules[i] = rule
+			return u.putAccountRules(id, AccountRules{Rules: newRules, NextID: ar.NextID})
+		}
+	}
+	return NewEs(EsNotFound,
+		fmt.Sprintf("Rule with id %d", rule.ID))
+}
+
+func (u *rulesUsecase) DeleteRule(id AccountIDType, ruleID RuleIDType) error {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	ar, err := u.getAccountRules(id)
+	if err != nil {
+		return err
+	}
+	newRules := make([]Rule, 0, len(ar.Rules))
+	for _, rule := range ar.Rules {
+		if rule.ID != ruleID {
+			newRules = append(newRules, rule)
+		}
+	}
+	if len(newRules) == len(ar.Rules) {
+		return NewEs(EsNotFound,
+			fmt.Sprintf("Rule with id %d", ruleID))
+	}
+	return u.putAccountRules(id, AccountRules{Rules: newRules, NextID: ar.NextID})
+}
+
+func containsFold(s string, sub string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+}
+
+// matches checks the rule's conditions against the message
+func (rule *Rule) matches(msg MsgEntry) bool {
+	conds := []bool{}
+	if rule.SenderContains != "" {
+		conds = append(conds, containsFold(msg.M.M.SenderEmail, rule.SenderContains))
+	}
+	if rule.SubjectContains != "" {
+		conds = append(conds, containsFold(msg.M.M.Subject, rule.SubjectContains))
+	}
+	if len(conds) == 0 {
+		return false
+	}
+	for _, ok := range conds {
+		if ok && !rule.MatchAll {
+			return true
+		}
+		if !ok && rule.MatchAll {
+			return false
+		}
+	}
+	return rule.MatchAll
+}
+
+func (u *rulesUsecase) DryRun(id AccountIDType, rule Rule) (*RuleDryRunOutput, error) {
+	if err := u.validRule(id, rule); err != nil {
+		return nil, err
+	}
+	pOut := &RuleDryRunOutput{Elems: []MsgEntry{}}
+	err := u.folUsecase.ForEachInAccount(id, func(folderEnum int, msg MsgEntry) {
+		if isEntryFolder(folderEnum) && folderEnum != EnumTrash && rule.matches(msg) {
+			pOut.Elems = append(pOut.Elems, msg)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	pOut.NumMatched = len(pOut.Elems)
+	return pOut, nil
+}
+
+func (u *rulesUsecase) DeliverToInbox(id AccountIDType, msg MsgEntry) error {
+	ar, err := u.getAccountRules(id)
+	if err != nil {
+		return err
+	}
+	dest := EnumInbox
+	forwards := []string{}
+	for _, rule := range ar.Rules {
+		if !rule.matches(msg) {
+			continue
+		}
+		if rule.Star {
+			msg.IsStarred = true
+		}
+		if rule.MarkRead && !msg.IsViewed {
+			msg.IsViewed = true
+			msg.ViewedAt = time.Now()
+		}
+		if dest == EnumInbox {
+			if rule.Archive {
+				dest = EnumArchive
+			} else if rule.MoveTo != 0 {
+				dest = rule.MoveTo
+			}
+		}
+		// Forwarding what was already sent automatically could bounce between accounts forever
+		if rule.ForwardTo != "" && !msg.M.Auto {
+			forwards = append(forwards, rule.ForwardTo)
+		}
+	}
+
+	err = u.folUsecase.AddToFolder(dest, id, msg)
+	if err != nil && dest != EnumInbox && CheckEs(err, EsNotFound) {
+		// The rule's folder was deleted since, don't lose the message
+		err = u.folUsecase.AddToFolder(EnumInbox, id, msg)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, fn := range u.deliveredSubscribers {
+		fn(id, msg)
+	}
+
+	sent := make(map[string]bool)
+	for _, to := range forwards {
+		if sent[strings.ToLower(to)] {
+			continue
+		}
+		sent[strings.ToLower(to)] = true
+		for _, fn := range u.forwardSubscribers {
+			fn(id, MsgIDType(msg.Mid), to)
+		}
+	}
+	return nil
+}
+
+// SubscribeForward same simple pub-sub as the AccountService, subscribe at bootup
+func (u *rulesUsecase) SubscribeForward(fn func(AccountIDType, MsgIDType, string)) {
+	u.forwardSubscribers = append(u.forwardSubscribers, fn)
+}
+
+// SubscribeDelivered subscribe at bootup
+func (u *rulesUsecase) SubscribeDelivered(fn func(AccountIDType, MsgEntry)) {
+	u.deliveredSubscribers = append(u.deliveredSubscribers, fn)
+}
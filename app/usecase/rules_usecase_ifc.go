@@ -0,0 +1,49 @@
+package usecase
+
+// RuleIDType ids are per account
+type RuleIDType uint64
+
+// Rule a filter run on every message delivered to the account's Inbox.
+// The conditions that are set are checked, with MatchAll false one matching is enough.
+// The actions of every matching rule are applied, the first rule that moves the message decides the folder
+type Rule struct {
+	ID   RuleIDType
+	Name string
+
+	// Conditions, substring matches ignoring case
+	SenderContains  string
+	SubjectContains string
+	MatchAll        bool
+
+	// Actions
+	Archive   bool
+	Star      bool
+	MarkRead  bool
+	MoveTo    int    // folder idx, 0 doesn't move
+	ForwardTo string // email address
+}
+
+// RuleDryRunOutput the existing messages a rule would have matched
+type RuleDryRunOutput struct {
+	NumMatched int
+	Elems      []MsgEntry
+}
+
+// RulesUsecase the per account rules and applying them on delivery
+type RulesUsecase interface {
+	ListRules(id AccountIDType) ([]Rule, error)
+	// CreateRule the ID is assigned, returns the stored rule
+	CreateRule(id AccountIDType, rule Rule) (*Rule, error)
+	UpdateRule(id AccountIDType, rule Rule) error
+	DeleteRule(id AccountIDType, ruleID RuleIDType) error
+	// DryRun checks the rule against the messages in the Inbox, Archive and user folders, nothing is changed
+	DryRun(id AccountIDType, rule Rule) (*RuleDryRunOutput, error)
+
+	// For use by the system
+	// DeliverToInbox runs the account's rules and puts the message in the resulting folder
+	DeliverToInbox(id AccountIDType, msg MsgEntry) error
+	// SubscribeForward fn is called for the rules' forward actions
+	SubscribeForward(fn func(id AccountIDType, mid MsgIDType, to string))
+	// SubscribeDelivered fn is called after a message is delivered, wherever the rules put it
+	SubscribeDelivered(fn func(id AccountIDType, msg MsgEntry))
+}
@@ -0,0 +1,114 @@
+package usecase_test
+
+import (
+	"testing"
+
+	"github.com/git-sim/tc/app/usecase"
+)
+
+func TestRulesOnDelivery(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com", "carol@mail.com")
+	bob, carol := ids[1], ids[2]
+
+	send := func(from string, subject string) usecase.MsgIDType {
+		mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			SenderEmail: from,
+			Recipients:  []string{"bob@mail.com"},
+			Subject:     subject,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mid
+	}
+	old := send("alice@mail.com", "Your invoice")
+
+	receipts, _ := ts.fol.CreateFolder(bob, "Receipts")
+	if _, err := ts.rules.CreateRule(bob, usecase.Rule{SubjectContains: "invoice"}); !usecase.CheckEs(err, usecase.EsArgInvalid) {
+		t.Errorf("rule without actions expected EsArgInvalid got %v", err)
+	}
+	rule, err := ts.rules.CreateRule(bob, usecase.Rule{
+		SenderContains:  "ALICE",
+		SubjectContains: "invoice",
+		MatchAll:        true,
+		Star:            true,
+		MoveTo:          receipts.Idx,
+		ForwardTo:       "carol@mail.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.rules.CreateRule(bob, usecase.Rule{SenderContains: "alice", MarkRead: true, Archive: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The dry run sees the message that came before the rule
+	dry, err := ts.rules.DryRun(bob, *rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dry.NumMatched != 1 || usecase.MsgIDType(dry.Elems[0].Mid) != old {
+		t.Errorf("dry run expected the old invoice got %+v", dry.Elems)
+	}
+
+	matched := send("alice@mail.com", "Another Invoice")
+	send("alice@mail.com", "lunch")
+	send("carol@mail.com", "invoice from carol")
+
+	// Both rules matched, the first one decides the folder
+	entry, err := ts.fol.RetrieveFromFolder(receipts.Idx, bob, matched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.IsStarred || !entry.IsViewed {
+		t.Errorf("expected starred and read got %+v", entry)
+	}
+	if n := ts.count(t, bob, usecase.EnumArchive); n != 1 {
+		t.Errorf("archive expected 1 got %d", n)
+	}
+	if n := ts.count(t, bob, usecase.EnumInbox); n != 2 {
+		t.Errorf("inbox expected 2 got %d", n)
+	}
+	if n := ts.count(t, carol, usecase.EnumInbox); n != 1 {
+		t.Errorf("carol expected the forward got %d", n)
+	}
+
+	// A deleted folder doesn't lose mail
+	ts.fol.DeleteFolder(bob, receipts.Idx)
+	send("alice@mail.com", "invoice again")
+	if n := ts.count(t, bob, usecase.EnumInbox); n != 3 {
+		t.Errorf("inbox after folder delete expected 3 got %d", n)
+	}
+
+	if err := ts.rules.DeleteRule(bob, rule.ID); err != nil {
+		t.Fatal(err)
+	}
+	if rules, _ := ts.rules.ListRules(bob); len(rules) != 1 {
+		t.Errorf("expected 1 rule left got %d", len(rules))
+	}
+}
+
+func TestRuleForwardsDontLoop(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	for i, to := range []string{"bob@mail.com", "alice@mail.com"} {
+		if _, err := ts.rules.CreateRule(ids[i], usecase.Rule{SubjectContains: "ping", ForwardTo: to}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		SenderEmail: "alice@mail.com",
+		Recipients:  []string{"bob@mail.com"},
+		Subject:     "ping",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// bob's rule forwards to alice, alice's rule leaves the forward alone
+	if n := ts.count(t, ids[0], usecase.EnumInbox); n != 1 {
+		t.Errorf("alice expected 1 forward got %d", n)
+	}
+	if n := ts.count(t, ids[1], usecase.EnumInbox); n != 1 {
+		t.Errorf("bob expected 1 msg got %d", n)
+	}
+}
@@ -0,0 +1,239 @@
+package usecase
+
+import (
+	"container/heap"
+	"log"
+	"sync"
+	"time"
+)
+
+// scheduledItem is an entry in the scheduler's time ordered queue
+type scheduledItem struct {
+	mid     MsgIDType
+	at      time.Time
+	retries int // failed dispatches so far
+	index   int // maintained by the heap
+}
+
+// A failed dispatch is retried after retryBackoff, doubling with each failure up to maxRetryBackoff
+const (
+	retryBackoff    = time.Second
+	maxRetryBackoff = 10 * time.Minute
+)
+
+// backoff the wait before the next try after retries failures
+func backoff(retries int) time.Duration {
+	d := retryBackoff
+	for i := 1; i < retries && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	return d
+}
+
+// scheduleQueue min-heap on the due time, ties broken by the message id so order is stable
+type scheduleQueue []*scheduledItem
+
+func (q scheduleQueue) Len() int { return len(q) }
+func (q scheduleQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].mid < q[j].mid
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q scheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+func (q *scheduleQueue) Push(x interface{}) {
+	item := x.(*scheduledItem)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+func (q *scheduleQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*q = old[:n-1]
+	return item
+}
+
+type scheduler struct {
+	mtx     *sync.Mutex
+	clock   Clock
+	queue   scheduleQueue
+	items   map[MsgIDType]*scheduledItem
+	fn      DispatchFunc
+	wake    chan struct{}
+	done    chan struct{}
+	running bool
+}
+
+// NewScheduler ctor, pass nil to use the real clock
+func NewScheduler(clock Clock) Scheduler {
+	if clock == nil {
+		clock = NewRealClock()
+	}
+	return &scheduler{
+		mtx:   &sync.Mutex{},
+		clock: clock,
+		items: make(map[MsgIDType]*scheduledItem),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+func (s *scheduler) Clock() Clock {
+	return s.clock
+}
+
+func (s *scheduler) Schedule(mid MsgIDType, at time.Time) {
+	s.mtx.Lock()
+	if item, ok := s.items[mid]; ok {
+		item.at = at
+		item.retries = 0
+		heap.Fix(&s.queue, item.index)
+	} else {
+		item := &scheduledItem{mid: mid, at: at}
+		heap.Push(&s.queue, item)
+		s.items[mid] = item
+	}
+	s.mtx.Unlock()
+	s.notify()
+}
+
+func (s *scheduler) Cancel(mid MsgIDType) bool {
+	s.mtx.Lock()
+	item, ok := s.items[mid]
+	if ok {
+		heap.Remove(&s.queue, item.index)
+		delete(s.items, mid)
+	}
+	s.mtx.Unlock()
+	if ok {
+		s.notify()
+	}
+	return ok
+}
+
+func (s *scheduler) IsScheduled(mid MsgIDType) (time.Time, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if item, ok := s.items[mid]; ok {
+		return item.at, true
+	}
+	return time.Time{}, false
+}
+
+func (s *scheduler) Start(fn DispatchFunc) error {
+	if fn == nil {
+		return NewEs(EsArgInvalid, "DispatchFunc")
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.running {
+		return NewEs(EsAlreadyExists, "scheduler already running")
+	}
+	s.fn = fn
+	s.done = make(chan struct{})
+	s.running = true
+	go s.loop(s.done)
+	return nil
+}
+
+func (s *scheduler) Stop() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.running {
+		close(s.done)
+		s.running = false
+	}
+}
+
+// notify wakes the loop so it can recompute the next deadline, never blocks
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// nextDue returns how long until the earliest item is due
+func (s *scheduler) nextDue() (time.Duration, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(s.queue) == 0 {
+		return 0, false
+	}
+	return s.queue[0].at.Sub(s.clock.Now()), true
+}
+
+func (s *scheduler) loop(done chan struct{}) {
+	for {
+		var timer <-chan time.Time // nil channel blocks forever when nothing is queued
+		if d, ok := s.nextDue(); ok {
+			if d <= 0 {
+				s.RunDue()
+				continue
+			}
+			timer = s.clock.After(d)
+		}
+		select {
+		case <-timer:
+			s.RunDue()
+		case <-s.wake:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (s *scheduler) RunDue() int {
+	s.mtx.Lock()
+	fn := s.fn
+	if fn == nil {
+		// Not started yet, leave everything queued
+		s.mtx.Unlock()
+		return 0
+	}
+	now := s.clock.Now()
+	due := []*scheduledItem{}
+	for len(s.queue) > 0 && !s.queue[0].at.After(now) {
+		item := heap.Pop(&s.queue).(*scheduledItem)
+		delete(s.items, item.mid)
+		due = append(due, item)
+	}
+	s.mtx.Unlock()
+
+	// Dispatch outside of the lock so the dispatcher can call back into the scheduler
+	for _, item := range due {
+		if err := fn(item.mid, now); err != nil {
+			log.Printf("scheduler: dispatch of msg %s failed: %s", MsgIDToString(item.mid), err.Error())
+			s.retry(item, now, err)
+		}
+	}
+	return len(due)
+}
+
+// retry puts a failed item back in the queue after the backoff. Not if it was scheduled again
+// while being dispatched, or the message is gone or already sent as trying again won't help.
+func (s *scheduler) retry(item *scheduledItem, now time.Time, err error) {
+	if CheckEs(err, EsNotFound) || CheckEs(err, EsAlreadyReported) {
+		return
+	}
+	s.mtx.Lock()
+	if _, ok := s.items[item.mid]; ok {
+		s.mtx.Unlock()
+		return
+	}
+	item.retries++
+	item.at = now.Add(backoff(item.retries))
+	heap.Push(&s.queue, item)
+	s.items[item.mid] = item
+	s.mtx.Unlock()
+	s.notify()
+}
@@ -0,0 +1,42 @@
+package usecase
+
+import (
+	"time"
+)
+
+// Clock abstracts the time source so the scheduler can be driven in tests without sleeping
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// NewRealClock returns a Clock backed by the time package
+func NewRealClock() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// DispatchFunc is called by the scheduler when a message is due. now is the scheduler's clock time
+type DispatchFunc func(mid MsgIDType, now time.Time) error
+
+// Scheduler wakes up at each message's ScheduledAt and hands it to the dispatcher
+type Scheduler interface {
+	// Schedule adds the message, or moves it to the new time if already scheduled
+	Schedule(mid MsgIDType, at time.Time)
+	// Cancel removes the message, returns false if it wasn't scheduled
+	Cancel(mid MsgIDType) bool
+	// IsScheduled reports if the message is waiting and when it's due
+	IsScheduled(mid MsgIDType) (time.Time, bool)
+
+	// Start runs the background timer loop calling fn for each due message
+	Start(fn DispatchFunc) error
+	Stop()
+
+	// RunDue dispatches everything due as of Clock.Now(), returns the number dispatched
+	RunDue() int
+	Clock() Clock
+}
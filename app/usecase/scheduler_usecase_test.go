@@ -0,0 +1,352 @@
+package usecase_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/domain/service"
+	"github.com/git-sim/tc/app/io/storage/ram"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+// fakeClock only moves when the test advances it, After never fires so the
+// test drives dispatch through RunDue
+type fakeClock struct {
+	mtx *sync.Mutex
+	now time.Time
+}
+
+func newFakeClock(start time.Time) *fakeClock {
+	return &fakeClock{mtx: &sync.Mutex{}, now: start}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return nil
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// testSystem wires up the usecases on top of the ram repos
+type testSystem struct {
+	clock     *fakeClock
+	sched     usecase.Scheduler
+	acc       usecase.AccountUsecase
+	fol       usecase.FoldersUsecase
+	rules     usecase.RulesUsecase
+	settings  usecase.SettingsUsecase
+	msg       usecase.MsgUsecase
+	dbPending repo.Store[usecase.PendingKeyType, entity.PendingMsgEntry]
+}
+
+func newTestSystem(t *testing.T) *testSystem {
+	dbAccounts := ram.NewAccountRepo()
+	dbPending := ram.NewStore[usecase.PendingKeyType, entity.PendingMsgEntry]()
+	accServ := service.NewAccountService(dbAccounts)
+
+	ts := &testSystem{clock: newFakeClock(time.Now()), dbPending: dbPending}
+	ts.sched = usecase.NewScheduler(ts.clock)
+	ts.acc = usecase.NewAccountUsecase(dbAccounts, nil, accServ, usecase.NewSequentialAccountIDs(dbAccounts))
+	ts.fol = usecase.NewFoldersUsecase(ram.NewStore[entity.AccountIDType, usecase.AccountFolders](),
+		ram.IndexedStoreFactory[entity.MsgIDType, entity.MsgEntry](usecase.FolderIndexes...),
+		ram.NewStore[entity.ThreadIDType, bool], accServ)
+	ts.rules = usecase.NewRulesUsecase(ram.NewStore[entity.AccountIDType, usecase.AccountRules](), ts.fol)
+	ts.msg = usecase.NewMsgUsecase(ram.NewStore[entity.MsgIDType, entity.Msg](), dbPending,
+		ram.NewStore[entity.ThreadIDType, []entity.MsgIDType](), ts.fol, ts.rules, accServ, ts.sched,
+		usecase.NewMemIDGenerator())
+
+	if err := usecase.InitSubscribers(accServ, ts.fol, ts.acc, ts.rules, dbPending); err != nil {
+		t.Fatal(err)
+	}
+	if err := usecase.InitRules(ts.rules, ts.msg); err != nil {
+		t.Fatal(err)
+	}
+	ts.settings = usecase.NewSettingsUsecase(ram.NewStore[entity.AccountIDType, usecase.AccountSettingsEntry](), accServ, ts.clock)
+	if err := usecase.InitSettings(ts.settings, ts.rules, ts.msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := usecase.InitFolderSubscribers(ts.fol, ts.msg); err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func (ts *testSystem) register(t *testing.T, emails ...string) []usecase.AccountIDType {
+	ids := []usecase.AccountIDType{}
+	for _, email := range emails {
+		acc, err := ts.acc.RegisterAccount(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := usecase.ToAccountID(acc.ID)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (ts *testSystem) count(t *testing.T, id usecase.AccountIDType, folderEnum int) int {
+	out, err := ts.fol.QueryMsgs(id, usecase.QueryParams{FolderIdx: folderEnum, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.NumTotal
+}
+
+func TestSchedulerDispatchesWhenDue(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	if err := ts.sched.Start(ts.msg.DispatchScheduled); err != nil {
+		t.Fatal(err)
+	}
+	defer ts.sched.Stop()
+
+	mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		SenderEmail: "alice@mail.com",
+		Recipients:  []string{"bob@mail.com", "carol@mail.com"},
+		Subject:     "later",
+		ScheduledAt: ts.clock.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ts.sched.IsScheduled(mid); !ok {
+		t.Fatal("message not handed to the scheduler")
+	}
+	if n := ts.count(t, ids[0], usecase.EnumScheduled); n != 1 {
+		t.Errorf("scheduled count expected 1 got %d", n)
+	}
+
+	// Not due yet
+	ts.clock.Advance(30 * time.Minute)
+	if n := ts.sched.RunDue(); n != 0 {
+		t.Errorf("dispatched %d before due", n)
+	}
+
+	ts.clock.Advance(30 * time.Minute)
+	if n := ts.sched.RunDue(); n != 1 {
+		t.Fatalf("expected 1 dispatch got %d", n)
+	}
+
+	out, err := ts.msg.RetrieveMsg(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.SentAt.Equal(ts.clock.Now()) {
+		t.Errorf("SentAt expected %s got %s", ts.clock.Now(), out.SentAt)
+	}
+	if n := ts.count(t, ids[0], usecase.EnumScheduled); n != 0 {
+		t.Errorf("scheduled count expected 0 got %d", n)
+	}
+	if n := ts.count(t, ids[0], usecase.EnumSent); n != 1 {
+		t.Errorf("sent count expected 1 got %d", n)
+	}
+	if n := ts.count(t, ids[1], usecase.EnumInbox); n != 1 {
+		t.Errorf("inbox count expected 1 got %d", n)
+	}
+	// carol isn't registered so she gets a pending entry
+	if n, _ := ts.dbPending.RetrieveCount(); n != 1 {
+		t.Errorf("pending count expected 1 got %d", n)
+	}
+	// and it's delivered and cleared once she registers
+	carol := ts.register(t, "carol@mail.com")
+	if n := ts.count(t, carol[0], usecase.EnumInbox); n != 1 {
+		t.Errorf("carol's inbox count expected 1 got %d", n)
+	}
+	if n, _ := ts.dbPending.RetrieveCount(); n != 0 {
+		t.Errorf("pending count after registering expected 0 got %d", n)
+	}
+}
+
+func TestScheduleThresholdUsesClock(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	// Far from the real time, only the scheduler's clock counts
+	ts.clock.Advance(365 * 24 * time.Hour)
+
+	for _, tc := range []struct {
+		in        time.Duration
+		scheduled bool
+	}{
+		{5 * time.Second, false}, // too soon to be worth scheduling
+		{time.Minute, true},
+	} {
+		mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			SenderEmail: "alice@mail.com",
+			Recipients:  []string{"bob@mail.com"},
+			ScheduledAt: ts.clock.Now().Add(tc.in),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := ts.sched.IsScheduled(mid); ok != tc.scheduled {
+			t.Errorf("in %s expected scheduled %v got %v", tc.in, tc.scheduled, ok)
+		}
+	}
+	if n := ts.count(t, ids[1], usecase.EnumInbox); n != 1 {
+		t.Errorf("inbox expected the msg sent right away got %d", n)
+	}
+	if out, _ := ts.msg.RetrieveMsg(1); out == nil || !out.SentAt.Equal(ts.clock.Now()) {
+		t.Errorf("SentAt expected the clock's %s got %v", ts.clock.Now(), out)
+	}
+}
+
+func TestSchedulerRetriesFailedDispatch(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	// The first dispatch fails like a repo that's briefly unavailable
+	failures := 1
+	err := ts.sched.Start(func(mid usecase.MsgIDType, now time.Time) error {
+		if failures > 0 {
+			failures--
+			return usecase.NewEs(usecase.EsInternalError, "repo unavailable")
+		}
+		return ts.msg.DispatchScheduled(mid, now)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.sched.Stop()
+
+	mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		SenderEmail: "alice@mail.com",
+		Recipients:  []string{"bob@mail.com"},
+		ScheduledAt: ts.clock.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.clock.Advance(time.Hour)
+	ts.sched.RunDue()
+	at, ok := ts.sched.IsScheduled(mid)
+	if !ok || !at.After(ts.clock.Now()) {
+		t.Fatalf("failed dispatch expected to be retried later got %s %v", at, ok)
+	}
+	if n := ts.count(t, ids[0], usecase.EnumScheduled); n != 1 {
+		t.Errorf("scheduled count after the failure expected 1 got %d", n)
+	}
+
+	ts.clock.Advance(at.Sub(ts.clock.Now()))
+	if n := ts.sched.RunDue(); n != 1 {
+		t.Fatalf("expected the retry to be dispatched got %d", n)
+	}
+	if n := ts.count(t, ids[1], usecase.EnumInbox); n != 1 {
+		t.Errorf("inbox count after the retry expected 1 got %d", n)
+	}
+	if _, ok := ts.sched.IsScheduled(mid); ok {
+		t.Error("still scheduled after the retry went out")
+	}
+}
+
+func TestSchedulerRescanAtBoot(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+
+	mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		SenderEmail: "alice@mail.com",
+		Recipients:  []string{"bob@mail.com"},
+		ScheduledAt: ts.clock.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a restart, a fresh scheduler over the same repos
+	rebooted := usecase.NewScheduler(ts.clock)
+	if err := usecase.InitScheduler(rebooted, ts.msg, ts.fol); err != nil {
+		t.Fatal(err)
+	}
+	defer rebooted.Stop()
+	if _, ok := rebooted.IsScheduled(mid); !ok {
+		t.Fatal("boot scan didn't pick up the scheduled message")
+	}
+
+	ts.clock.Advance(2 * time.Hour)
+	rebooted.RunDue()
+	if n := ts.count(t, ids[1], usecase.EnumInbox); n != 1 {
+		t.Errorf("inbox count expected 1 got %d", n)
+	}
+}
+
+func TestCancelAndReschedule(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	if err := ts.sched.Start(ts.msg.DispatchScheduled); err != nil {
+		t.Fatal(err)
+	}
+	defer ts.sched.Stop()
+
+	enqueue := func(subject string) usecase.MsgIDType {
+		mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			SenderEmail: "alice@mail.com",
+			Recipients:  []string{"bob@mail.com"},
+			Subject:     subject,
+			ScheduledAt: ts.clock.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mid
+	}
+	midCancel := enqueue("cancel me")
+	midMove := enqueue("move me")
+
+	// Only the sender can pull it back
+	if _, err := ts.msg.CancelScheduled(ids[1], midCancel); !usecase.CheckEs(err, usecase.EsNotFound) {
+		t.Errorf("cancel by non sender expected EsNotFound got %v", err)
+	}
+	draft, err := ts.msg.CancelScheduled(ids[0], midCancel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if draft.Subject != "cancel me" {
+		t.Errorf("draft subject expected 'cancel me' got %s", draft.Subject)
+	}
+	if _, ok := ts.sched.IsScheduled(midCancel); ok {
+		t.Error("cancelled message still scheduled")
+	}
+	if _, err := ts.msg.RetrieveDraft(ids[0], midCancel); err != nil {
+		t.Errorf("cancelled message not kept as a draft %v", err)
+	}
+
+	newAt := ts.clock.Now().Add(3 * time.Hour)
+	if err := ts.msg.RescheduleMsg(ids[0], midMove, newAt); err != nil {
+		t.Fatal(err)
+	}
+	if at, _ := ts.sched.IsScheduled(midMove); !at.Equal(newAt) {
+		t.Errorf("scheduled at expected %s got %s", newAt, at)
+	}
+
+	// Original time passes, nothing goes out
+	ts.clock.Advance(2 * time.Hour)
+	ts.sched.RunDue()
+	if n := ts.count(t, ids[1], usecase.EnumInbox); n != 0 {
+		t.Errorf("inbox count expected 0 got %d", n)
+	}
+
+	ts.clock.Advance(time.Hour)
+	ts.sched.RunDue()
+	if n := ts.count(t, ids[1], usecase.EnumInbox); n != 1 {
+		t.Errorf("inbox count expected 1 got %d", n)
+	}
+
+	// Too late once dispatched
+	err = ts.msg.RescheduleMsg(ids[0], midMove, ts.clock.Now().Add(time.Hour))
+	if !usecase.CheckEs(err, usecase.EsForbidden) {
+		t.Errorf("reschedule after dispatch expected EsForbidden got %v", err)
+	}
+	if _, err := ts.msg.CancelScheduled(ids[0], midMove); !usecase.CheckEs(err, usecase.EsForbidden) {
+		t.Errorf("cancel after dispatch expected EsForbidden got %v", err)
+	}
+}
@@ -0,0 +1,375 @@
+package usecase
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// searchDoc what's indexed for one message in one account
+type searchDoc struct {
+	folders map[int]bool // a message sent to yourself is in Sent and Inbox
+	terms   map[string]bool
+}
+
+// accountIndex inverted index of an account's messages
+type accountIndex struct {
+	docs     map[MsgIDType]*searchDoc
+	postings map[string]map[MsgIDType]bool
+}
+
+type searchUsecase struct {
+	mtx        *sync.RWMutex
+	folUsecase FoldersUsecase
+	accounts   map[AccountIDType]*accountIndex
+}
+
+// NewSearchUsecase ctor, the index is empty until Rebuild
+func NewSearchUsecase(folUsecase FoldersUsecase) SearchUsecase {
+	return &searchUsecase{
+		mtx:        &sync.RWMutex{},
+		folUsecase: folUsecase,
+		accounts:   make(map[AccountIDType]*accountIndex),
+	}
+}
+
+// tokenize lower cases and splits on anything that isn't a letter or digit
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func msgTerms(msg MsgEntry) map[string]bool {
+	terms := make(map[string]bool)
+	fields := append([]string{msg.M.M.Subject, string(msg.M.M.Body), msg.M.M.SenderEmail}, msg.M.M.Recipients...)
+	for _, field := range fields {
+		for _, term := range tokenize(field) {
+			terms[term] = true
+		}
+	}
+	return terms
+}
+
+func (s *searchUsecase) OnFolderChange(ch FolderChange) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	ai, ok := s.accounts[ch.ID]
+	if !ok {
+		ai = &accountIndex{
+			docs:     make(map[MsgIDType]*searchDoc),
+			postings: make(map[string]map[MsgIDType]bool),
+		}
+		s.accounts[ch.ID] = ai
+	}
+
+	doc, ok := ai.docs[ch.Mid]
+	if !ok {
+		if ch.From != EnumNoFolder || ch.To == EnumNoFolder {
+			return // never saw it added, nothing to update
+		}
+		doc = &searchDoc{folders: make(map[int]bool), terms: msgTerms(ch.Msg)}
+		ai.docs[ch.Mid] = doc
+		for term := range doc.terms {
+			if ai.postings[term] == nil {
+				ai.postings[term] = make(map[MsgIDType]bool)
+			}
+			ai.postings[term][ch.Mid] = true
+		}
+	}
+
+	delete(doc.folders, ch.From)
+	if ch.To != EnumNoFolder {
+		doc.folders[ch.To] = true
+	}
+	if len(doc.folders) == 0 {
+		// Gone from every folder of the account
+		for term := range doc.terms {
+			delete(ai.postings[term], ch.Mid)
+			if len(ai.postings[term]) == 0 {
+				delete(ai.postings, term)
+			}
+		}
+		delete(ai.docs, ch.Mid)
+	}
+}
+
+func (s *searchUsecase) Rebuild() error {
+	s.mtx.Lock()
+	s.accounts = make(map[AccountIDType]*accountIndex)
+	s.mtx.Unlock()
+
+	return s.folUsecase.ForEachInAllAccounts(func(id AccountIDType, folderEnum int, msg MsgEntry) {
+		s.OnFolderChange(FolderChange{ID: id, Mid: MsgIDType(msg.Mid), From: EnumNoFolder, To: folderEnum, Msg: msg})
+	})
+}
+
+// searchQuery the parsed SearchParams.Query
+type searchQuery struct {
+	terms     []string
+	phrases   [][]string
+	from      []string
+	to        []string
+	before    time.Time
+	after     time.Time
+	isUnread  bool
+	isStarred bool
+}
+
+// splitQuery splits on spaces keeping double quoted parts together, quotes included
+func splitQuery(q string) []string {
+	parts := []string{}
+	var cur strings.Builder
+	inQuote := false
+	for _, r := range q {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			cur.WriteRune(r)
+		case unicode.IsSpace(r) && !inQuote:
+			if cur.Len() > 0 {
+				parts = append(parts, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		parts = append(parts, cur.String())
+	}
+	return parts
+}
+
+func parseSearchDate(val string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006/01/02"} {
+		if t, err := time.Parse(layout, val); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, NewEs(EsArgInvalid, fmt.Sprintf("date %s", val))
+}
+
+func parseSearchQuery(q string) (*searchQuery, error) {
+	sq := &searchQuery{}
+	for _, part := range splitQuery(q) {
+		if strings.HasPrefix(part, "\"") {
+			words := tokenize(part)
+			if len(words) > 0 {
+				sq.phrases = append(sq.phrases, words)
+				sq.terms = append(sq.terms, words...)
+			}
+			continue
+		}
+		op, val := "", part
+		if i := strings.Index(part, ":"); i > 0 {
+			op, val = strings.ToLower(part[:i]), part[i+1:]
+		}
+		var err error
+		switch op {
+		case "from":
+			sq.from = append(sq.from, strings.ToLower(val))
+		case "to":
+			sq.to = append(sq.to, strings.ToLower(val))
+		case "before":
+			sq.before, err = parseSearchDate(val)
+		case "after":
+			sq.after, err = parseSearchDate(val)
+		case "is":
+			switch strings.ToLower(val) {
+			case "unread":
+				sq.isUnread = true
+			case "starred":
+				sq.isStarred = true
+			default:
+				err = NewEs(EsArgInvalid, fmt.Sprintf("is:%s", val))
+			}
+		default:
+			// Not an operator, an address or a time say, search the words in it
+			sq.terms = append(sq.terms, tokenize(part)...)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sq, nil
+}
+
+// candidates the mids having all the terms, every mid in the account if there are none
+func (ai *accountIndex) candidates(terms []string) []MsgIDType {
+	if len(terms) == 0 {
+		out := make([]MsgIDType, 0, len(ai.docs))
+		for mid := range ai.docs {
+			out = append(out, mid)
+		}
+		return out
+	}
+	// Start from the rarest term
+	sort.Slice(terms, func(i, j int) bool { return len(ai.postings[terms[i]]) < len(ai.postings[terms[j]]) })
+	out := []MsgIDType{}
+	for mid := range ai.postings[terms[0]] {
+		hasAll := true
+		for _, term := range terms[1:] {
+			if !ai.postings[term][mid] {
+				hasAll = false
+				break
+			}
+		}
+		if hasAll {
+			out = append(out, mid)
+		}
+	}
+	return out
+}
+
+// searchFolder where to read the message's current entry from, the entry folders
+// carry the viewed/starred state so they're preferred
+func (doc *searchDoc) searchFolder() (int, bool) {
+	best, found := 0, false
+	for idx := range doc.folders {
+		if idx == EnumTrash || idx == EnumDrafts {
+			continue
+		}
+		if !found || (isEntryFolder(idx) && !isEntryFolder(best)) ||
+			(isEntryFolder(idx) == isEntryFolder(best) && idx < best) {
+			best, found = idx, true
+		}
+	}
+	return best, found
+}
+
+func hasPhrase(words []string, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		match := true
+		for j := range phrase {
+			if words[i+j] != phrase[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(vals []string, sub string) bool {
+	for _, val := range vals {
+		if strings.Contains(strings.ToLower(val), sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// msgTime when the message went out, or was created if it hasn't
+func msgTime(msg MsgEntry) time.Time {
+	if !msg.M.SentAt.IsZero() {
+		return msg.M.SentAt
+	}
+	return msg.M.M.CreatedAt
+}
+
+// matches checks the parts of the query the index doesn't cover
+func (sq *searchQuery) matches(folderEnum int, msg MsgEntry) bool {
+	if sq.isUnread && (!isEntryFolder(folderEnum) || msg.IsViewed) {
+		return false
+	}
+	if sq.isStarred && !msg.IsStarred {
+		return false
+	}
+	at := msgTime(msg)
+	if !sq.before.IsZero() && !at.Before(sq.before) {
+		return false
+	}
+	if !sq.after.IsZero() && at.Before(sq.after) {
+		return false
+	}
+	for _, from := range sq.from {
+		if !strings.Contains(strings.ToLower(msg.M.M.SenderEmail), from) {
+			return false
+		}
+	}
+	for _, to := range sq.to {
+		if !containsAny(msg.M.M.Recipients, to) {
+			return false
+		}
+	}
+	if len(sq.phrases) > 0 {
+		subject := tokenize(msg.M.M.Subject)
+		body := tokenize(string(msg.M.M.Body))
+		for _, phrase := range sq.phrases {
+			if !hasPhrase(subject, phrase) && !hasPhrase(body, phrase) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *searchUsecase) Search(id AccountIDType, sp SearchParams) (*SearchOutput, error) {
+	if sp.Limit < 0 || sp.Page < 0 {
+		return nil, NewEs(EsArgInvalid,
+			fmt.Sprintf("limit %d page %d", sp.Limit, sp.Page))
+	}
+	sq, err := parseSearchQuery(sp.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pick the candidates under the lock, the folders are read after it's released
+	type hit struct {
+		mid        MsgIDType
+		folderEnum int
+	}
+	hits := []hit{}
+	s.mtx.RLock()
+	if ai, ok := s.accounts[id]; ok {
+		for _, mid := range ai.candidates(sq.terms) {
+			if folderEnum, ok := ai.docs[mid].searchFolder(); ok {
+				hits = append(hits, hit{mid, folderEnum})
+			}
+		}
+	}
+	s.mtx.RUnlock()
+
+	found := []MsgEntry{}
+	for _, h := range hits {
+		msg, err := s.folUsecase.RetrieveFromFolder(h.folderEnum, id, h.mid)
+		if err != nil {
+			continue // moved or removed since
+		}
+		if sq.matches(h.folderEnum, *msg) {
+			found = append(found, *msg)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		ti, tj := msgTime(found[i]), msgTime(found[j])
+		if ti.Equal(tj) {
+			return found[i].Mid > found[j].Mid
+		}
+		return ti.After(tj)
+	})
+
+	pOut := &SearchOutput{
+		Requested: sp,
+		QueriedAt: time.Now(),
+		NumTotal:  len(found),
+		Elems:     []MsgEntry{},
+	}
+	start := sp.Page * sp.Limit
+	end := len(found)
+	if sp.Limit > 0 && start+sp.Limit < end {
+		end = start + sp.Limit
+	}
+	if start < len(found) {
+		pOut.Elems = found[start:end]
+	}
+	pOut.NumElems = len(pOut.Elems)
+	return pOut, nil
+}
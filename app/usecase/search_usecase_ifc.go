@@ -0,0 +1,43 @@
+package usecase
+
+import (
+	"time"
+)
+
+// SearchParams a query over all of an account's messages, the Trash and Drafts aren't searched.
+// The query is space separated, every part has to match:
+//
+//	word           the word appears in the subject, body, sender or recipients
+//	"some words"   the words appear together in that order in the subject or body
+//	from:text      the sender's address contains text
+//	to:text        one of the recipient addresses contains text
+//	before:date    sent before the date, 2006-01-02 (UTC)
+//	after:date     sent on or after the date
+//	is:unread      not viewed yet
+//	is:starred     starred
+type SearchParams struct {
+	Query string
+	Limit int
+	Page  int
+}
+
+// SearchOutput results newest first
+type SearchOutput struct {
+	Requested SearchParams
+	QueriedAt time.Time
+	NumTotal  int // matches across all pages
+	NumElems  int
+	Elems     []MsgEntry
+}
+
+// SearchUsecase full text search across an account's folders. The index is kept up to date
+// from the FoldersUsecase change notifications
+type SearchUsecase interface {
+	Search(id AccountIDType, sp SearchParams) (*SearchOutput, error)
+
+	// For use by the system
+	// OnFolderChange meets the FoldersUsecase.SubscribeChange signature
+	OnFolderChange(ch FolderChange)
+	// Rebuild drops the index and reads it back from the folders, called at bootup
+	Rebuild() error
+}
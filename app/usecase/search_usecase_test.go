@@ -0,0 +1,99 @@
+package usecase_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/git-sim/tc/app/usecase"
+)
+
+func TestSearch(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com", "carol@mail.com")
+	bob := ids[1]
+
+	send := func(from string, subject string, body string) usecase.MsgIDType {
+		mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			SenderEmail: from,
+			Recipients:  []string{"bob@mail.com"},
+			Subject:     subject,
+			Body:        []byte(body),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mid
+	}
+	// Messages sent before the index is built are picked up by the rebuild
+	invoice := send("alice@mail.com", "Invoice for March", "Please pay the invoice by Friday")
+	us := usecase.NewSearchUsecase(ts.fol)
+	if err := usecase.InitSearch(us, ts.fol); err != nil {
+		t.Fatal(err)
+	}
+	lunch := send("carol@mail.com", "Lunch", "pay day lunch on Friday?")
+	send("alice@mail.com", "Re: lunch", "Friday works")
+
+	search := func(q string) []usecase.MsgIDType {
+		out, err := us.Search(bob, usecase.SearchParams{Query: q, Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		mids := []usecase.MsgIDType{}
+		for _, e := range out.Elems {
+			mids = append(mids, usecase.MsgIDType(e.Mid))
+		}
+		return mids
+	}
+	expect := func(q string, want ...usecase.MsgIDType) {
+		got := search(q)
+		if len(got) != len(want) {
+			t.Errorf("%s: expected %v got %v", q, want, got)
+			return
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: expected %v got %v", q, want, got)
+				return
+			}
+		}
+	}
+
+	expect("invoice", invoice)
+	expect("INVOICE friday", invoice)
+	expect(`"pay the invoice"`, invoice)
+	expect(`"invoice pay"`)
+	expect("from:carol friday", lunch)
+	expect("to:bob pay", lunch, invoice)
+	expect("after:2000-01-01 before:2001-01-01")
+	expect("after:"+time.Now().UTC().Format("2006-01-02")+" invoice", invoice)
+
+	ts.fol.UpdateStarred(bob, lunch, true)
+	expect("is:starred", lunch)
+	ts.fol.UpdateViewed(bob, invoice, true)
+	expect("is:unread pay", lunch)
+
+	// Moves keep it searchable, the Trash isn't searched
+	if err := ts.fol.ArchiveMsg(bob, invoice); err != nil {
+		t.Fatal(err)
+	}
+	expect("invoice", invoice)
+	if err := ts.fol.DeleteMsg(bob, invoice); err != nil {
+		t.Fatal(err)
+	}
+	expect("invoice")
+	ts.fol.RestoreMsg(bob, invoice)
+	expect("invoice", invoice)
+
+	// Another account doesn't see bob's messages
+	if out, _ := us.Search(ids[2], usecase.SearchParams{Query: "invoice"}); out.NumTotal != 0 {
+		t.Errorf("carol expected no results got %d", out.NumTotal)
+	}
+	if _, err := us.Search(bob, usecase.SearchParams{Query: "is:important"}); !usecase.CheckEs(err, usecase.EsArgInvalid) {
+		t.Errorf("unknown is: expected EsArgInvalid got %v", err)
+	}
+
+	out, _ := us.Search(bob, usecase.SearchParams{Query: "friday", Limit: 2, Page: 1})
+	if out.NumTotal != 3 || out.NumElems != 1 {
+		t.Errorf("paging expected 3 total 1 returned got %d %d", out.NumTotal, out.NumElems)
+	}
+}
@@ -0,0 +1,189 @@
+package usecase
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/domain/repo"
+	"github.com/git-sim/tc/app/domain/service"
+)
+
+// AccountSettingsEntry what's stored per account in dbSettings, copy-on-write
+type AccountSettingsEntry struct {
+	S         AccountSettings
+	RepliedTo map[string]bool // senders already auto-replied to in this vacation window
+}
+
+type settingsUsecase struct {
+	mtx        *sync.Mutex // guards the read-modify-write of an account's settings
+	dbSettings repo.Store[entity.AccountIDType, AccountSettingsEntry]
+	service    *service.AccountService
+	clock      Clock // decides whether a vacation window is on
+
+	forwardSubscribers   []func(AccountIDType, MsgIDType, string)
+	autoReplySubscribers []func(*IngressMsg)
+}
+
+// NewSettingsUsecase ctor, a nil clock is the real one
+func NewSettingsUsecase(dbSettings repo.Store[entity.AccountIDType, AccountSettingsEntry], service *service.AccountService,
+	clock Clock) SettingsUsecase {
+	if clock == nil {
+		clock = NewRealClock()
+	}
+	return &settingsUsecase{
+		mtx:        &sync.Mutex{},
+		dbSettings: dbSettings,
+		service:    service,
+		clock:      clock,
+	}
+}
+
+// getAccountSettings an account that never saved settings has the defaults
+func (u *settingsUsecase) getAccountSettings(id AccountIDType) (AccountSettingsEntry, error) {
+	as, err := u.dbSettings.Retrieve(entity.AccountIDType(id))
+	if CheckEs(err, EsNotFound) {
+		return AccountSettingsEntry{}, nil
+	}
+	return as, err
+}
+
+func (u *settingsUsecase) putAccountSettings(id AccountIDType, as AccountSettingsEntry) error {
+	return u.dbSettings.Update(entity.AccountIDType(id), as)
+}
+
+func (u *settingsUsecase) GetSettings(id AccountIDType) (*AccountSettings, error) {
+	if !u.service.AlreadyExistsByID(entity.AccountIDType(id)) {
+		return nil, NewEs(EsNotFound, fmt.Sprintf("Account with id %s", AccountIDToString(id)))
+	}
+	as, err := u.getAccountSettings(id)
+	if err != nil {
+		return nil, err
+	}
+	return &as.S, nil
+}
+
+func sameVacation(a VacationSettings, b VacationSettings) bool {
+	return a.On == b.On && a.Start.Equal(b.Start) && a.End.Equal(b.End) &&
+		a.Subject == b.Subject && a.Message == b.Message
+}
+
+func (u *settingsUsecase) UpdateSettings(id AccountIDType, settings AccountSettings) error {
+	email, err := u.service.GetEmailFromID(entity.AccountIDType(id))
+	if err != nil {
+		return NewEs(EsNotFound, fmt.Sprintf("Account with id %s", AccountIDToString(id)))
+	}
+	if settings.ForwardTo != "" {
+		if !IsValidEmailStr(settings.ForwardTo) {
+			return NewEs(EsArgInvalid, "ForwardTo email format")
+		}
+		if strings.EqualFold(settings.ForwardTo, email) {
+			return NewEs(EsArgInvalid, "ForwardTo is the account's own address")
+		}
+	}
+	vac := settings.Vacation
+	if !vac.Start.IsZero() && !vac.End.IsZero() && !vac.End.After(vac.Start) {
+		return NewEs(EsArgInvalid, "Vacation End has to be later than Start")
+	}
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	as, err := u.getAccountSettings(id)
+	if err != nil {
+		return err
+	}
+	newAs := AccountSettingsEntry{S: settings, RepliedTo: as.RepliedTo}
+	if !sameVacation(as.S.Vacation, vac) {
+		newAs.RepliedTo = nil // a new window
+	}
+	return u.putAccountSettings(id, newAs)
+}
+
+// active the vacation window covers now
+func (vac *VacationSettings) active(now time.Time) bool {
+	if !vac.On {
+		return false
+	}
+	if !vac.Start.IsZero() && now.Before(vac.Start) {
+		return false
+	}
+	if !vac.End.IsZero() && !now.Before(vac.End) {
+		return false
+	}
+	return true
+}
+
+func (u *settingsUsecase) OnInboxDelivery(id AccountIDType, msg MsgEntry) {
+	// Mail the system sent automatically is left alone, that's what keeps two
+	// forwarding or vacationing accounts from sending to each other forever
+	if msg.M.Auto || AccountIDType(msg.M.SenderID) == id {
+		return
+	}
+	email, err := u.service.GetEmailFromID(entity.AccountIDType(id))
+	if err != nil {
+		return
+	}
+
+	u.mtx.Lock()
+	as, err := u.getAccountSettings(id)
+	if err != nil {
+		u.mtx.Unlock()
+		log.Printf("settings of account %s: %v", AccountIDToString(id), err)
+		return
+	}
+	var reply *IngressMsg
+	sender := strings.ToLower(msg.M.M.SenderEmail)
+	if as.S.Vacation.active(u.clock.Now()) && !as.RepliedTo[sender] && !strings.EqualFold(sender, email) {
+		newAs := AccountSettingsEntry{S: as.S, RepliedTo: map[string]bool{sender: true}}
+		for k := range as.RepliedTo {
+			newAs.RepliedTo[k] = true
+		}
+		if err := u.putAccountSettings(id, newAs); err == nil {
+			reply = u.vacationReply(email, msg, as.S.Vacation)
+		}
+	}
+	u.mtx.Unlock()
+
+	if as.S.ForwardTo != "" {
+		for _, fn := range u.forwardSubscribers {
+			fn(id, MsgIDType(msg.Mid), as.S.ForwardTo)
+		}
+	}
+	if reply != nil {
+		for _, fn := range u.autoReplySubscribers {
+			fn(reply)
+		}
+	}
+}
+
+// vacationReply a reply in the same thread as the incoming message
+func (u *settingsUsecase) vacationReply(email string, msg MsgEntry, vac VacationSettings) *IngressMsg {
+	subject := vac.Subject
+	if subject == "" {
+		subject = msg.M.M.Subject
+		if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+			subject = "Re: " + subject
+		}
+	}
+	return &IngressMsg{
+		ParentMid:   msg.Mid,
+		SenderEmail: email,
+		Recipients:  []string{msg.M.M.SenderEmail},
+		Subject:     subject,
+		Body:        []byte(vac.Message),
+	}
+}
+
+// SubscribeForward same simple pub-sub as the AccountService, subscribe at bootup
+func (u *settingsUsecase) SubscribeForward(fn func(AccountIDType, MsgIDType, string)) {
+	u.forwardSubscribers = append(u.forwardSubscribers, fn)
+}
+
+// SubscribeAutoReply subscribe at bootup
+func (u *settingsUsecase) SubscribeAutoReply(fn func(*IngressMsg)) {
+	u.autoReplySubscribers = append(u.autoReplySubscribers, fn)
+}
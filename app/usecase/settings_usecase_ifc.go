@@ -0,0 +1,37 @@
+package usecase
+
+import (
+	"time"
+)
+
+// VacationSettings the out-of-office auto-responder. Each sender gets at most one reply
+// per window, changing the settings starts a new window
+type VacationSettings struct {
+	On      bool
+	Start   time.Time // zero means from now
+	End     time.Time // zero means until turned off
+	Subject string    // Def "Re: " + the incoming subject
+	Message string
+}
+
+// AccountSettings per account settings acting on incoming mail
+type AccountSettings struct {
+	ForwardTo string // every incoming message is also forwarded here, empty is off
+	Vacation  VacationSettings
+}
+
+// SettingsUsecase account settings, forwarding and the vacation auto-reply.
+// Neither forwards nor replies to mail the system sent automatically, or to the account itself
+type SettingsUsecase interface {
+	GetSettings(id AccountIDType) (*AccountSettings, error)
+	UpdateSettings(id AccountIDType, settings AccountSettings) error
+
+	// For use by the system
+	// OnInboxDelivery called after a message is delivered to the account, meets the
+	// RulesUsecase.SubscribeDelivered signature
+	OnInboxDelivery(id AccountIDType, msg MsgEntry)
+	// SubscribeForward fn is called to forward a delivered message
+	SubscribeForward(fn func(id AccountIDType, mid MsgIDType, to string))
+	// SubscribeAutoReply fn is called with the reply to send
+	SubscribeAutoReply(fn func(reply *IngressMsg))
+}
@@ -0,0 +1,98 @@
+package usecase_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/git-sim/tc/app/usecase"
+)
+
+func TestVacationAutoReply(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com", "carol@mail.com")
+	alice, bob := ids[0], ids[1]
+
+	send := func(from string, to string) {
+		if _, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			SenderEmail: from,
+			Recipients:  []string{to},
+			Subject:     "hi",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vacation := usecase.VacationSettings{On: true, End: ts.clock.Now().Add(time.Hour), Message: "away"}
+	if err := ts.settings.UpdateSettings(bob, usecase.AccountSettings{Vacation: vacation}); err != nil {
+		t.Fatal(err)
+	}
+
+	// One reply per sender per window
+	send("alice@mail.com", "bob@mail.com")
+	send("alice@mail.com", "bob@mail.com")
+	send("bob@mail.com", "bob@mail.com")
+	if n := ts.count(t, alice, usecase.EnumInbox); n != 1 {
+		t.Errorf("alice expected 1 auto reply got %d", n)
+	}
+	if n := ts.count(t, bob, usecase.EnumSent); n != 2 {
+		t.Errorf("bob expected the note to self and 1 reply sent got %d", n)
+	}
+
+	// Both away, alice's auto reply doesn't get one back even in bob's new window
+	if err := ts.settings.UpdateSettings(alice, usecase.AccountSettings{Vacation: vacation}); err != nil {
+		t.Fatal(err)
+	}
+	vacation.Message = "still away"
+	ts.settings.UpdateSettings(bob, usecase.AccountSettings{Vacation: vacation})
+	send("bob@mail.com", "alice@mail.com")
+	if n := ts.count(t, bob, usecase.EnumInbox); n != 4 {
+		t.Errorf("bob expected alice's auto reply got %d in inbox", n)
+	}
+	if n := ts.count(t, bob, usecase.EnumSent); n != 3 {
+		t.Errorf("bob replied to an auto reply, %d sent", n)
+	}
+	send("carol@mail.com", "bob@mail.com")
+
+	// Outside the window nothing goes out, the window is on the settings' clock
+	vacation.Start = ts.clock.Now().Add(time.Hour)
+	vacation.End = ts.clock.Now().Add(2 * time.Hour)
+	ts.settings.UpdateSettings(bob, usecase.AccountSettings{Vacation: vacation})
+	send("carol@mail.com", "bob@mail.com")
+	if n := ts.count(t, ids[2], usecase.EnumInbox); n != 1 {
+		t.Errorf("carol expected only the first reply got %d", n)
+	}
+	ts.clock.Advance(90 * time.Minute)
+	send("carol@mail.com", "bob@mail.com")
+	if n := ts.count(t, ids[2], usecase.EnumInbox); n != 2 {
+		t.Errorf("carol expected a reply inside the window got %d", n)
+	}
+}
+
+func TestForwardAllMail(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com", "carol@mail.com")
+	bob, carol := ids[1], ids[2]
+
+	if err := ts.settings.UpdateSettings(bob, usecase.AccountSettings{ForwardTo: "BOB@mail.com"}); !usecase.CheckEs(err, usecase.EsArgInvalid) {
+		t.Errorf("forward to self expected EsArgInvalid got %v", err)
+	}
+	// Forwarding both ways doesn't loop
+	ts.settings.UpdateSettings(bob, usecase.AccountSettings{ForwardTo: "carol@mail.com"})
+	ts.settings.UpdateSettings(carol, usecase.AccountSettings{ForwardTo: "bob@mail.com"})
+	if _, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+		SenderEmail: "alice@mail.com",
+		Recipients:  []string{"bob@mail.com"},
+		Subject:     "hi",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count(t, bob, usecase.EnumInbox); n != 1 {
+		t.Errorf("bob keeps his copy, expected 1 got %d", n)
+	}
+	if n := ts.count(t, carol, usecase.EnumInbox); n != 1 {
+		t.Errorf("carol expected 1 forward got %d", n)
+	}
+	settings, _ := ts.settings.GetSettings(bob)
+	if settings.ForwardTo != "carol@mail.com" {
+		t.Errorf("settings not stored %+v", settings)
+	}
+}
@@ -0,0 +1,180 @@
+package usecase
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type threadsUsecase struct {
+	folUsecase FoldersUsecase
+}
+
+// NewThreadsUsecase ctor
+func NewThreadsUsecase(folUsecase FoldersUsecase) ThreadsUsecase {
+	return &threadsUsecase{
+		folUsecase: folUsecase,
+	}
+}
+
+// threadAccum gathers the messages of one thread while walking the folders
+type threadAccum struct {
+	info     ThreadInfo
+	mids     map[MsgIDType]bool
+	latestAt time.Time
+}
+
+// collect groups everything in the account's folders by thread, the Trash and Drafts are left out. A message
+// sent to yourself is in both Sent and Inbox, it's only counted once.
+func (u *threadsUsecase) collect(id AccountIDType) (map[ThreadIDType]*threadAccum, error) {
+	threads := make(map[ThreadIDType]*threadAccum)
+	err := u.folUsecase.ForEachInAccount(id, func(folderEnum int, msg MsgEntry) {
+		if folderEnum == EnumTrash || folderEnum == EnumDrafts {
+			return
+		}
+		tid := ThreadIDType(msg.M.Tid)
+		acc, ok := threads[tid]
+		if !ok {
+			acc = &threadAccum{
+				info: ThreadInfo{Tid: tid},
+				mids: make(map[MsgIDType]bool),
+			}
+			threads[tid] = acc
+		}
+		mid := MsgIDType(msg.Mid)
+		if !acc.mids[mid] {
+			acc.mids[mid] = true
+			acc.info.MessageID = append(acc.info.MessageID, mid)
+		}
+		// Only received messages have a viewed state
+		if isEntryFolder(folderEnum) && !msg.IsViewed {
+			acc.info.NumUnviewed++
+		}
+		at := msg.M.SentAt
+		if at.IsZero() {
+			at = msg.M.M.ScheduledAt
+		}
+		if at.After(acc.latestAt) {
+			acc.latestAt = at
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for tid, acc := range threads {
+		sort.Slice(acc.info.MessageID, func(i, j int) bool {
+			return acc.info.MessageID[i] < acc.info.MessageID[j]
+		})
+		acc.info.NumTotalMsgsInThread = len(acc.info.MessageID)
+		acc.info.IsMuted, err = u.folUsecase.IsThreadMuted(id, tid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return threads, nil
+}
+
+func (u *threadsUsecase) ListThreads(id AccountIDType, limit int, offset int) (*ThreadListOutput, error) {
+	if limit < 0 || offset < 0 {
+		return nil, NewEs(EsArgInvalid,
+			fmt.Sprintf("limit %d offset %d", limit, offset))
+	}
+	threads, err := u.collect(id)
+	if err != nil {
+		return nil, err
+	}
+
+	accs := make([]*threadAccum, 0, len(threads))
+	for _, acc := range threads {
+		accs = append(accs, acc)
+	}
+	sort.Slice(accs, func(i, j int) bool {
+		if accs[i].latestAt.Equal(accs[j].latestAt) {
+			return accs[i].info.Tid > accs[j].info.Tid
+		}
+		return accs[i].latestAt.After(accs[j].latestAt)
+	})
+
+	pOut := &ThreadListOutput{
+		TotalNumberOfThreads: len(accs),
+		Threads:              []ThreadInfo{},
+	}
+	if offset >= len(accs) {
+		return pOut, nil
+	}
+	end := len(accs)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	for _, acc := range accs[offset:end] {
+		pOut.Threads = append(pOut.Threads, acc.info)
+	}
+	pOut.NumRet = len(pOut.Threads)
+	return pOut, nil
+}
+
+func (u *threadsUsecase) GetThread(id AccountIDType, tid ThreadIDType) (*ThreadInfo, error) {
+	threads, err := u.collect(id)
+	if err != nil {
+		return nil, err
+	}
+	acc, ok := threads[tid]
+	if !ok {
+		return nil, NewEs(EsNotFound,
+			fmt.Sprintf("Thread with id %s", ThreadIDToString(tid)))
+	}
+	return &acc.info, nil
+}
+
+// MuteThread only a thread with messages in the account's folders can be muted, same as GetThread finds
+func (u *threadsUsecase) MuteThread(id AccountIDType, tid ThreadIDType, muted bool) error {
+	found := false
+	err := u.folUsecase.ForEachInAccount(id, func(folderEnum int, msg MsgEntry) {
+		if ThreadIDType(msg.M.Tid) == tid {
+			found = true
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return NewEs(EsNotFound,
+			fmt.Sprintf("Thread with id %s", ThreadIDToString(tid)))
+	}
+	return u.folUsecase.SetThreadMuted(id, tid, muted)
+}
+
+func (u *threadsUsecase) DeleteThread(id AccountIDType, tid ThreadIDType) error {
+	type folderMsg struct {
+		folderEnum int
+		mid        MsgIDType
+	}
+	toDelete := []folderMsg{}
+	err := u.folUsecase.ForEachInAccount(id, func(folderEnum int, msg MsgEntry) {
+		if ThreadIDType(msg.M.Tid) == tid && folderEnum != EnumScheduled &&
+			folderEnum != EnumTrash && folderEnum != EnumDrafts {
+			toDelete = append(toDelete, folderMsg{folderEnum, MsgIDType(msg.Mid)})
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(toDelete) == 0 {
+		return NewEs(EsNotFound,
+			fmt.Sprintf("Thread with id %s", ThreadIDToString(tid)))
+	}
+	for _, fm := range toDelete {
+		var err error
+		if isEntryFolder(fm.folderEnum) {
+			// Received messages go to the Trash like any other delete
+			err = u.folUsecase.DeleteMsg(id, fm.mid)
+		} else {
+			err = u.folUsecase.RemoveFromFolder(fm.folderEnum, id, fm.mid)
+		}
+		if err != nil && !CheckEs(err, EsNotFound) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package usecase
+
+// ThreadInfo summary of a thread as seen from one account's folders
+type ThreadInfo struct {
+	Tid                  ThreadIDType
+	IsMuted              bool
+	NumTotalMsgsInThread int
+	NumUnviewed          int
+	MessageID            []MsgIDType // oldest first
+}
+
+// ThreadListOutput result of ListThreads, threads with the most recent activity come first
+type ThreadListOutput struct {
+	TotalNumberOfThreads int
+	NumRet               int
+	Threads              []ThreadInfo
+}
+
+// ThreadsUsecase presents the messages in an account's folders grouped by thread
+type ThreadsUsecase interface {
+	// limit 0 means all
+	ListThreads(id AccountIDType, limit int, offset int) (*ThreadListOutput, error)
+	GetThread(id AccountIDType, tid ThreadIDType) (*ThreadInfo, error)
+	MuteThread(id AccountIDType, tid ThreadIDType, muted bool) error
+	// Moves the thread's received messages to the Trash and removes the sent copies,
+	// scheduled messages must be cancelled instead
+	DeleteThread(id AccountIDType, tid ThreadIDType) error
+}
@@ -0,0 +1,146 @@
+package usecase_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/git-sim/tc/app/domain/entity"
+	"github.com/git-sim/tc/app/usecase"
+)
+
+func TestThreadsUsecase(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+	ut := usecase.NewThreadsUsecase(ts.fol)
+
+	send := func(from string, to string, parent usecase.MsgIDType) usecase.MsgIDType {
+		mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			ParentMid:   entity.MsgIDType(parent),
+			SenderEmail: from,
+			Recipients:  []string{to},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mid
+	}
+	root := send("alice@mail.com", "bob@mail.com", 0)
+	reply := send("bob@mail.com", "alice@mail.com", root)
+	send("alice@mail.com", "bob@mail.com", 0)
+
+	rootMsg, _ := ts.msg.RetrieveMsg(root)
+	tid := usecase.ThreadIDType(rootMsg.Tid)
+
+	// alice sent root and received reply
+	info, err := ut.GetThread(ids[0], tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.NumTotalMsgsInThread != 2 || info.NumUnviewed != 1 {
+		t.Errorf("expected 2 msgs 1 unviewed got %d %d", info.NumTotalMsgsInThread, info.NumUnviewed)
+	}
+	if info.MessageID[0] != root || info.MessageID[1] != reply {
+		t.Errorf("thread messages out of order %v", info.MessageID)
+	}
+
+	list, err := ut.ListThreads(ids[1], 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.TotalNumberOfThreads != 2 || list.NumRet != 1 {
+		t.Errorf("expected 2 threads 1 returned got %d %d", list.TotalNumberOfThreads, list.NumRet)
+	}
+
+	if err := ut.MuteThread(ids[0], tid, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ut.MuteThread(ids[0], tid+1000, true); !usecase.CheckEs(err, usecase.EsNotFound) {
+		t.Errorf("unknown thread expected EsNotFound got %v", err)
+	}
+	if info, _ := ut.GetThread(ids[0], tid); !info.IsMuted {
+		t.Error("thread not muted")
+	}
+	if info, _ := ut.GetThread(ids[1], tid); info.IsMuted {
+		t.Error("mute leaked to the other account")
+	}
+
+	if err := ut.DeleteThread(ids[0], tid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ut.GetThread(ids[0], tid); !usecase.CheckEs(err, usecase.EsNotFound) {
+		t.Errorf("deleted thread expected EsNotFound got %v", err)
+	}
+	if _, err := ut.GetThread(ids[1], tid); err != nil {
+		t.Errorf("delete removed bob's copy %v", err)
+	}
+}
+
+func TestMutedThreadArrivesViewed(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com", "bob@mail.com")
+
+	send := func(parent usecase.MsgIDType) usecase.MsgIDType {
+		mid, err := ts.msg.EnqueueMsg(&usecase.IngressMsg{
+			ParentMid:   entity.MsgIDType(parent),
+			SenderEmail: "alice@mail.com",
+			Recipients:  []string{"bob@mail.com"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mid
+	}
+	unviewed := func() int {
+		out, err := ts.fol.QueryMsgs(ids[1], usecase.QueryParams{FolderIdx: usecase.EnumInbox, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.NumUnviewed
+	}
+
+	root := send(0)
+	send(0)
+	if n := unviewed(); n != 2 {
+		t.Fatalf("unviewed expected 2 got %d", n)
+	}
+
+	rootMsg, _ := ts.msg.RetrieveMsg(root)
+	if err := ts.fol.SetThreadMuted(ids[1], usecase.ThreadIDType(rootMsg.Tid), true); err != nil {
+		t.Fatal(err)
+	}
+	// The unread root no longer counts, and the new reply arrives viewed
+	if n := unviewed(); n != 1 {
+		t.Errorf("unviewed after mute expected 1 got %d", n)
+	}
+	send(root)
+	if n := unviewed(); n != 1 {
+		t.Errorf("unviewed after muted reply expected 1 got %d", n)
+	}
+	out, _ := ts.fol.QueryMsgs(ids[1], usecase.QueryParams{FolderIdx: usecase.EnumInbox, Limit: 10})
+	if out.NumTotal != 3 {
+		t.Errorf("muted reply should still be delivered, total %d", out.NumTotal)
+	}
+}
+
+func TestMuteConcurrent(t *testing.T) {
+	ts := newTestSystem(t)
+	ids := ts.register(t, "alice@mail.com")
+
+	// Racing mutes and unmutes of the same thread all succeed
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(muted bool) {
+			defer wg.Done()
+			if err := ts.fol.SetThreadMuted(ids[0], 7, muted); err != nil {
+				errs <- err
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
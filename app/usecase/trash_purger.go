@@ -0,0 +1,32 @@
+package usecase
+
+import (
+	"log"
+	"time"
+)
+
+// StartTrashPurger every interval removes the Trash entries older than retention from all
+// accounts. Returns the func that stops it
+func StartTrashPurger(folUsecase FoldersUsecase, clock Clock, retention time.Duration,
+	interval time.Duration) (stop func()) {
+	if clock == nil {
+		clock = NewRealClock()
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-clock.After(interval):
+				n, err := folUsecase.PurgeTrash(clock.Now().Add(-retention))
+				if err != nil {
+					log.Printf("trash purger: %s", err.Error())
+				} else if n > 0 {
+					log.Printf("trash purger: removed %d msgs", n)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}